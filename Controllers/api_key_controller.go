@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+	"time"
+)
+
+// apiKeyRequest is the body of POST /api_keys.
+type apiKeyRequest struct {
+	Name           string   `json:"name"`
+	OrganizationID *int     `json:"organization_id"`
+	Roles          []string `json:"roles"`
+}
+
+// CreateAPIKey creates an API key for a service. The secret is only part of
+// this response; just its hash is kept. Keys created under an organization
+// scope belong to that organization, others to the organization_id given,
+// or to the platform when there is none. Callers may only grant roles whose
+// permissions they hold themselves.
+func CreateAPIKey(keys store.APIKeyStore, organizations store.OrganizationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermAPIKeysManage) {
+			return
+		}
+
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		key := models.APIKey{Name: strings.TrimSpace(req.Name), OrganizationID: req.OrganizationID, Roles: req.Roles}
+		if key.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if len(key.Roles) == 0 {
+			http.Error(w, "roles is required", http.StatusBadRequest)
+			return
+		}
+		roles, err := auth.ParseRoles(key.Roles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, role := range roles {
+			if err := auth.AuthorizeGrant(r.Context(), role); err != nil {
+				writeForbidden(w, err)
+				return
+			}
+		}
+		if scope := store.OrganizationFrom(r.Context()); scope != 0 && key.OrganizationID != nil && *key.OrganizationID != scope {
+			http.Error(w, "organization_id must be the organization of the request", http.StatusForbidden)
+			return
+		}
+		if key.OrganizationID == nil && store.OrganizationFrom(r.Context()) == 0 && !auth.HasPlatformRole(roles) {
+			http.Error(w, "organization_id is required unless roles include "+string(auth.RolePlatformAdmin), http.StatusBadRequest)
+			return
+		}
+		if key.OrganizationID != nil {
+			_, err := organizations.Get(r.Context(), *key.OrganizationID)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				http.Error(w, "Organization not found", http.StatusUnprocessableEntity)
+				return
+			case err != nil:
+				log.Printf("Database error: %v", err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		secret, prefix, hash, err := auth.GenerateAPIKey()
+		if err != nil {
+			log.Printf("Generating API key: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		key.Prefix, key.Hash = prefix, hash
+		if err := keys.Create(r.Context(), &key); err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"api_key": key,
+			"secret":  secret,
+		})
+	}
+}
+
+// GetAPIKeys lists the API keys, without their secrets
+func GetAPIKeys(keys store.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermAPIKeysManage) {
+			return
+		}
+
+		list, err := keys.List(r.Context())
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// RevokeAPIKey revokes an API key; requests made with it fail from then on
+func RevokeAPIKey(keys store.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermAPIKeysManage) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		key, err := keys.Revoke(r.Context(), id, time.Now())
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(key)
+	}
+}
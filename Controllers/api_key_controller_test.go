@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	for _, name := range []string{"Acme", "Globex"} {
+		assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: name}))
+	}
+	r := mux.NewRouter()
+	r.Use(OrganizationScope)
+	r.HandleFunc("/api_keys", CreateAPIKey(s.APIKeys(), s.Organizations())).Methods("POST")
+	r.HandleFunc("/api_keys", GetAPIKeys(s.APIKeys())).Methods("GET")
+	r.HandleFunc("/api_keys/{id}", RevokeAPIKey(s.APIKeys())).Methods("DELETE")
+
+	do := func(organization, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if organization != "" {
+			req.Header.Set(OrganizationHeader, organization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("create", func(t *testing.T) {
+		w := do("", "POST", "/api_keys", `{"name": "ci", "roles": ["platform_admin"]}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		var created struct {
+			APIKey map[string]interface{} `json:"api_key"`
+			Secret string                 `json:"secret"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+		assert.True(t, strings.HasPrefix(created.Secret, created.APIKey["prefix"].(string)))
+		assert.Nil(t, created.APIKey["organization_id"], "platform key")
+		assert.NotContains(t, created.APIKey, "hash")
+
+		key, err := s.APIKeys().FindByHash(ctx, auth.HashAPIKey(created.Secret))
+		assert.NoError(t, err, "only the hash is stored")
+		assert.Equal(t, 1, key.ID)
+
+		assert.Equal(t, http.StatusCreated, do("1", "POST", "/api_keys", `{"name": "acme-sync", "roles": ["service"]}`).Code)
+		assert.Equal(t, http.StatusCreated, do("", "POST", "/api_keys", `{"name": "globex-sync", "roles": ["service"], "organization_id": 2}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("", "POST", "/api_keys", `{"name": " ", "roles": ["service"]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("", "POST", "/api_keys", `{"name": "x", "roles": ["service"]}`).Code, "only platform keys may lack an organization")
+		assert.Equal(t, http.StatusForbidden, do("1", "POST", "/api_keys", `{"name": "x", "roles": ["service"], "organization_id": 2}`).Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, do("", "POST", "/api_keys", `{"name": "x", "roles": ["service"], "organization_id": 9}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("", "POST", "/api_keys", `{"name": "x"}`).Code, "roles are required")
+		assert.Equal(t, http.StatusBadRequest, do("", "POST", "/api_keys", `{"name": "x", "roles": ["root"]}`).Code)
+	})
+
+	t.Run("grant", func(t *testing.T) {
+		create := CreateAPIKey(s.APIKeys(), s.Organizations())
+		orgAdmin := auth.Principal{Kind: auth.KindUser, UserID: 7, OrganizationID: 1, Roles: []auth.Role{auth.RoleOrgAdmin}}
+		grant := func(principal auth.Principal, roles string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("POST", "/api_keys", strings.NewReader(`{"name": "x", "roles": `+roles+`}`))
+			req = req.WithContext(auth.WithPrincipal(store.WithOrganization(req.Context(), 1), principal))
+			w := httptest.NewRecorder()
+			create(w, req)
+			return w
+		}
+
+		assert.Equal(t, http.StatusCreated, grant(orgAdmin, `["service", "org_admin"]`).Code)
+		w := grant(orgAdmin, `["billing_admin"]`)
+		assert.Equal(t, http.StatusForbidden, w.Code, "org admins cannot hand out billing powers")
+		assert.Equal(t, "subscriptions:write", w.Header().Get("X-Missing-Permission"))
+
+		user := auth.Principal{Kind: auth.KindUser, UserID: 8, OrganizationID: 1, Roles: []auth.Role{auth.RoleUser}}
+		w = grant(user, `["user"]`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "missing permission api_keys:manage")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var keys []models.APIKey
+		assert.NoError(t, json.NewDecoder(do("", "GET", "/api_keys", "").Body).Decode(&keys))
+		assert.Len(t, keys, 4)
+
+		assert.NoError(t, json.NewDecoder(do("1", "GET", "/api_keys", "").Body).Decode(&keys))
+		if assert.Len(t, keys, 2) {
+			assert.Equal(t, "acme-sync", keys[0].Name)
+			assert.Equal(t, []string{"service", "org_admin"}, keys[1].Roles)
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do("1", "DELETE", "/api_keys/3", "").Code, "other organizations' keys")
+		w := do("2", "DELETE", "/api_keys/3", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var key models.APIKey
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&key))
+		assert.NotNil(t, key.RevokedAt)
+		assert.Equal(t, http.StatusNotFound, do("", "DELETE", "/api_keys/9", "").Code)
+	})
+}
+
+func TestOrganizationScopeFromPrincipal(t *testing.T) {
+	handler := OrganizationScope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(store.OrganizationFrom(r.Context()))
+	}))
+	do := func(principal auth.Principal, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		if header != "" {
+			req.Header.Set(OrganizationHeader, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	member := auth.Principal{Kind: auth.KindUser, Subject: "7", UserID: 7, OrganizationID: 2}
+	assert.Equal(t, "2\n", do(member, "").Body.String())
+	assert.Equal(t, "2\n", do(member, "2").Body.String())
+	assert.Equal(t, http.StatusForbidden, do(member, "1").Code, "members cannot act for other organizations")
+
+	platform := auth.Principal{Kind: auth.KindService, Subject: "api_key:1", APIKeyID: 1}
+	assert.Equal(t, "1\n", do(platform, "1").Body.String())
+	assert.Equal(t, "0\n", do(platform, "").Body.String())
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"subscriptions/auth"
+)
+
+// authorize checks that the caller has permission. It writes a 403 response
+// naming the missing permission and returns false when they do not.
+func authorize(w http.ResponseWriter, r *http.Request, permission auth.Permission) bool {
+	if err := auth.Authorize(r.Context(), permission); err != nil {
+		writeForbidden(w, err)
+		return false
+	}
+	return true
+}
+
+// authorizeOwn is authorize for reads that callers holding only own may
+// make about themselves. It returns the user they are limited to, or 0
+// when they may read everyone's.
+func authorizeOwn(w http.ResponseWriter, r *http.Request, all, own auth.Permission) (int, bool) {
+	if auth.Authorize(r.Context(), all) == nil {
+		return 0, true
+	}
+	principal, _ := auth.PrincipalFrom(r.Context())
+	if !principal.Has(own) || principal.UserID == 0 {
+		writeForbidden(w, &auth.PermissionError{Permission: all})
+		return 0, false
+	}
+	return principal.UserID, true
+}
+
+// authorizeSelf checks that the caller is a user allowed to read own about
+// themselves, or all about anyone, and returns the user. It writes a 401
+// response when there is no caller and a 403 when it is not a user.
+func authorizeSelf(w http.ResponseWriter, r *http.Request, own, all auth.Permission) (int, bool) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return 0, false
+	}
+	if principal.UserID == 0 {
+		http.Error(w, "Forbidden: the caller is not a user", http.StatusForbidden)
+		return 0, false
+	}
+	if !principal.Has(own) && !principal.Has(all) {
+		writeForbidden(w, &auth.PermissionError{Permission: own})
+		return 0, false
+	}
+	return principal.UserID, true
+}
+
+func writeForbidden(w http.ResponseWriter, err error) {
+	var missing *auth.PermissionError
+	if errors.As(err, &missing) {
+		w.Header().Set("X-Missing-Permission", string(missing.Permission))
+	}
+	http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+}
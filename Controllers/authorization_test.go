@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolePolicies(t *testing.T) {
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(context.Background(), &models.Organization{Name: "Acme"}))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions", CreateSubscription(s.Subscriptions(), s.Organizations(), nil)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}", UpdateSubscription(s.Subscriptions(), nil)).Methods("PUT")
+	r.HandleFunc("/subscriptions/{id}/change_plan", ChangeSubscriptionPlan(s.Subscriptions(), nil)).Methods("POST")
+	r.HandleFunc("/user_subscriptions", GetUserSubscriptions(s.UserSubscriptions(), nil)).Methods("GET")
+	r.HandleFunc("/user_subscriptions/{id}", GetUserSubscriptionByID(s.UserSubscriptions())).Methods("GET")
+	r.HandleFunc("/user_subscriptions", CreateUserSubscription(s.UserSubscriptions())).Methods("POST")
+
+	as := func(role auth.Role, userID int, method, target, body string) *httptest.ResponseRecorder {
+		principal := auth.Principal{Kind: auth.KindUser, UserID: userID, OrganizationID: 1, Roles: []auth.Role{role}}
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(auth.WithPrincipal(store.WithOrganization(req.Context(), 1), principal))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("billing admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, as(auth.RoleBillingAdmin, 1, "POST", "/subscriptions", `{"name": "Team", "product_id": 101, "license_count": 1}`).Code)
+		assert.Equal(t, http.StatusOK, as(auth.RoleBillingAdmin, 1, "PUT", "/subscriptions/1", `{"name": "Team"}`).Code)
+		assert.Equal(t, http.StatusCreated, as(auth.RoleBillingAdmin, 1, "POST", "/subscriptions/1/change_plan", `{"license_count": 3}`).Code)
+
+		w := as(auth.RoleBillingAdmin, 1, "POST", "/user_subscriptions", `{"user_id": 7, "subscription_id": 1}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "Forbidden: missing permission seats:write\n", w.Body.String())
+	})
+
+	t.Run("org admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, as(auth.RoleOrgAdmin, 2, "POST", "/user_subscriptions", `{"user_id": 7, "subscription_id": 1}`).Code)
+		assert.Equal(t, http.StatusOK, as(auth.RoleOrgAdmin, 2, "POST", "/user_subscriptions", `{"user_id": 8, "subscription_id": 1}`).Code)
+
+		w := as(auth.RoleOrgAdmin, 2, "POST", "/subscriptions/1/change_plan", `{"license_count": 9}`)
+		assert.Equal(t, http.StatusForbidden, w.Code, "org admins do not resize subscriptions")
+		assert.Equal(t, "subscriptions:write", w.Header().Get("X-Missing-Permission"))
+
+		var page store.Page[models.UserSubscription]
+		assert.NoError(t, json.NewDecoder(as(auth.RoleOrgAdmin, 2, "GET", "/user_subscriptions", "").Body).Decode(&page))
+		assert.Len(t, page.Items, 2)
+	})
+
+	t.Run("end user", func(t *testing.T) {
+		var page store.Page[models.UserSubscription]
+		assert.NoError(t, json.NewDecoder(as(auth.RoleUser, 7, "GET", "/user_subscriptions", "").Body).Decode(&page))
+		if assert.Len(t, page.Items, 1, "only their own seats") {
+			assert.Equal(t, 7, page.Items[0].UserID)
+		}
+		assert.Equal(t, http.StatusForbidden, as(auth.RoleUser, 7, "GET", "/user_subscriptions?user_id=8", "").Code)
+		assert.Equal(t, http.StatusForbidden, as(auth.RoleUser, 7, "GET", "/user_subscriptions?user_id=7", "").Code, "the user_id filter is for admins")
+
+		assert.Equal(t, http.StatusOK, as(auth.RoleUser, 7, "GET", "/user_subscriptions/1", "").Code)
+		w := as(auth.RoleUser, 7, "GET", "/user_subscriptions/2", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "seats:read", w.Header().Get("X-Missing-Permission"))
+
+		assert.Equal(t, http.StatusForbidden, as(auth.RoleUser, 7, "POST", "/user_subscriptions", `{"user_id": 7, "subscription_id": 1}`).Code)
+		assert.Equal(t, http.StatusForbidden, as(auth.RoleUser, 0, "GET", "/user_subscriptions", "").Code, "no user to limit the seats to")
+	})
+}
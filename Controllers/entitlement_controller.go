@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"subscriptions/auth"
+	"subscriptions/entitlements"
+)
+
+// GetEntitlement answers whether user_id may use product_id right now. A
+// denial is still a 200 response, with allowed false and a reason.
+func GetEntitlement(checker *entitlements.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermEntitlementsRead) {
+			return
+		}
+
+		query := r.URL.Query()
+		userID, err := queryInt(query, "user_id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		productID, err := queryInt(query, "product_id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if userID == 0 || productID == 0 {
+			http.Error(w, "user_id and product_id are required", http.StatusBadRequest)
+			return
+		}
+
+		entitlement, err := checker.Check(r.Context(), userID, productID)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entitlement)
+	}
+}
+
+// entitlementCheckRequest is the body of POST /entitlements/check.
+type entitlementCheckRequest struct {
+	Checks []entitlements.Check `json:"checks"`
+}
+
+// CheckEntitlements answers a batch of entitlement checks, in request order
+func CheckEntitlements(checker *entitlements.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermEntitlementsRead) {
+			return
+		}
+
+		var req entitlementCheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if len(req.Checks) == 0 || len(req.Checks) > entitlements.MaxBatchSize {
+			http.Error(w, fmt.Sprintf("checks must hold between 1 and %d entries", entitlements.MaxBatchSize), http.StatusBadRequest)
+			return
+		}
+		for i, check := range req.Checks {
+			if check.UserID < 1 || check.ProductID < 1 {
+				http.Error(w, fmt.Sprintf("checks[%d] needs a positive user_id and product_id", i), http.StatusBadRequest)
+				return
+			}
+		}
+
+		results, err := checker.CheckMany(r.Context(), req.Checks)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}
+}
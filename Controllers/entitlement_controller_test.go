@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/entitlements"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntitlements(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+	checker := entitlements.NewChecker(s.Subscriptions(), 0)
+
+	t.Run("single", func(t *testing.T) {
+		testCases := []struct {
+			name         string
+			query        string
+			expectedCode int
+			allowed      bool
+			reason       models.DenialReason
+		}{
+			{name: "allowed", query: "?user_id=7&product_id=101", expectedCode: http.StatusOK, allowed: true},
+			{name: "denied", query: "?user_id=8&product_id=101", expectedCode: http.StatusOK, reason: models.DenyNoSeat},
+			{name: "missing product", query: "?user_id=7", expectedCode: http.StatusBadRequest},
+			{name: "invalid user", query: "?user_id=x&product_id=101", expectedCode: http.StatusBadRequest},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				GetEntitlement(checker).ServeHTTP(w, httptest.NewRequest("GET", "/entitlements"+tc.query, nil))
+				assert.Equal(t, tc.expectedCode, w.Code)
+				if tc.expectedCode != http.StatusOK {
+					return
+				}
+				var entitlement models.Entitlement
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&entitlement))
+				assert.Equal(t, tc.allowed, entitlement.Allowed)
+				assert.Equal(t, tc.reason, entitlement.Reason)
+			})
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := `{"checks": [{"user_id": 7, "product_id": 101}, {"user_id": 7, "product_id": 102}]}`
+		CheckEntitlements(checker).ServeHTTP(w, httptest.NewRequest("POST", "/entitlements/check", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Results []models.Entitlement `json:"results"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		if assert.Len(t, response.Results, 2) {
+			assert.True(t, response.Results[0].Allowed)
+			assert.Equal(t, subscription.ID, response.Results[0].SubscriptionID)
+			assert.Equal(t, models.DenyNoSeat, response.Results[1].Reason)
+		}
+
+		for _, body := range []string{`{"checks": []}`, `{"checks": [{"user_id": 7}]}`, `{"checks": `} {
+			w := httptest.NewRecorder()
+			CheckEntitlements(checker).ServeHTTP(w, httptest.NewRequest("POST", "/entitlements/check", strings.NewReader(body)))
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readinessCheckTimeout bounds how long a single dependency check may take.
+const readinessCheckTimeout = 2 * time.Second
+
+// DependencyCheck probes one dependency for the readiness endpoint.
+// Optional dependencies are reported but never fail readiness.
+type DependencyCheck struct {
+	Name     string
+	Check    func(ctx context.Context) error
+	Optional bool
+}
+
+// DependencyStatus is the readiness report for one dependency.
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	Optional  bool   `json:"optional,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessReport is the body returned by /readyz.
+type ReadinessReport struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Readiness holds the dependency checks and the draining flag the server
+// flips on shutdown so load balancers stop routing new traffic to it.
+type Readiness struct {
+	checks   []DependencyCheck
+	draining atomic.Bool
+}
+
+// NewReadiness creates a Readiness that probes the given dependencies.
+func NewReadiness(checks ...DependencyCheck) *Readiness {
+	return &Readiness{checks: checks}
+}
+
+// Drain marks the server as draining; readiness fails from then on.
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// Draining reports whether Drain has been called.
+func (r *Readiness) Draining() bool {
+	return r.draining.Load()
+}
+
+// Healthz reports liveness: the process is up and serving HTTP.
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// Readyz reports readiness: every required dependency answers and the
+// server is not draining. Dependencies are probed concurrently.
+func Readyz(readiness *Readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := ReadinessReport{Status: "ready", Dependencies: map[string]DependencyStatus{}}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, check := range readiness.checks {
+			wg.Add(1)
+			go func(check DependencyCheck) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+				defer cancel()
+
+				start := time.Now()
+				err := check.Check(ctx)
+				status := DependencyStatus{Status: "up", Optional: check.Optional, LatencyMS: time.Since(start).Milliseconds()}
+				if err != nil {
+					status.Status = "down"
+					status.Error = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				report.Dependencies[check.Name] = status
+				if err != nil && !check.Optional {
+					report.Status = "not_ready"
+				}
+			}(check)
+		}
+		wg.Wait()
+
+		if readiness.Draining() {
+			report.Status = "draining"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != "ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	Healthz().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	testCases := []struct {
+		name           string
+		checks         []DependencyCheck
+		drain          bool
+		expectedCode   int
+		expectedStatus string
+	}{
+		{
+			name:           "all dependencies up",
+			checks:         []DependencyCheck{{Name: "postgres", Check: up}, {Name: "products", Check: up, Optional: true}},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "ready",
+		},
+		{
+			name:           "required dependency down",
+			checks:         []DependencyCheck{{Name: "postgres", Check: down}},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedStatus: "not_ready",
+		},
+		{
+			name:           "optional dependency down",
+			checks:         []DependencyCheck{{Name: "postgres", Check: up}, {Name: "products", Check: down, Optional: true}},
+			expectedCode:   http.StatusOK,
+			expectedStatus: "ready",
+		},
+		{
+			name:           "draining",
+			checks:         []DependencyCheck{{Name: "postgres", Check: up}},
+			drain:          true,
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedStatus: "draining",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readiness := NewReadiness(tc.checks...)
+			if tc.drain {
+				readiness.Drain()
+			}
+
+			w := httptest.NewRecorder()
+			Readyz(readiness).ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+
+			var report ReadinessReport
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+			assert.Equal(t, tc.expectedStatus, report.Status)
+			assert.Len(t, report.Dependencies, len(tc.checks))
+			for _, check := range tc.checks {
+				assert.Contains(t, report.Dependencies, check.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"subscriptions/store"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// pathID parses the {id} route variable. It writes a 400 response and
+// returns false when the id is not a positive integer.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// pageRequest reads limit, sort, cursor and include_total from the query.
+// A sort field prefixed with "-" sorts descending.
+func pageRequest(query url.Values) (store.PageRequest, error) {
+	var req store.PageRequest
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return req, errors.New("Invalid limit")
+		}
+		req.Limit = n
+	}
+	req.Sort = query.Get("sort")
+	if strings.HasPrefix(req.Sort, "-") {
+		req.Sort, req.Desc = req.Sort[1:], true
+	}
+	req.Cursor = query.Get("cursor")
+	if total := query.Get("include_total"); total != "" {
+		b, err := strconv.ParseBool(total)
+		if err != nil {
+			return req, errors.New("Invalid include_total")
+		}
+		req.IncludeTotal = b
+	}
+	return req, nil
+}
+
+// queryInt parses an optional positive integer query parameter; 0 means absent.
+func queryInt(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("Invalid %s", name)
+	}
+	return n, nil
+}
+
+// queryTime parses an optional RFC 3339 query parameter.
+func queryTime(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s", name)
+	}
+	return t, nil
+}
+
+// writeListError maps a List failure to a response: 400 for bad paging
+// parameters, 500 otherwise.
+func writeListError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrInvalidPageRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Println("Database query error:", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// writeSeatOverage writes the 409 response for a license_count below the
+// assigned seats, including by how many seats it falls short.
+func writeSeatOverage(w http.ResponseWriter, err error) {
+	body := map[string]interface{}{"error": err.Error()}
+	var overage *store.SeatOverageError
+	if errors.As(err, &overage) {
+		body["license_count"] = overage.LicenseCount
+		body["assigned_seats"] = overage.Assigned
+		body["overage"] = overage.Overage()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"subscriptions/auth"
+	"subscriptions/clock"
+	"subscriptions/store"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader names the key a client retries a request with.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// DefaultIdempotencyTTL is how long keys are remembered by default.
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a request may stay in progress
+	// before a retry presumes it abandoned, say by a crashed instance.
+	idempotencyLockTimeout  = time.Minute
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodyBytes  = 1 << 20
+)
+
+// Idempotency replays the response of a request when a client retries it
+// with the same Idempotency-Key, so retries cannot create duplicates.
+type Idempotency struct {
+	Keys  store.IdempotencyStore
+	TTL   time.Duration
+	Clock clock.Clock
+}
+
+// NewIdempotency creates an Idempotency remembering keys for ttl.
+func NewIdempotency(keys store.IdempotencyStore, ttl time.Duration) *Idempotency {
+	return &Idempotency{Keys: keys, TTL: ttl, Clock: clock.Real{}}
+}
+
+// Wrap makes next idempotent for requests carrying an Idempotency-Key. The
+// first request runs and its response is stored with a fingerprint of the
+// request; repeats get that response again, or 422 when their method, path
+// or body differ, or 409 while the first is still running. Responses with
+// a 5xx status are not stored so the request can be retried. Requests
+// without the header run as usual.
+func (i *Idempotency) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, IdempotencyKeyHeader+" must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := i.Clock.Now()
+		record := store.IdempotencyRecord{
+			Scope:       idempotencyScope(r),
+			Key:         key,
+			Fingerprint: fingerprint(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(i.TTL),
+		}
+		existing, claimed, err := i.Keys.Begin(r.Context(), record, now.Add(-idempotencyLockTimeout))
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			replay(w, existing, record.Fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		// The response is written, so remember it even if the client went
+		// away. The claim's CreatedAt keeps a request that ran past
+		// idempotencyLockTimeout from touching the claim that took it over.
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.status >= http.StatusInternalServerError {
+			err = i.Keys.Release(ctx, record.Scope, record.Key, record.CreatedAt)
+		} else {
+			err = i.Keys.Complete(ctx, record.Scope, record.Key, record.CreatedAt, recorder.status, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Storing idempotent response: %v", err)
+		}
+	}
+}
+
+// RunPurge deletes expired keys every interval until ctx is cancelled.
+func (i *Idempotency) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := i.Keys.Purge(ctx, i.Clock.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Purging idempotency keys failed: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d expired idempotency keys", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay answers a repeated request from the record of the first one.
+func replay(w http.ResponseWriter, record store.IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		http.Error(w, IdempotencyKeyHeader+" was already used with a different request", http.StatusUnprocessableEntity)
+	case record.StatusCode == 0:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "A request with this "+IdempotencyKeyHeader+" is still in progress", http.StatusConflict)
+	default:
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.StatusCode)
+		w.Write(record.Body)
+	}
+}
+
+// idempotencyScope is who a key belongs to: the caller, within the
+// organization the request acts for.
+func idempotencyScope(r *http.Request) string {
+	scope := strconv.Itoa(store.OrganizationFrom(r.Context()))
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		scope += "/" + principal.Subject
+	}
+	return scope
+}
+
+// fingerprint hashes the method, path and body of a request. JSON bodies are
+// compacted first so whitespace does not make a retry look different.
+func fingerprint(r *http.Request, body []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		body = compact.Bytes()
+	}
+	sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// responseRecorder writes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/auth"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(context.Background(), &models.Organization{Name: "Acme"}))
+	assert.NoError(t, s.Subscriptions().Create(context.Background(), &models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1, OrganizationID: 1}))
+	fake := clock.NewFake(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	idempotency := &Idempotency{Keys: s.IdempotencyKeys(), TTL: time.Hour, Clock: fake}
+
+	failing, calls := true, 0
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions", idempotency.Wrap(CreateSubscription(s.Subscriptions(), s.Organizations(), nil))).Methods("POST")
+	r.HandleFunc("/user_subscriptions", idempotency.Wrap(CreateUserSubscription(s.UserSubscriptions()))).Methods("POST")
+	r.HandleFunc("/flaky", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})).Methods("POST")
+
+	as := func(subject, key, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		if subject != "" {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Kind: auth.KindService, Subject: subject, Roles: []auth.Role{auth.RolePlatformAdmin}}))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	do := func(key, target, body string) *httptest.ResponseRecorder { return as("", key, target, body) }
+	seats := func() int {
+		page, err := s.UserSubscriptions().List(context.Background(), store.UserSubscriptionFilter{}, store.PageRequest{Limit: store.MaxLimit})
+		assert.NoError(t, err)
+		return len(page.Items)
+	}
+
+	t.Run("replays the first response", func(t *testing.T) {
+		first := do("seat-1", "/user_subscriptions", `{"user_id": 7, "subscription_id": 1}`)
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		again := do("seat-1", "/user_subscriptions", "{\n  \"user_id\": 7,\n  \"subscription_id\": 1\n}")
+		assert.Equal(t, http.StatusOK, again.Code, "whitespace does not change the fingerprint")
+		assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Header().Get("Content-Type"), again.Header().Get("Content-Type"))
+		assert.Equal(t, first.Body.String(), again.Body.String())
+		assert.Equal(t, 1, seats(), "the retry consumed no seat")
+	})
+
+	t.Run("replays errors", func(t *testing.T) {
+		first := do("seat-2", "/user_subscriptions", `{"user_id": 8, "subscription_id": 1}`)
+		assert.Equal(t, http.StatusForbidden, first.Code, "no seat left")
+		again := do("seat-2", "/user_subscriptions", `{"user_id": 8, "subscription_id": 1}`)
+		assert.Equal(t, http.StatusForbidden, again.Code)
+		assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("rejects a different request", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity, do("seat-1", "/user_subscriptions", `{"user_id": 9, "subscription_id": 1}`).Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, do("seat-1", "/subscriptions", `{"user_id": 7, "subscription_id": 1}`).Code)
+	})
+
+	t.Run("keys belong to their caller", func(t *testing.T) {
+		failing = false
+		assert.Equal(t, http.StatusCreated, as("svc-a", "plan", "/flaky", "").Code)
+		assert.Equal(t, http.StatusCreated, as("svc-b", "plan", "/flaky", "").Code)
+		replayed := as("svc-a", "plan", "/flaky", "")
+		assert.Equal(t, http.StatusCreated, replayed.Code)
+		assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		// Another instance is still handling the first request
+		now := fake.Now()
+		running := store.IdempotencyRecord{Scope: "0", Key: "running", Fingerprint: fingerprint(httptest.NewRequest("POST", "/flaky", nil), nil), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		_, claimed, err := s.IdempotencyKeys().Begin(context.Background(), running, now)
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+
+		w := do("running", "/flaky", "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		fake.Advance(2 * time.Minute)
+		assert.Equal(t, http.StatusCreated, do("running", "/flaky", "").Code, "abandoned requests can be taken over")
+	})
+
+	t.Run("server errors are not remembered", func(t *testing.T) {
+		failing = true
+		assert.Equal(t, http.StatusInternalServerError, do("flaky", "/flaky", "").Code)
+		failing = false
+		w := do("flaky", "/flaky", "")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		fake.Advance(2 * time.Hour)
+		w := do("seat-1", "/user_subscriptions", `{"user_id": 9, "subscription_id": 1}`)
+		assert.Equal(t, http.StatusForbidden, w.Code, "the expired key runs the request again")
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		purged, err := s.IdempotencyKeys().Purge(context.Background(), fake.Now().Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Positive(t, purged)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(strings.Repeat("k", 256), "/flaky", "").Code)
+		assert.Equal(t, http.StatusCreated, do("", "/flaky", "").Code, "requests without a key run as usual")
+	})
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"subscriptions/auth"
+	"subscriptions/billing"
+	"subscriptions/clients"
+	"subscriptions/models"
+	"subscriptions/store"
+	"time"
+)
+
+// GetSubscriptionInvoices lists the invoices of a subscription
+func GetSubscriptionInvoices(subscriptions store.SubscriptionStore, invoices store.InvoiceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermInvoicesRead) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		if _, err := subscriptions.Get(r.Context(), id); err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		list, err := invoices.ListBySubscription(r.Context(), id)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// CreateSubscriptionInvoice drafts the invoice of the current period of a
+// subscription. Invoices are priced from the products service, so without a
+// products client generator is nil and invoicing is unavailable.
+func CreateSubscriptionInvoice(generator *billing.InvoiceGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermInvoicesWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		if generator == nil {
+			http.Error(w, "Invoicing requires the products service", http.StatusServiceUnavailable)
+			return
+		}
+
+		invoice, err := generator.Generate(r.Context(), id)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrInvoiceExists), errors.Is(err, billing.ErrNotBillable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, clients.ErrNotFound):
+			http.Error(w, "Subscription product is no longer offered", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, clients.ErrUnavailable):
+			log.Printf("Products service unavailable: %v", err)
+			http.Error(w, "Products service unavailable", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			log.Printf("Invoice generation error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invoice)
+	}
+}
+
+// GetInvoice returns a single invoice with its lines
+func GetInvoice(invoices store.InvoiceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermInvoicesRead) {
+			return
+		}
+
+		invoice, ok := findInvoice(w, r, invoices)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+	}
+}
+
+// GetInvoiceHTML renders an invoice as a printable HTML page
+func GetInvoiceHTML(invoices store.InvoiceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermInvoicesRead) {
+			return
+		}
+
+		invoice, ok := findInvoice(w, r, invoices)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := billing.RenderHTML(w, invoice); err != nil {
+			log.Printf("Rendering invoice %d: %v", invoice.ID, err)
+		}
+	}
+}
+
+// findInvoice loads the invoice named by the {id} route variable, writing
+// the error response when it cannot.
+func findInvoice(w http.ResponseWriter, r *http.Request, invoices store.InvoiceStore) (models.Invoice, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return models.Invoice{}, false
+	}
+
+	invoice, err := invoices.Get(r.Context(), id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Database error: %v", err)
+		}
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return models.Invoice{}, false
+	}
+	return invoice, true
+}
+
+// TransitionInvoice moves an invoice to status: finalizing a draft numbers
+// and issues it, open invoices can be paid, and both can be voided.
+func TransitionInvoice(invoices store.InvoiceStore, status models.InvoiceStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermInvoicesWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		invoice, err := invoices.Transition(r.Context(), id, status, time.Now())
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+	}
+}
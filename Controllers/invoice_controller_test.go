@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/billing"
+	"subscriptions/clients"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoices(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 2, AutoRenew: true, DiscountPercent: 50, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	paused := models.Subscription{Name: "Paused", ProductID: 101, LicenseCount: 2, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &paused))
+	_, err := s.Subscriptions().Transition(ctx, paused.ID, models.EventPause, time.Now())
+	assert.NoError(t, err)
+
+	products := &fakeProducts{products: map[int]clients.Product{101: {ID: 101, Name: "Editor", Price: 10}}}
+	newRouter := func(generator *billing.InvoiceGenerator) *mux.Router {
+		r := mux.NewRouter()
+		r.HandleFunc("/subscriptions/{id}/invoices", GetSubscriptionInvoices(s.Subscriptions(), s.Invoices())).Methods("GET")
+		r.HandleFunc("/subscriptions/{id}/invoices", CreateSubscriptionInvoice(generator)).Methods("POST")
+		r.HandleFunc("/invoices/{id}", GetInvoice(s.Invoices())).Methods("GET")
+		r.HandleFunc("/invoices/{id}/html", GetInvoiceHTML(s.Invoices())).Methods("GET")
+		r.HandleFunc("/invoices/{id}/finalize", TransitionInvoice(s.Invoices(), models.InvoiceOpen)).Methods("POST")
+		r.HandleFunc("/invoices/{id}/pay", TransitionInvoice(s.Invoices(), models.InvoicePaid)).Methods("POST")
+		r.HandleFunc("/invoices/{id}/void", TransitionInvoice(s.Invoices(), models.InvoiceVoid)).Methods("POST")
+		return r
+	}
+	r := newRouter(billing.NewInvoiceGenerator(s, products))
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	t.Run("generate draft", func(t *testing.T) {
+		w := do("POST", "/subscriptions/1/invoices")
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var invoice models.Invoice
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&invoice))
+		assert.Equal(t, models.InvoiceDraft, invoice.Status)
+		assert.Equal(t, 20.0, invoice.Subtotal)
+		assert.Equal(t, 10.0, invoice.Total)
+		assert.Len(t, invoice.Lines, 2)
+
+		assert.Equal(t, http.StatusConflict, do("POST", "/subscriptions/1/invoices").Code, "period already invoiced")
+	})
+
+	t.Run("lifecycle", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, do("POST", "/invoices/1/pay").Code, "drafts cannot be paid")
+
+		w := do("POST", "/invoices/1/finalize")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var invoice models.Invoice
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&invoice))
+		assert.Equal(t, models.InvoiceOpen, invoice.Status)
+		assert.Equal(t, "INV-000001", invoice.Number)
+
+		assert.Equal(t, http.StatusOK, do("POST", "/invoices/1/pay").Code)
+		assert.Equal(t, http.StatusConflict, do("POST", "/invoices/1/void").Code, "paid invoices cannot be voided")
+		assert.Equal(t, http.StatusNotFound, do("POST", "/invoices/99/finalize").Code)
+	})
+
+	t.Run("list and render", func(t *testing.T) {
+		w := do("GET", "/subscriptions/1/invoices")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var invoices []models.Invoice
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&invoices))
+		if assert.Len(t, invoices, 1) {
+			assert.Equal(t, models.InvoicePaid, invoices[0].Status)
+		}
+		assert.Equal(t, http.StatusNotFound, do("GET", "/subscriptions/99/invoices").Code)
+
+		w = do("GET", "/invoices/1/html")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.True(t, strings.Contains(w.Body.String(), "INV-000001"))
+		assert.Equal(t, http.StatusNotFound, do("GET", "/invoices/99").Code)
+	})
+
+	t.Run("not billable", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, do("POST", "/subscriptions/2/invoices").Code)
+		assert.Equal(t, http.StatusNotFound, do("POST", "/subscriptions/99/invoices").Code)
+	})
+
+	t.Run("products service", func(t *testing.T) {
+		down := &fakeProducts{err: fmt.Errorf("%w: connection refused", clients.ErrUnavailable)}
+		w := httptest.NewRecorder()
+		newRouter(billing.NewInvoiceGenerator(s, down)).ServeHTTP(w, httptest.NewRequest("POST", "/subscriptions/1/invoices", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		w = httptest.NewRecorder()
+		newRouter(nil).ServeHTTP(w, httptest.NewRequest("POST", "/subscriptions/1/invoices", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "invoicing needs prices")
+	})
+}
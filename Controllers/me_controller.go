@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"subscriptions/auth"
+	"subscriptions/entitlements"
+	"subscriptions/store"
+)
+
+// GetMySubscriptions lists the seats of the authenticated user with their
+// subscription name and product details. It takes the filters and paging
+// of GET /user_subscriptions, but the user always comes from the caller.
+func GetMySubscriptions(userSubscriptions store.UserSubscriptionStore, products ProductLookup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorizeSelf(w, r, auth.PermSeatsReadOwn, auth.PermSeatsRead)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		query.Del("user_id")
+		filter, err := userSubscriptionFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.UserID = userID
+
+		listUserSubscriptions(w, r, userSubscriptions, products, filter, true)
+	}
+}
+
+// GetMyEntitlements answers what the authenticated user may use right now:
+// one entitlement for each product they hold a seat on, denied ones included
+// with their reason.
+func GetMyEntitlements(checker *entitlements.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorizeSelf(w, r, auth.PermEntitlementsReadOwn, auth.PermEntitlementsRead)
+		if !ok {
+			return
+		}
+
+		results, err := checker.ForUser(r.Context(), userID)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "entitlements": results})
+	}
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"subscriptions/auth"
+	"subscriptions/clients"
+	"subscriptions/entitlements"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMe(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	for _, subscription := range []models.Subscription{
+		{Name: "Editor Team", ProductID: 101, LicenseCount: 5, OrganizationID: 1},
+		{Name: "Viewer Team", ProductID: 102, LicenseCount: 5, OrganizationID: 1},
+	} {
+		assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	}
+	for _, seat := range []models.UserSubscription{{UserID: 7, SubscriptionID: 1}, {UserID: 7, SubscriptionID: 2}, {UserID: 8, SubscriptionID: 1}} {
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &seat))
+	}
+	_, err := s.Subscriptions().Transition(ctx, 2, models.EventPause, time.Now())
+	assert.NoError(t, err)
+
+	products := &fakeProducts{products: map[int]clients.Product{101: {ID: 101, Name: "Editor"}, 102: {ID: 102, Name: "Viewer"}}}
+	r := mux.NewRouter()
+	r.HandleFunc("/me/subscriptions", GetMySubscriptions(s.UserSubscriptions(), products)).Methods("GET")
+	r.HandleFunc("/me/entitlements", GetMyEntitlements(entitlements.NewChecker(s.Subscriptions(), time.Minute))).Methods("GET")
+
+	as := func(principal *auth.Principal, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if principal != nil {
+			req = req.WithContext(auth.WithPrincipal(store.WithOrganization(req.Context(), principal.OrganizationID), *principal))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	user := &auth.Principal{Kind: auth.KindUser, Subject: "7", UserID: 7, OrganizationID: 1, Roles: []auth.Role{auth.RoleUser}}
+
+	t.Run("subscriptions", func(t *testing.T) {
+		w := as(user, "/me/subscriptions?user_id=8")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page store.Page[models.UserSubscription]
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		if assert.Len(t, page.Items, 2, "user_id cannot pick someone else") {
+			assert.Equal(t, 7, page.Items[0].UserID)
+			assert.Equal(t, "Editor Team", page.Items[0].SubscriptionName)
+			if assert.NotNil(t, page.Items[0].Product) {
+				assert.Equal(t, "Editor", page.Items[0].Product.Name)
+			}
+		}
+
+		assert.NoError(t, json.NewDecoder(as(user, "/me/subscriptions?product_id=102").Body).Decode(&page))
+		assert.Len(t, page.Items, 1)
+		assert.Equal(t, http.StatusBadRequest, as(user, "/me/subscriptions?limit=0").Code)
+	})
+
+	t.Run("entitlements", func(t *testing.T) {
+		w := as(user, "/me/entitlements")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			UserID       int                  `json:"user_id"`
+			Entitlements []models.Entitlement `json:"entitlements"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, 7, body.UserID)
+		if assert.Len(t, body.Entitlements, 2) {
+			assert.True(t, body.Entitlements[0].Allowed)
+			assert.Equal(t, models.DenySubscriptionPaused, body.Entitlements[1].Reason)
+		}
+	})
+
+	t.Run("callers", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, as(nil, "/me/subscriptions").Code)
+		service := &auth.Principal{Kind: auth.KindService, Subject: "api_key:1", APIKeyID: 1, Roles: []auth.Role{auth.RolePlatformAdmin}}
+		assert.Equal(t, http.StatusForbidden, as(service, "/me/entitlements").Code, "services have no seats")
+		admin := &auth.Principal{Kind: auth.KindUser, UserID: 8, OrganizationID: 1, Roles: []auth.Role{auth.RoleOrgAdmin}}
+		assert.Equal(t, http.StatusOK, as(admin, "/me/subscriptions").Code, "admins may read about themselves too")
+		noRoles := &auth.Principal{Kind: auth.KindUser, UserID: 9, OrganizationID: 1, Roles: []auth.Role{}}
+		w := as(noRoles, "/me/entitlements")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "entitlements:read:own", w.Header().Get("X-Missing-Permission"))
+	})
+}
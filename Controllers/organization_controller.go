@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+)
+
+// OrganizationHeader names the organization a request acts for.
+const OrganizationHeader = "X-Organization-ID"
+
+// OrganizationScope scopes each request to the organization it acts for, so
+// the stores only see that organization's subscriptions, seats and
+// invoices. That is the organization of the authenticated caller or, for
+// platform callers, the one named by the X-Organization-ID header. Requests
+// without either are not scoped.
+func OrganizationScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(OrganizationHeader)
+		id := 0
+		if header != "" {
+			var err error
+			if id, err = strconv.Atoi(header); err != nil || id < 1 {
+				http.Error(w, "Invalid "+OrganizationHeader+" header", http.StatusBadRequest)
+				return
+			}
+		}
+		if principal, ok := auth.PrincipalFrom(r.Context()); ok && principal.OrganizationID != 0 {
+			if id != 0 && id != principal.OrganizationID {
+				http.Error(w, OrganizationHeader+" must be the caller's organization", http.StatusForbidden)
+				return
+			}
+			id = principal.OrganizationID
+		}
+		if id == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(store.WithOrganization(r.Context(), id)))
+	})
+}
+
+// CreateOrganization creates a new organization. Organizations are created
+// outside of any organization scope.
+func CreateOrganization(organizations store.OrganizationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermOrganizationsWrite) {
+			return
+		}
+
+		if store.OrganizationFrom(r.Context()) != 0 {
+			http.Error(w, "Organizations cannot be created on behalf of an organization", http.StatusForbidden)
+			return
+		}
+
+		var organization models.Organization
+		if err := json.NewDecoder(r.Body).Decode(&organization); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		organization.Name = strings.TrimSpace(organization.Name)
+		if organization.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := organizations.Create(r.Context(), &organization); err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(organization)
+	}
+}
+
+// GetOrganization retrieves an organization by ID
+func GetOrganization(organizations store.OrganizationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermOrganizationsRead) {
+			return
+		}
+
+		organization, ok := findOrganization(w, r, organizations)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(organization)
+	}
+}
+
+// GetOrganizationSubscriptions lists the subscriptions of an organization,
+// with the filters, paging and expansions of GET /subscriptions
+func GetOrganizationSubscriptions(organizations store.OrganizationStore, subscriptions store.SubscriptionStore, seats store.UserSubscriptionStore, products ProductLookup) http.HandlerFunc {
+	list := GetSubscriptions(subscriptions, seats, products)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermOrganizationsRead) {
+			return
+		}
+
+		organization, ok := findOrganization(w, r, organizations)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		query.Set("organization_id", strconv.Itoa(organization.ID))
+		r.URL.RawQuery = query.Encode()
+		list(w, r)
+	}
+}
+
+// findOrganization loads the organization named by the {id} route variable,
+// writing the error response when it cannot.
+func findOrganization(w http.ResponseWriter, r *http.Request, organizations store.OrganizationStore) (models.Organization, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return models.Organization{}, false
+	}
+
+	organization, err := organizations.Get(r.Context(), id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Database error: %v", err)
+		}
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return models.Organization{}, false
+	}
+	return organization, true
+}
+
+// resolveOrganization decides the organization a new subscription belongs
+// to: the request's organization when it is scoped, organization_id from
+// the body otherwise. It writes the error response when there is none.
+func resolveOrganization(w http.ResponseWriter, r *http.Request, organizations store.OrganizationStore, subscription *models.Subscription) bool {
+	if scope := store.OrganizationFrom(r.Context()); scope != 0 {
+		if subscription.OrganizationID != 0 && subscription.OrganizationID != scope {
+			http.Error(w, "organization_id must be the organization of the request", http.StatusForbidden)
+			return false
+		}
+		subscription.OrganizationID = scope
+	}
+	if subscription.OrganizationID == 0 {
+		http.Error(w, "organization_id is required", http.StatusBadRequest)
+		return false
+	}
+
+	_, err := organizations.Get(r.Context(), subscription.OrganizationID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Organization not found", http.StatusUnprocessableEntity)
+		return false
+	case err != nil:
+		log.Printf("Database error: %v", err)
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
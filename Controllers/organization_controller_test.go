@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrganizations(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	r := mux.NewRouter()
+	r.Use(OrganizationScope)
+	r.HandleFunc("/organizations", CreateOrganization(s.Organizations())).Methods("POST")
+	r.HandleFunc("/organizations/{id}", GetOrganization(s.Organizations())).Methods("GET")
+	r.HandleFunc("/organizations/{id}/subscriptions", GetOrganizationSubscriptions(s.Organizations(), s.Subscriptions(), s.UserSubscriptions(), nil)).Methods("GET")
+
+	do := func(organization, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if organization != "" {
+			req.Header.Set(OrganizationHeader, organization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("create", func(t *testing.T) {
+		w := do("", "POST", "/organizations", `{"name": " Acme "}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var organization models.Organization
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&organization))
+		assert.Equal(t, 1, organization.ID)
+		assert.Equal(t, "Acme", organization.Name)
+
+		assert.Equal(t, http.StatusCreated, do("", "POST", "/organizations", `{"name": "Globex"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("", "POST", "/organizations", `{"name": ""}`).Code)
+		assert.Equal(t, http.StatusForbidden, do("1", "POST", "/organizations", `{"name": "Initech"}`).Code)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do("", "GET", "/organizations/2", "").Code)
+		assert.Equal(t, http.StatusOK, do("2", "GET", "/organizations/2", "").Code)
+		assert.Equal(t, http.StatusNotFound, do("1", "GET", "/organizations/2", "").Code, "other organizations do not exist")
+		assert.Equal(t, http.StatusNotFound, do("", "GET", "/organizations/9", "").Code)
+		assert.Equal(t, http.StatusBadRequest, do("0", "GET", "/organizations/1", "").Code)
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		for _, organizationID := range []int{1, 2, 1} {
+			subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1, OrganizationID: organizationID}
+			assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+		}
+
+		w := do("", "GET", "/organizations/1/subscriptions?limit=1&include_total=true", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page store.Page[models.Subscription]
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		assert.Equal(t, 2, *page.Total)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, 1, page.Items[0].OrganizationID)
+		}
+
+		assert.Equal(t, http.StatusOK, do("1", "GET", "/organizations/1/subscriptions", "").Code)
+		assert.Equal(t, http.StatusNotFound, do("1", "GET", "/organizations/2/subscriptions", "").Code)
+	})
+}
+
+func TestOrganizationScopeInQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := store.NewPostgres(db)
+	ctx := store.WithOrganization(context.Background(), 3)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectSubscriptionsQuery+" AND id = $1 AND organization_id = $2")).
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns))
+	_, err = s.Subscriptions().Get(ctx, 1)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND subscription_id IN (SELECT id FROM subscriptions WHERE organization_id = $2)")).
+		WithArgs(5, 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.UserSubscriptions().Delete(ctx, 5), store.ErrNotFound)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, number, subscription_id, status, period_start, period_end, subtotal, total, created_at, issued_at, paid_at, voided_at FROM invoices WHERE id = $1 AND subscription_id IN (SELECT id FROM subscriptions WHERE organization_id = $2)")).
+		WithArgs(7, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = s.Invoices().Get(ctx, 7)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1 AND deleted_at IS NULL)")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO subscriptions")).
+		WithArgs("Team", 101, 1, models.StatusActive, models.IntervalMonthly, 0, false, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, 0.0, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(4, time.Now(), time.Now()))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1, OrganizationID: 8}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.Equal(t, 3, subscription.OrganizationID, "the scope decides the organization")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"subscriptions/auth"
+	"subscriptions/billing"
+	"subscriptions/clients"
+	"subscriptions/models"
+	"subscriptions/store"
+	"time"
+)
+
+// planChangeRequest is the body of POST /subscriptions/{id}/change_plan.
+// Zero product_id or license_count keep the current value.
+type planChangeRequest struct {
+	ProductID    int                     `json:"product_id"`
+	LicenseCount int                     `json:"license_count"`
+	Timing       models.PlanChangeTiming `json:"timing"`
+}
+
+// ChangeSubscriptionPlan upgrades or downgrades the product or seat count of
+// a subscription, immediately with proration or at the end of the current
+// period. A new product is validated like on create. Without a products
+// client, or when the products service is down and products allows it,
+// changes are not prorated. Lowering license_count below the assigned seats
+// fails with 409 unless seat_policy=revoke is given, which revokes the most
+// recently assigned seats and responds with the change and the revoked seat
+// ids.
+func ChangeSubscriptionPlan(subscriptions store.SubscriptionStore, products *ProductValidator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermSubscriptionsWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		policy, ok := seatPolicy(w, r)
+		if !ok {
+			return
+		}
+
+		var req planChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if req.Timing == "" {
+			req.Timing = models.ChangeImmediately
+		}
+		if req.Timing != models.ChangeImmediately && req.Timing != models.ChangeAtPeriodEnd {
+			http.Error(w, "Invalid timing (want immediately or at_period_end)", http.StatusBadRequest)
+			return
+		}
+		if req.ProductID < 0 || req.LicenseCount < 0 {
+			http.Error(w, "product_id and license_count must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		subscription, err := subscriptions.Get(r.Context(), id)
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		change := models.PlanChange{
+			SubscriptionID:   id,
+			FromProductID:    subscription.ProductID,
+			FromLicenseCount: subscription.LicenseCount,
+			ToProductID:      subscription.ProductID,
+			ToLicenseCount:   subscription.LicenseCount,
+			Timing:           req.Timing,
+		}
+		if req.ProductID != 0 {
+			change.ToProductID = req.ProductID
+		}
+		if req.LicenseCount != 0 {
+			change.ToLicenseCount = req.LicenseCount
+		}
+		if change.ToProductID == change.FromProductID && change.ToLicenseCount == change.FromLicenseCount {
+			http.Error(w, "Plan is unchanged", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		if change.ToProductID != change.FromProductID {
+			if err := products.Validate(r.Context(), change.ToProductID); err != nil {
+				writeProductError(w, err)
+				return
+			}
+		}
+		if change.Timing == models.ChangeImmediately {
+			proration, err := prorate(r.Context(), products, subscription, change, now)
+			if err != nil {
+				writeProductError(w, err)
+				return
+			}
+			change.Proration = proration
+		}
+
+		revoked, err := subscriptions.ChangePlan(r.Context(), &change, policy, now)
+		if err != nil {
+			writePlanChangeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if policy == store.SeatPolicyRevoke {
+			if revoked == nil {
+				revoked = []int{}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"plan_change":                   change,
+				"revoked_user_subscription_ids": revoked,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(change)
+	}
+}
+
+// seatPolicy reads the seat_policy query parameter, rejecting unknown
+// policies with 400. It defaults to reject.
+func seatPolicy(w http.ResponseWriter, r *http.Request) (store.SeatPolicy, bool) {
+	policy := store.SeatPolicy(r.URL.Query().Get("seat_policy"))
+	if policy == "" {
+		policy = store.SeatPolicyReject
+	}
+	if policy != store.SeatPolicyReject && policy != store.SeatPolicyRevoke {
+		http.Error(w, "Invalid seat_policy (want reject or revoke)", http.StatusBadRequest)
+		return "", false
+	}
+	return policy, true
+}
+
+// writePlanChangeError responds to an error from SubscriptionStore.ChangePlan.
+func writePlanChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrPlanChanged), errors.Is(err, store.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, store.ErrSeatsAssigned):
+		writeSeatOverage(w, err)
+	default:
+		log.Printf("Database error: %v", err)
+		http.Error(w, "database error", http.StatusInternalServerError)
+	}
+}
+
+// prorate prices the immediate change of subscription to change's plan. It
+// is zero without a products client, and when the products service is down
+// but products lets writes through regardless.
+func prorate(ctx context.Context, products *ProductValidator, subscription models.Subscription, change models.PlanChange, now time.Time) (float64, error) {
+	if products == nil || products.Products == nil {
+		return 0, nil
+	}
+	fromPrice, toPrice, err := planPrices(ctx, products.Products, change.FromProductID, change.ToProductID)
+	if errors.Is(err, errProductsUnavailable) && products.AllowUnavailable {
+		log.Printf("Changing the plan of subscription %d without proration", subscription.ID)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return billing.Prorate(subscription, fromPrice, toPrice, change.ToLicenseCount, now), nil
+}
+
+// planPrices looks up the per-seat prices of the current and the new
+// product. The new product must exist; a current product that has left the
+// catalog is priced at zero.
+func planPrices(ctx context.Context, products ProductLookup, fromID, toID int) (fromPrice, toPrice float64, err error) {
+	to, err := products.GetOfferById(ctx, toID)
+	switch {
+	case errors.Is(err, clients.ErrNotFound):
+		return 0, 0, errUnknownProduct
+	case err != nil:
+		log.Printf("Products service unavailable: %v", err)
+		return 0, 0, errProductsUnavailable
+	}
+	if fromID == toID {
+		return to.Price, to.Price, nil
+	}
+
+	from, err := products.GetOfferById(ctx, fromID)
+	switch {
+	case errors.Is(err, clients.ErrNotFound):
+		log.Printf("Product %d is no longer offered, prorating it at zero", fromID)
+		return 0, to.Price, nil
+	case err != nil:
+		log.Printf("Products service unavailable: %v", err)
+		return 0, 0, errProductsUnavailable
+	}
+	return from.Price, to.Price, nil
+}
+
+// GetSubscriptionPlanChanges lists the applied, scheduled and canceled plan
+// changes of a subscription
+func GetSubscriptionPlanChanges(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermSubscriptionsRead) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		if _, err := subscriptions.Get(r.Context(), id); err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		changes, err := subscriptions.PlanChanges(r.Context(), id)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/clients"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeSubscriptionPlan(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 2, AutoRenew: true, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	canceled := models.Subscription{Name: "Gone", ProductID: 101, LicenseCount: 2, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &canceled))
+	_, err := s.Subscriptions().Transition(ctx, canceled.ID, models.EventCancel, time.Now())
+	assert.NoError(t, err)
+
+	products := &fakeProducts{products: map[int]clients.Product{
+		101: {ID: 101, Name: "Editor", Price: 10},
+		102: {ID: 102, Name: "Studio", Price: 30},
+	}}
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions/{id}/change_plan", ChangeSubscriptionPlan(s.Subscriptions(), &ProductValidator{Products: products})).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/plan_changes", GetSubscriptionPlanChanges(s.Subscriptions())).Methods("GET")
+
+	do := func(target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("immediate upgrade is prorated", func(t *testing.T) {
+		w := do("/subscriptions/1/change_plan", `{"product_id": 102, "license_count": 3}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var change models.PlanChange
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&change))
+		assert.Equal(t, models.PlanChangeApplied, change.Status)
+		assert.Equal(t, models.ChangeImmediately, change.Timing)
+		// Almost the whole period remains: 3 x 30 - 2 x 10 = 70
+		assert.InDelta(t, 70, change.Proration, 0.01)
+
+		got, err := s.Subscriptions().Get(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 102, got.ProductID)
+		assert.Equal(t, 3, got.LicenseCount)
+	})
+
+	t.Run("downgrade at period end is scheduled", func(t *testing.T) {
+		w := do("/subscriptions/1/change_plan", `{"license_count": 1, "timing": "at_period_end"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var change models.PlanChange
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&change))
+		assert.Equal(t, models.PlanChangeScheduled, change.Status)
+		assert.Equal(t, 102, change.ToProductID)
+		assert.Equal(t, 1, change.ToLicenseCount)
+		assert.Zero(t, change.Proration)
+
+		got, err := s.Subscriptions().Get(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, got.LicenseCount)
+		assert.Equal(t, got.CurrentPeriodEnd, change.EffectiveAt)
+	})
+
+	t.Run("history", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions/1/plan_changes", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var changes []models.PlanChange
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&changes))
+		assert.Len(t, changes, 2)
+	})
+
+	testCases := []struct {
+		name         string
+		target       string
+		requestBody  string
+		products     *ProductValidator
+		expectedCode int
+	}{
+		{name: "unchanged", target: "/subscriptions/1/change_plan", requestBody: `{"product_id": 102}`, expectedCode: http.StatusBadRequest},
+		{name: "bad timing", target: "/subscriptions/1/change_plan", requestBody: `{"license_count": 9, "timing": "tomorrow"}`, expectedCode: http.StatusBadRequest},
+		{name: "negative seats", target: "/subscriptions/1/change_plan", requestBody: `{"license_count": -1}`, expectedCode: http.StatusBadRequest},
+		{name: "unknown product", target: "/subscriptions/1/change_plan", requestBody: `{"product_id": 999}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "missing subscription", target: "/subscriptions/42/change_plan", requestBody: `{"license_count": 9}`, expectedCode: http.StatusNotFound},
+		{name: "canceled subscription", target: "/subscriptions/2/change_plan", requestBody: `{"license_count": 9}`, expectedCode: http.StatusConflict},
+		{
+			name:         "products service down",
+			target:       "/subscriptions/1/change_plan",
+			requestBody:  `{"license_count": 9}`,
+			products:     &ProductValidator{Products: &fakeProducts{err: fmt.Errorf("%w: connection refused", clients.ErrUnavailable)}},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "unknown product at period end",
+			target:       "/subscriptions/1/change_plan",
+			requestBody:  `{"product_id": 999, "timing": "at_period_end"}`,
+			products:     &ProductValidator{Products: products},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := http.Handler(r)
+			if tc.products != nil {
+				router := mux.NewRouter()
+				router.HandleFunc("/subscriptions/{id}/change_plan", ChangeSubscriptionPlan(s.Subscriptions(), tc.products)).Methods("POST")
+				handler = router
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", tc.target, strings.NewReader(tc.requestBody)))
+			assert.Equal(t, tc.expectedCode, w.Code)
+		})
+	}
+
+	t.Run("products service down is allowed unprorated", func(t *testing.T) {
+		down := &ProductValidator{Products: &fakeProducts{err: fmt.Errorf("%w: connection refused", clients.ErrUnavailable)}, AllowUnavailable: true}
+		router := mux.NewRouter()
+		router.HandleFunc("/subscriptions/{id}/change_plan", ChangeSubscriptionPlan(s.Subscriptions(), down)).Methods("POST")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/subscriptions/1/change_plan", strings.NewReader(`{"license_count": 9}`)))
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var change models.PlanChange
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&change))
+		assert.Equal(t, models.PlanChangeApplied, change.Status)
+		assert.Zero(t, change.Proration)
+	})
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"subscriptions/clients"
+)
+
+// ProductLookup is the part of the products client the controllers depend on.
+type ProductLookup interface {
+	GetOffers(ctx context.Context) ([]clients.Product, error)
+	GetOfferById(ctx context.Context, id int) (*clients.Product, error)
+}
+
+var (
+	errUnknownProduct      = errors.New("unknown product_id")
+	errProductsUnavailable = errors.New("products service unavailable")
+)
+
+// ProductValidator checks product_id values against the products service
+// before subscriptions are written.
+type ProductValidator struct {
+	Products ProductLookup
+	// AllowUnavailable lets writes through when the products service cannot
+	// be reached; unknown products are still rejected.
+	AllowUnavailable bool
+}
+
+// Validate returns errUnknownProduct when the products service does not know
+// productID and errProductsUnavailable when it cannot answer. A nil
+// validator, or one without a products client, accepts everything.
+func (v *ProductValidator) Validate(ctx context.Context, productID int) error {
+	if v == nil || v.Products == nil {
+		return nil
+	}
+
+	_, err := v.Products.GetOfferById(ctx, productID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, clients.ErrNotFound):
+		return errUnknownProduct
+	case errors.Is(err, clients.ErrUnavailable) && v.AllowUnavailable:
+		log.Printf("Products service unavailable, accepting product_id %d unverified: %v", productID, err)
+		return nil
+	default:
+		log.Printf("Products service unavailable: %v", err)
+		return errProductsUnavailable
+	}
+}
+
+// writeProductError writes the response for an error returned by Validate.
+func writeProductError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnknownProduct) {
+		http.Error(w, "Unknown product_id", http.StatusUnprocessableEntity)
+		return
+	}
+	http.Error(w, "Products service unavailable", http.StatusServiceUnavailable)
+}
+
+// wantsExpand reports whether the expand query parameter, a comma separated
+// list, contains field.
+func wantsExpand(r *http.Request, field string) bool {
+	for _, value := range r.URL.Query()["expand"] {
+		for _, f := range strings.Split(value, ",") {
+			if strings.TrimSpace(f) == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupProducts resolves the distinct product ids. A single id is fetched
+// directly; several are batched into one GetOffers call, falling back to
+// single lookups for ids the list does not contain. Products that cannot be
+// resolved are missing from the result, so responses degrade instead of failing.
+func lookupProducts(ctx context.Context, products ProductLookup, ids []int) map[int]*clients.Product {
+	found := map[int]*clients.Product{}
+	if products == nil || len(ids) == 0 {
+		return found
+	}
+
+	wanted := map[int]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	if len(wanted) > 1 {
+		offers, err := products.GetOffers(ctx)
+		if err != nil {
+			log.Printf("Failed to fetch offers for expansion: %v", err)
+		}
+		for i := range offers {
+			if wanted[offers[i].ID] {
+				found[offers[i].ID] = &offers[i]
+			}
+		}
+	}
+
+	for id := range wanted {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		product, err := products.GetOfferById(ctx, id)
+		if err != nil {
+			if !errors.Is(err, clients.ErrNotFound) {
+				log.Printf("Failed to fetch product %d for expansion: %v", id, err)
+			}
+			continue
+		}
+		found[id] = product
+	}
+	return found
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+)
+
+// GetSubscriptionSeats reports license_count, assigned and available seats
+// and the users holding them
+func GetSubscriptionSeats(subscriptions store.SubscriptionStore, seats store.UserSubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermSeatsRead) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		subscription, err := subscriptions.Get(r.Context(), id)
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		assigned, err := seats.Assigned(r.Context(), id)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.NewSeatUsage(subscription, assigned))
+	}
+}
+
+// expandSeats fills in seats_used and seats_available for expand=seats.
+func expandSeats(ctx context.Context, seats store.UserSubscriptionStore, list []models.Subscription) error {
+	ids := make([]int, len(list))
+	for i := range list {
+		ids[i] = list[i].ID
+	}
+	counts, err := seats.CountAssigned(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		used, available := counts[list[i].ID], list[i].AvailableSeats(counts[list[i].ID])
+		list[i].SeatsUsed, list[i].SeatsAvailable = &used, &available
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSubscriptionSeats(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 3, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	empty := models.Subscription{Name: "Empty", ProductID: 101, LicenseCount: 2, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &empty))
+	for _, userID := range []int{7, 8} {
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: userID, SubscriptionID: subscription.ID}))
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions", GetSubscriptions(s.Subscriptions(), s.UserSubscriptions(), nil)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id}", GetSubscriptionByID(s.Subscriptions(), s.UserSubscriptions(), nil)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id}/seats", GetSubscriptionSeats(s.Subscriptions(), s.UserSubscriptions())).Methods("GET")
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	t.Run("seats", func(t *testing.T) {
+		w := get("/subscriptions/1/seats")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var usage models.SeatUsage
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&usage))
+		assert.Equal(t, 3, usage.LicenseCount)
+		assert.Equal(t, 2, usage.Assigned)
+		assert.Equal(t, 1, usage.Available)
+		if assert.Len(t, usage.Users, 2) {
+			assert.Equal(t, 7, usage.Users[0].UserID)
+			assert.Equal(t, 8, usage.Users[1].UserID)
+		}
+
+		assert.Equal(t, http.StatusNotFound, get("/subscriptions/99/seats").Code)
+	})
+
+	t.Run("expand=seats", func(t *testing.T) {
+		var page store.Page[models.Subscription]
+		assert.NoError(t, json.NewDecoder(get("/subscriptions?expand=seats").Body).Decode(&page))
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, 2, *page.Items[0].SeatsUsed)
+			assert.Equal(t, 1, *page.Items[0].SeatsAvailable)
+			assert.Equal(t, 0, *page.Items[1].SeatsUsed)
+			assert.Equal(t, 2, *page.Items[1].SeatsAvailable)
+		}
+
+		var one models.Subscription
+		assert.NoError(t, json.NewDecoder(get("/subscriptions/1?expand=seats").Body).Decode(&one))
+		assert.Equal(t, 2, *one.SeatsUsed)
+
+		assert.NotContains(t, get("/subscriptions/1").Body.String(), "seats_used", "seats are only counted on request")
+	})
+}
+
+func TestCountAssignedQuery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectSubscriptionsQuery)).
+		WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+			AddRow(1, "Team A", 101, 5, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1).
+			AddRow(2, "Team B", 101, 1, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT subscription_id, COUNT(*) FROM user_subscriptions WHERE deleted_at IS NULL AND subscription_id IN ($1, $2) GROUP BY subscription_id")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_id", "count"}).AddRow(1, 3))
+
+	s := store.NewPostgres(db)
+	w := httptest.NewRecorder()
+	GetSubscriptions(s.Subscriptions(), s.UserSubscriptions(), nil).ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions?expand=seats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var page store.Page[models.Subscription]
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	if assert.Len(t, page.Items, 2) {
+		assert.Equal(t, 2, *page.Items[0].SeatsAvailable)
+		assert.Equal(t, 0, *page.Items[1].SeatsUsed)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
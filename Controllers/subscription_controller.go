@@ -1,103 +1,175 @@
 package controllers
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
-	"subscriptions/models"
 	"net/http"
-	"github.com/gorilla/mux"
+	"net/url"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+	"time"
 )
 
 // GetSubscriptions retrieves all subscriptions
 
-func GetSubscriptions(db *sql.DB) http.HandlerFunc {
+func GetSubscriptions(subscriptions store.SubscriptionStore, seats store.UserSubscriptionStore, products ProductLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT * FROM subscriptions WHERE deleted_at IS NULL")
+		if !authorize(w, r, auth.PermSubscriptionsRead) {
+			return
+		}
+
+		query := r.URL.Query()
+		req, err := pageRequest(query)
 		if err != nil {
-			log.Printf("Database error: %v", err)
-			http.Error(w, "database error", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := subscriptionFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
 
-		subscriptions := []models.Subscription{}
-		for rows.Next() {
-			var subscription models.Subscription
-			if err := rows.Scan(&subscription.ID, &subscription.Name, &subscription.ProductID, &subscription.LicenseCount, &subscription.CreatedAt, &subscription.UpdatedAt, &subscription.DeletedAt); err != nil {
-				log.Printf("Row scan error: %v", err)
-				http.Error(w, "error processing database results", http.StatusInternalServerError)
-				return
+		page, err := subscriptions.List(r.Context(), filter, req)
+		if err != nil {
+			writeListError(w, err)
+			return
+		}
+
+		if wantsExpand(r, "product") {
+			list := page.Items
+			ids := make([]int, len(list))
+			for i := range list {
+				ids[i] = list[i].ProductID
+			}
+			found := lookupProducts(r.Context(), products, ids)
+			for i := range list {
+				list[i].Product = found[list[i].ProductID]
 			}
-			subscriptions = append(subscriptions, subscription)
 		}
-		if err := rows.Err(); err != nil {
-			log.Printf("Iteration error: %v", err)
-			http.Error(w, "error iterating over results", http.StatusInternalServerError)
-			return
+		if wantsExpand(r, "seats") {
+			if err := expandSeats(r.Context(), seats, page.Items); err != nil {
+				log.Printf("Database error: %v", err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(subscriptions)
+		json.NewEncoder(w).Encode(page)
 	}
 }
 
-// func GetSubscriptions(db *sql.DB) http.HandlerFunc {
-// 	return func(w http.ResponseWriter, r *http.Request) {
-// 		rows, err := db.Query("SELECT * FROM subscriptions WHERE deleted_at IS NULL")
-// 		if err != nil {
-// 			log.Fatal(err)
-// 		}
-// 		defer rows.Close()
-
-// 		subscriptions := []models.Subscription{}
-// 		for rows.Next() {
-// 			var subscription models.Subscription
-// 			if err := rows.Scan(&subscription.ID, &subscription.Name, &subscription.ProductID, &subscription.LicenseCount, &subscription.CreatedAt, &subscription.UpdatedAt, &subscription.DeletedAt); err != nil {
-// 				log.Fatal(err)
-// 			}
-// 			subscriptions = append(subscriptions, subscription)
-// 		}
-// 		if err := rows.Err(); err != nil {
-// 			log.Fatal(err)
-// 		}
-
-// 		w.Header().Set("Content-Type", "application/json")
-// 		json.NewEncoder(w).Encode(subscriptions)
-// 	}
-// }
+// subscriptionFilter reads organization_id, product_id, name_prefix, status, created_after
+// and created_before from the query.
+func subscriptionFilter(query url.Values) (store.SubscriptionFilter, error) {
+	var filter store.SubscriptionFilter
+	var err error
+	if filter.OrganizationID, err = queryInt(query, "organization_id"); err != nil {
+		return filter, err
+	}
+	if filter.ProductID, err = queryInt(query, "product_id"); err != nil {
+		return filter, err
+	}
+	filter.NamePrefix = query.Get("name_prefix")
+	if status := models.SubscriptionStatus(query.Get("status")); status != "" {
+		if !status.Valid() {
+			return filter, errors.New("Invalid status")
+		}
+		filter.Status = status
+	}
+	if filter.CreatedAfter, err = queryTime(query, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = queryTime(query, "created_before"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
 
 // GetSubscriptionByID retrieves a subscription by ID
-func GetSubscriptionByID(db *sql.DB) http.HandlerFunc {
+func GetSubscriptionByID(subscriptions store.SubscriptionStore, seats store.UserSubscriptionStore, products ProductLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		if !authorize(w, r, auth.PermSubscriptionsRead) {
+			return
+		}
 
-		var subscription models.Subscription
-		err := db.QueryRow("SELECT * FROM subscriptions WHERE id = $1 AND deleted_at IS NULL", id).
-			Scan(&subscription.ID, &subscription.Name, &subscription.ProductID, &subscription.LicenseCount, &subscription.CreatedAt, &subscription.UpdatedAt, &subscription.DeletedAt)
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		subscription, err := subscriptions.Get(r.Context(), id)
 		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
 			http.Error(w, "Subscription not found", http.StatusNotFound)
 			return
 		}
 
+		if wantsExpand(r, "product") {
+			subscription.Product = lookupProducts(r.Context(), products, []int{subscription.ProductID})[subscription.ProductID]
+		}
+		if wantsExpand(r, "seats") {
+			list := []models.Subscription{subscription}
+			if err := expandSeats(r.Context(), seats, list); err != nil {
+				log.Printf("Database error: %v", err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			subscription = list[0]
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(subscription)
 	}
 }
 
-// CreateSubscription creates a new subscription
+// CreateSubscription creates a new subscription for the organization of the
+// request, or the organization_id in the body when the request is not scoped
 
-func CreateSubscription(db *sql.DB) http.HandlerFunc {
+func CreateSubscription(subscriptions store.SubscriptionStore, organizations store.OrganizationStore, products *ProductValidator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var subscription models.Subscription
+		if !authorize(w, r, auth.PermSubscriptionsWrite) {
+			return
+		}
+
+		// Subscriptions renew automatically unless the request opts out
+		subscription := models.Subscription{AutoRenew: true}
 		if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		err := db.QueryRow("INSERT INTO subscriptions (name, product_id, license_count) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at", subscription.Name, subscription.ProductID, subscription.LicenseCount).
-			Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+		if err := validateBillingInterval(subscription); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateTrial(subscription, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if subscription.DiscountPercent < 0 || subscription.DiscountPercent > 100 {
+			http.Error(w, "discount_percent must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		if !resolveOrganization(w, r, organizations, &subscription) {
+			return
+		}
+
+		if err := products.Validate(r.Context(), subscription.ProductID); err != nil {
+			writeProductError(w, err)
+			return
+		}
+
+		err := subscriptions.Create(r.Context(), &subscription)
+		if errors.Is(err, store.ErrOrganizationRequired) {
+			http.Error(w, "Organization not found", http.StatusUnprocessableEntity)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -109,56 +181,161 @@ func CreateSubscription(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// func CreateSubscription(db *sql.DB) http.HandlerFunc {
-// 	return func(w http.ResponseWriter, r *http.Request) {
-// 		var subscription models.Subscription
-// 		if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
-// 			http.Error(w, err.Error(), http.StatusBadRequest)
-// 			return
-// 		}
-
-// 		err := db.QueryRow("INSERT INTO subscriptions (name, product_id, license_count) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at", subscription.Name, subscription.ProductID, subscription.LicenseCount).
-// 			Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
-// 		if err != nil {
-// 			http.Error(w, err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
-
-// 		w.Header().Set("Content-Type", "application/json")
-// 		json.NewEncoder(w).Encode(subscription)
-// 	}
-// }
-
-// UpdateSubscription updates an existing subscription
-func UpdateSubscription(db *sql.DB) http.HandlerFunc {
+// validateBillingInterval checks billing_interval and interval_days; an
+// empty interval defaults to monthly.
+func validateBillingInterval(subscription models.Subscription) error {
+	if subscription.BillingInterval != "" && !subscription.BillingInterval.Valid() {
+		return errors.New("Invalid billing_interval (want monthly, annual or custom)")
+	}
+	if subscription.BillingInterval == models.IntervalCustom && subscription.IntervalDays < 1 {
+		return errors.New("interval_days must be positive for a custom billing_interval")
+	}
+	return nil
+}
+
+// validateTrial checks the trial settings of a new subscription.
+func validateTrial(subscription models.Subscription, now time.Time) error {
+	if subscription.TrialEnd != nil && !subscription.TrialEnd.After(now) {
+		return errors.New("trial_end must be in the future")
+	}
+	if subscription.TrialSeatLimit < 0 {
+		return errors.New("trial_seat_limit must not be negative")
+	}
+	if subscription.TrialSeatLimit > 0 && subscription.TrialEnd == nil {
+		return errors.New("trial_seat_limit requires trial_end")
+	}
+	return nil
+}
+
+// UpdateSubscription updates an existing subscription. A different
+// product_id or license_count is applied at once as an unprorated plan
+// change; use change_plan to prorate or to wait for the end of the period.
+// Lowering license_count below the assigned seats fails with 409 unless
+// seat_policy=revoke is given, which revokes the most recently assigned
+// seats and responds with the subscription and the revoked seat ids.
+func UpdateSubscription(subscriptions store.SubscriptionStore, products *ProductValidator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		if !authorize(w, r, auth.PermSubscriptionsWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		policy, ok := seatPolicy(w, r)
+		if !ok {
+			return
+		}
 
 		var subscription models.Subscription
 		if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if subscription.ProductID < 0 || subscription.LicenseCount < 0 {
+			http.Error(w, "product_id and license_count must not be negative", http.StatusBadRequest)
+			return
+		}
 
-		_, err := db.Exec("UPDATE subscriptions SET name = $1, product_id = $2, license_count = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND deleted_at IS NULL", subscription.Name, subscription.ProductID, subscription.LicenseCount, id)
+		current, err := subscriptions.Get(r.Context(), id)
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		change := models.PlanChange{
+			SubscriptionID:   id,
+			FromProductID:    current.ProductID,
+			FromLicenseCount: current.LicenseCount,
+			ToProductID:      current.ProductID,
+			ToLicenseCount:   current.LicenseCount,
+			Timing:           models.ChangeImmediately,
+		}
+		if subscription.ProductID != 0 {
+			change.ToProductID = subscription.ProductID
+		}
+		if subscription.LicenseCount != 0 {
+			change.ToLicenseCount = subscription.LicenseCount
+		}
+
+		var revoked []int
+		if change.ToProductID != change.FromProductID || change.ToLicenseCount != change.FromLicenseCount {
+			if change.ToProductID != change.FromProductID {
+				if err := products.Validate(r.Context(), change.ToProductID); err != nil {
+					writeProductError(w, err)
+					return
+				}
+			}
+			revoked, err = subscriptions.ChangePlan(r.Context(), &change, policy, time.Now())
+			if err != nil {
+				writePlanChangeError(w, err)
+				return
+			}
+		}
+
+		err = subscriptions.Update(r.Context(), id, &subscription)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, store.ErrPlanChangeRequired) {
+			// The plan moved on between the change above and the rename
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// Respond with the subscription as stored, not as requested
+		updated, err := subscriptions.Get(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(subscription)
+		if policy == store.SeatPolicyRevoke {
+			if revoked == nil {
+				revoked = []int{}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"subscription":                  updated,
+				"revoked_user_subscription_ids": revoked,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(updated)
 	}
 }
 
 // DeleteSubscription deletes a subscription (soft delete)
-func DeleteSubscription(db *sql.DB) http.HandlerFunc {
+func DeleteSubscription(subscriptions store.SubscriptionStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		if !authorize(w, r, auth.PermSubscriptionsWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
 
-		_, err := db.Exec("UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+		err := subscriptions.Delete(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -167,3 +344,67 @@ func DeleteSubscription(db *sql.DB) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// TransitionSubscription applies a lifecycle event such as pause, resume or
+// cancel. Events the current status does not allow are rejected with 409.
+func TransitionSubscription(subscriptions store.SubscriptionStore, event models.SubscriptionEvent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermSubscriptionsWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		subscription, err := subscriptions.Transition(r.Context(), id, event, time.Now())
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subscription)
+	}
+}
+
+// GetSubscriptionRenewals lists the renewals recorded for a subscription
+func GetSubscriptionRenewals(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermSubscriptionsRead) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		if _, err := subscriptions.Get(r.Context(), id); err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("Database error: %v", err)
+			}
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+
+		renewals, err := subscriptions.Renewals(r.Context(), id)
+		if err != nil {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(renewals)
+	}
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
@@ -9,8 +10,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strconv"
 	"strings"
+	"subscriptions/clients"
 	"subscriptions/models"
+	"subscriptions/store"
 	"testing"
 	"time"
 
@@ -36,6 +40,17 @@ func (m *MockDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	return nil, argsList.Error(1)
 }
 
+// subscriptionColumns are the columns the Postgres store reads a subscription from.
+var subscriptionColumns = []string{
+	"id", "name", "product_id", "license_count", "status", "created_at", "updated_at", "deleted_at",
+	"billing_interval", "interval_days", "auto_renew", "current_period_start", "current_period_end",
+	"trial_end", "trial_seat_limit", "discount_percent", "organization_id",
+}
+
+const selectSubscriptionsQuery = "SELECT id, name, product_id, license_count, status, created_at, updated_at, deleted_at, " +
+	"billing_interval, interval_days, auto_renew, current_period_start, current_period_end, trial_end, trial_seat_limit, discount_percent, organization_id " +
+	"FROM subscriptions WHERE deleted_at IS NULL"
+
 func TestGetSubscriptions(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -53,8 +68,8 @@ func TestGetSubscriptions(t *testing.T) {
 		{
 			name: "success - subscriptions found",
 			mockData: [][]interface{}{
-				{1, "Sub1", 101, 5, time.Now(), time.Now(), nil},
-				{2, "Sub2", 102, 10, time.Now(), time.Now(), nil},
+				{1, "Sub1", 101, 5, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1},
+				{2, "Sub2", 102, 10, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1},
 			},
 			expectedLen:  2,
 			expectedCode: http.StatusOK,
@@ -76,12 +91,12 @@ func TestGetSubscriptions(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			query := `SELECT \* FROM subscriptions WHERE deleted_at IS NULL`
+			query := regexp.QuoteMeta(selectSubscriptionsQuery)
 
 			if tc.mockError != nil {
 				mock.ExpectQuery(query).WillReturnError(tc.mockError)
 			} else {
-				rows := sqlmock.NewRows([]string{"id", "name", "product_id", "license_count", "created_at", "updated_at", "deleted_at"})
+				rows := sqlmock.NewRows(subscriptionColumns)
 				for _, row := range tc.mockData {
 					var values []driver.Value
 					for _, v := range row {
@@ -95,17 +110,17 @@ func TestGetSubscriptions(t *testing.T) {
 			req := httptest.NewRequest("GET", "/subscriptions", nil)
 			w := httptest.NewRecorder()
 
-			handler := GetSubscriptions(db)
+			handler := GetSubscriptions(store.NewPostgres(db).Subscriptions(), nil, nil)
 			handler.ServeHTTP(w, req)
 
 			assert.Equal(t, tc.expectedCode, w.Code)
 
 			if tc.mockError == nil {
-				var subscriptions []models.Subscription
-				if err := json.NewDecoder(w.Body).Decode(&subscriptions); err != nil {
+				var page store.Page[models.Subscription]
+				if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response: %v", err)
 				}
-				assert.Len(t, subscriptions, tc.expectedLen)
+				assert.Len(t, page.Items, tc.expectedLen)
 			}
 
 			assert.NoError(t, mock.ExpectationsWereMet())
@@ -113,6 +128,67 @@ func TestGetSubscriptions(t *testing.T) {
 	}
 }
 
+func TestGetSubscriptionsPaging(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error initializing sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	t.Run("filters, sort and total reach the query", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM subscriptions WHERE deleted_at IS NULL AND product_id = $1 AND starts_with(name, $2)")).
+			WithArgs(101, "Team").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(selectSubscriptionsQuery + " AND product_id = $1 AND starts_with(name, $2) ORDER BY name DESC, id DESC LIMIT 3")).
+			WithArgs(101, "Team").
+			WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+				AddRow(3, "Team C", 101, 1, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1).
+				AddRow(2, "Team B", 101, 1, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1).
+				AddRow(1, "Team A", 101, 1, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1))
+
+		w := httptest.NewRecorder()
+		GetSubscriptions(store.NewPostgres(db).Subscriptions(), nil, nil).
+			ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions?product_id=101&name_prefix=Team&sort=-name&limit=2&include_total=true", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var page store.Page[models.Subscription]
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		assert.Len(t, page.Items, 2)
+		assert.NotEmpty(t, page.NextCursor)
+		if assert.NotNil(t, page.Total) {
+			assert.Equal(t, 3, *page.Total)
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta(selectSubscriptionsQuery + " AND (name, id) < ($1, $2) ORDER BY name DESC, id DESC LIMIT 3")).
+			WithArgs("Team B", 2).
+			WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+				AddRow(1, "Team A", 101, 1, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1))
+
+		w = httptest.NewRecorder()
+		GetSubscriptions(store.NewPostgres(db).Subscriptions(), nil, nil).
+			ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions?sort=-name&limit=2&cursor="+page.NextCursor, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	for _, target := range []string{
+		"/subscriptions?limit=abc",
+		"/subscriptions?limit=0",
+		"/subscriptions?sort=deleted_at",
+		"/subscriptions?cursor=garbage",
+		"/subscriptions?product_id=x",
+		"/subscriptions?created_after=yesterday",
+		"/subscriptions?include_total=maybe",
+	} {
+		t.Run(target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetSubscriptions(store.NewPostgres(db).Subscriptions(), nil, nil).ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestGetSubscriptionByID(t *testing.T) {
 	type testCase struct {
 		name      string
@@ -127,7 +203,7 @@ func TestGetSubscriptionByID(t *testing.T) {
 			name:  "success - valid subscription",
 			subID: "1",
 			mockData: []interface{}{
-				1, "Basic Plan", 101, 10, time.Now(), time.Now(), nil,
+				1, "Basic Plan", 101, 10, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1,
 			},
 			expectErr: false,
 		},
@@ -151,27 +227,28 @@ func TestGetSubscriptionByID(t *testing.T) {
 			db, mock, err := sqlmock.New()
 			assert.NoError(t, err)
 
-			query := regexp.QuoteMeta(`SELECT * FROM subscriptions WHERE id = $1 AND deleted_at IS NULL`)
+			query := regexp.QuoteMeta(selectSubscriptionsQuery + " AND id = $1")
+			subID, _ := strconv.Atoi(tc.subID)
 
 			if tc.mockError != nil {
-				mock.ExpectQuery(query).WithArgs(tc.subID).WillReturnError(tc.mockError)
+				mock.ExpectQuery(query).WithArgs(subID).WillReturnError(tc.mockError)
 			} else if tc.mockData != nil {
 				rowValues := make([]driver.Value, len(tc.mockData))
 				for i, v := range tc.mockData {
 					rowValues[i] = v
 				}
 
-				rows := sqlmock.NewRows([]string{"id", "name", "product_id", "license_count", "created_at", "updated_at", "deleted_at"}).
+				rows := sqlmock.NewRows(subscriptionColumns).
 					AddRow(rowValues...)
 
-				mock.ExpectQuery(query).WithArgs(tc.subID).WillReturnRows(rows).RowsWillBeClosed()
+				mock.ExpectQuery(query).WithArgs(subID).WillReturnRows(rows).RowsWillBeClosed()
 			}
 
 			req := httptest.NewRequest("GET", "/subscription/"+tc.subID, nil)
 			w := httptest.NewRecorder()
 			req = mux.SetURLVars(req, map[string]string{"id": tc.subID})
 
-			handler := GetSubscriptionByID(db)
+			handler := GetSubscriptionByID(store.NewPostgres(db).Subscriptions(), nil, nil)
 			handler.ServeHTTP(w, req)
 
 			// Debugging logs
@@ -199,6 +276,14 @@ func TestCreateSubscription(t *testing.T) {
 	assert.NoError(t, err)
 	defer db.Close()
 
+	insertQuery := regexp.QuoteMeta("INSERT INTO subscriptions (name, product_id, license_count, status, billing_interval, interval_days, auto_renew, current_period_start, current_period_end, trial_end, trial_seat_limit, discount_percent, organization_id) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id, created_at, updated_at")
+	organizationQuery := regexp.QuoteMeta("SELECT id, name, created_at, updated_at FROM organizations WHERE id = $1 AND deleted_at IS NULL")
+	organizationRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).AddRow(3, "Acme", time.Now(), time.Now())
+	}
+	existsQuery := regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1 AND deleted_at IS NULL)")
+
 	testCases := []struct {
 		name         string
 		requestBody  string
@@ -207,11 +292,13 @@ func TestCreateSubscription(t *testing.T) {
 	}{
 		{
 			name:         "success - valid request",
-			requestBody:  `{"name": "Premium Subscription", "product_id": 101, "license_count": 10}`,
+			requestBody:  `{"name": "Premium Subscription", "product_id": 101, "license_count": 10, "organization_id": 3}`,
 			expectedCode: http.StatusCreated,
 			mockQueries: func() {
-				mock.ExpectQuery(`INSERT INTO subscriptions \(name, product_id, license_count\) VALUES \(\$1, \$2, \$3\) RETURNING id, created_at, updated_at`).
-					WithArgs("Premium Subscription", 101, 10).
+				mock.ExpectQuery(organizationQuery).WithArgs(3).WillReturnRows(organizationRows())
+				mock.ExpectQuery(existsQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(insertQuery).
+					WithArgs("Premium Subscription", 101, 10, models.StatusActive, models.IntervalMonthly, 0, true, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, 0.0, 3).
 					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
 						AddRow(1, time.Now(), time.Now()))
 			},
@@ -226,14 +313,30 @@ func TestCreateSubscription(t *testing.T) {
 		},
 		{
 			name:         "failure - database error on insert",
-			requestBody:  `{"name": "Standard Subscription", "product_id": 102, "license_count": 5}`,
+			requestBody:  `{"name": "Standard Subscription", "product_id": 102, "license_count": 5, "organization_id": 3}`,
 			expectedCode: http.StatusInternalServerError,
 			mockQueries: func() {
-				mock.ExpectQuery(`INSERT INTO subscriptions \(name, product_id, license_count\) VALUES \(\$1, \$2, \$3\) RETURNING id, created_at, updated_at`).
-					WithArgs("Standard Subscription", 102, 5).
+				mock.ExpectQuery(organizationQuery).WithArgs(3).WillReturnRows(organizationRows())
+				mock.ExpectQuery(existsQuery).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(insertQuery).
+					WithArgs("Standard Subscription", 102, 5, models.StatusActive, models.IntervalMonthly, 0, true, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, 0.0, 3).
 					WillReturnError(errors.New("insert error"))
 			},
 		},
+		{
+			name:         "failure - missing organization",
+			requestBody:  `{"name": "Standard Subscription", "product_id": 102, "license_count": 5}`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func() {},
+		},
+		{
+			name:         "failure - unknown organization",
+			requestBody:  `{"name": "Standard Subscription", "product_id": 102, "license_count": 5, "organization_id": 9}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			mockQueries: func() {
+				mock.ExpectQuery(organizationQuery).WithArgs(9).WillReturnError(sql.ErrNoRows)
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -244,7 +347,7 @@ func TestCreateSubscription(t *testing.T) {
 			req.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 
-			handler := CreateSubscription(db)
+			handler := CreateSubscription(store.NewPostgres(db).Subscriptions(), store.NewPostgres(db).Organizations(), nil)
 			handler.ServeHTTP(w, req)
 
 			assert.Equal(t, tc.expectedCode, w.Code)
@@ -258,40 +361,82 @@ func TestUpdateSubscription(t *testing.T) {
 	assert.NoError(t, err)
 	defer db.Close()
 
+	lockQuery := regexp.QuoteMeta(`SELECT product_id, license_count FROM subscriptions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+	updateQuery := regexp.QuoteMeta(`UPDATE subscriptions SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`)
+	planRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"product_id", "license_count"}).AddRow(2, 5)
+	}
+	getQuery := regexp.QuoteMeta(selectSubscriptionsQuery + " AND id = $1")
+	storedRows := func(name string) *sqlmock.Rows {
+		return sqlmock.NewRows(subscriptionColumns).
+			AddRow(1, name, 2, 5, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1)
+	}
+
 	testCases := []struct {
-		name         string
+		name           string
 		subscriptionID string
-		requestBody  string
-		expectedCode int
-		mockQueries  func()
+		requestBody    string
+		expectedCode   int
+		expectedBody   string
+		mockQueries    func()
 	}{
 		{
-			name:         "success - valid request",
+			name:           "success - valid request",
 			subscriptionID: "1",
-			requestBody:  `{"name": "Updated Subscription Name", "product_id": 2, "license_count": 5}`,
-			expectedCode: http.StatusOK,
+			requestBody:    `{"name": "Updated Subscription Name", "product_id": 2, "license_count": 5}`,
+			expectedCode:   http.StatusOK,
+			expectedBody:   `"status":"active"`,
 			mockQueries: func() {
-				mock.ExpectExec(`UPDATE subscriptions SET name = \$1, product_id = \$2, license_count = \$3, updated_at = CURRENT_TIMESTAMP WHERE id = \$4 AND deleted_at IS NULL`).
-					WithArgs("Updated Subscription Name", 2, 5, "1").
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(storedRows("Team"))
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(planRows())
+				mock.ExpectExec(updateQuery).WithArgs("Updated Subscription Name", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(storedRows("Updated Subscription Name"))
 			},
 		},
 		{
-			name:         "failure - invalid JSON",
+			name:           "success - name only",
 			subscriptionID: "1",
-			requestBody:  `{"name": }`, // Malformed JSON
-			expectedCode: http.StatusBadRequest,
-			mockQueries:  func() {}, // No DB queries should run because JSON is invalid
+			requestBody:    `{"name": "Renamed"}`,
+			expectedCode:   http.StatusOK,
+			expectedBody:   `"license_count":5`,
+			mockQueries: func() {
+				mock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(storedRows("Team"))
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(planRows())
+				mock.ExpectExec(updateQuery).WithArgs("Renamed", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(storedRows("Renamed"))
+			},
 		},
 		{
-			name:         "failure - database error on update",
+			name:           "failure - invalid JSON",
 			subscriptionID: "1",
-			requestBody:  `{"name": "New Subscription Name", "product_id": 3, "license_count": 10}`,
-			expectedCode: http.StatusInternalServerError,
+			requestBody:    `{"name": }`, // Malformed JSON
+			expectedCode:   http.StatusBadRequest,
+			mockQueries:    func() {}, // No DB queries should run because JSON is invalid
+		},
+		{
+			name:           "failure - database error on update",
+			subscriptionID: "1",
+			requestBody:    `{"name": "New Subscription Name"}`,
+			expectedCode:   http.StatusInternalServerError,
 			mockQueries: func() {
-				mock.ExpectExec(`UPDATE subscriptions SET name = \$1, product_id = \$2, license_count = \$3, updated_at = CURRENT_TIMESTAMP WHERE id = \$4 AND deleted_at IS NULL`).
-					WithArgs("New Subscription Name", 3, 10, "1").
-					WillReturnError(errors.New("update error"))
+				mock.ExpectQuery(getQuery).WithArgs(1).WillReturnRows(storedRows("Team"))
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(planRows())
+				mock.ExpectExec(updateQuery).WithArgs("New Subscription Name", 1).WillReturnError(errors.New("update error"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "failure - subscription not found",
+			subscriptionID: "9",
+			requestBody:    `{"name": "Team"}`,
+			expectedCode:   http.StatusNotFound,
+			mockQueries: func() {
+				mock.ExpectQuery(getQuery).WithArgs(9).WillReturnError(sql.ErrNoRows)
 			},
 		},
 	}
@@ -305,10 +450,11 @@ func TestUpdateSubscription(t *testing.T) {
 			w := httptest.NewRecorder()
 			req = mux.SetURLVars(req, map[string]string{"id": tc.subscriptionID})
 
-			handler := UpdateSubscription(db)
+			handler := UpdateSubscription(store.NewPostgres(db).Subscriptions(), nil)
 			handler.ServeHTTP(w, req)
 
 			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.expectedBody)
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
@@ -330,18 +476,28 @@ func TestDeleteSubscription(t *testing.T) {
 			subscriptionID: "1",
 			expectedCode: http.StatusNoContent,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs("1").
+				mock.ExpectExec(`UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND deleted_at IS NULL`).
+					WithArgs(1).
 					WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
 			},
 		},
+		{
+			name:         "failure - already deleted",
+			subscriptionID: "1",
+			expectedCode: http.StatusNotFound,
+			mockExec: func() {
+				mock.ExpectExec(`UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND deleted_at IS NULL`).
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
 		{
 			name:         "failure - database error",
 			subscriptionID: "1",
 			expectedCode: http.StatusInternalServerError,
 			mockExec: func() {
-				mock.ExpectExec(`UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1`).
-					WithArgs("1").
+				mock.ExpectExec(`UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = \$1 AND deleted_at IS NULL`).
+					WithArgs(1).
 					WillReturnError(errors.New("database error"))
 			},
 		},
@@ -355,7 +511,7 @@ func TestDeleteSubscription(t *testing.T) {
 			req = mux.SetURLVars(req, map[string]string{"id": tc.subscriptionID})
 			w := httptest.NewRecorder()
 
-			handler := DeleteSubscription(db)
+			handler := DeleteSubscription(store.NewPostgres(db).Subscriptions())
 			handler.ServeHTTP(w, req)
 
 			assert.Equal(t, tc.expectedCode, w.Code)
@@ -372,3 +528,337 @@ func TestDeleteSubscription(t *testing.T) {
 
 
 
+
+// fakeProducts answers lookups from a fixed set of products, or fails every
+// lookup with err when it is set. It counts the calls it receives.
+type fakeProducts struct {
+	products   map[int]clients.Product
+	err        error
+	offerCalls int
+	listCalls  int
+}
+
+func (f *fakeProducts) GetOffers(ctx context.Context) ([]clients.Product, error) {
+	f.listCalls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	offers := []clients.Product{}
+	for _, product := range f.products {
+		offers = append(offers, product)
+	}
+	return offers, nil
+}
+
+func (f *fakeProducts) GetOfferById(ctx context.Context, id int) (*clients.Product, error) {
+	f.offerCalls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	product, ok := f.products[id]
+	if !ok {
+		return nil, clients.ErrNotFound
+	}
+	return &product, nil
+}
+
+func TestSubscriptionWritesValidateProduct(t *testing.T) {
+	known := &fakeProducts{products: map[int]clients.Product{101: {ID: 101, Name: "Editor", Price: 10}}}
+	down := &fakeProducts{err: fmt.Errorf("%w: connection refused", clients.ErrUnavailable)}
+
+	testCases := []struct {
+		name         string
+		method       string
+		validator    *ProductValidator
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "create - known product", method: "POST", validator: &ProductValidator{Products: known}, requestBody: `{"name": "Team", "product_id": 101, "license_count": 5}`, expectedCode: http.StatusCreated},
+		{name: "create - unknown product", method: "POST", validator: &ProductValidator{Products: known}, requestBody: `{"name": "Team", "product_id": 999, "license_count": 5}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "create - products service down", method: "POST", validator: &ProductValidator{Products: down}, requestBody: `{"name": "Team", "product_id": 101, "license_count": 5}`, expectedCode: http.StatusServiceUnavailable},
+		{name: "create - products service down, allowed", method: "POST", validator: &ProductValidator{Products: down, AllowUnavailable: true}, requestBody: `{"name": "Team", "product_id": 101, "license_count": 5}`, expectedCode: http.StatusCreated},
+		{name: "create - validation disabled", method: "POST", validator: nil, requestBody: `{"name": "Team", "product_id": 999, "license_count": 5}`, expectedCode: http.StatusCreated},
+		{name: "update - same product", method: "PUT", validator: &ProductValidator{Products: down}, requestBody: `{"name": "Team", "product_id": 102, "license_count": 5}`, expectedCode: http.StatusOK},
+		{name: "update - product change", method: "PUT", validator: &ProductValidator{Products: known}, requestBody: `{"name": "Team", "product_id": 101, "license_count": 5}`, expectedCode: http.StatusOK},
+		{name: "update - unknown product", method: "PUT", validator: &ProductValidator{Products: known}, requestBody: `{"name": "Team", "product_id": 999, "license_count": 5}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "update - products service down", method: "PUT", validator: &ProductValidator{Products: down}, requestBody: `{"name": "Team", "product_id": 101, "license_count": 5}`, expectedCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := store.NewMemory()
+			organization := models.Organization{Name: "Acme"}
+			assert.NoError(t, s.Organizations().Create(context.Background(), &organization))
+			ctx := store.WithOrganization(context.Background(), organization.ID)
+			existing := models.Subscription{Name: "Team", ProductID: 102, LicenseCount: 5}
+			assert.NoError(t, s.Subscriptions().Create(ctx, &existing))
+
+			req := httptest.NewRequest(tc.method, "/subscriptions", strings.NewReader(tc.requestBody)).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			if tc.method == "PUT" {
+				req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(existing.ID)})
+				UpdateSubscription(s.Subscriptions(), tc.validator).ServeHTTP(w, req)
+			} else {
+				CreateSubscription(s.Subscriptions(), s.Organizations(), tc.validator).ServeHTTP(w, req)
+			}
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestUpdateSubscriptionChangesPlanInMemory(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 4, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	for userID := 1; userID <= 3; userID++ {
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: userID, SubscriptionID: subscription.ID}))
+	}
+
+	products := &ProductValidator{Products: &fakeProducts{products: map[int]clients.Product{
+		101: {ID: 101, Name: "Editor", Price: 10},
+		102: {ID: 102, Name: "Viewer", Price: 2},
+	}}}
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions/{id}", UpdateSubscription(s.Subscriptions(), products)).Methods("PUT")
+	do := func(target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("PUT", target, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("/subscriptions/1", `{"name": "Team", "license_count": 6}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated models.Subscription
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&updated))
+	assert.Equal(t, 6, updated.LicenseCount)
+
+	w = do("/subscriptions/1", `{"name": "Team", "license_count": 1}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"overage":2`)
+
+	w = do("/subscriptions/1?seat_policy=revoke", `{"name": "Viewers", "product_id": 102, "license_count": 2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Subscription models.Subscription `json:"subscription"`
+		Revoked      []int               `json:"revoked_user_subscription_ids"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "Viewers", body.Subscription.Name)
+	assert.Equal(t, 102, body.Subscription.ProductID)
+	assert.Equal(t, 2, body.Subscription.LicenseCount)
+	assert.Equal(t, []int{3}, body.Revoked)
+
+	changes, err := s.Subscriptions().PlanChanges(ctx, subscription.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 2, "PUT records its changes like change_plan") {
+		for _, change := range changes {
+			assert.Equal(t, models.ChangeImmediately, change.Timing)
+			assert.Zero(t, change.Proration, "PUT does not prorate")
+		}
+	}
+}
+
+func TestGetSubscriptionsExpandProduct(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	for _, productID := range []int{101, 102, 101, 999} {
+		subscription := models.Subscription{Name: "Team", ProductID: productID, LicenseCount: 1, OrganizationID: 1}
+		assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	}
+
+	products := &fakeProducts{products: map[int]clients.Product{
+		101: {ID: 101, Name: "Editor", Price: 10},
+		102: {ID: 102, Name: "Viewer", Price: 2},
+	}}
+
+	t.Run("list is batched", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		GetSubscriptions(s.Subscriptions(), s.UserSubscriptions(), products).ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions?expand=product", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var page store.Page[models.Subscription]
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		subscriptions := page.Items
+		assert.Len(t, subscriptions, 4)
+		assert.Equal(t, "Editor", subscriptions[0].Product.Name)
+		assert.Equal(t, "Viewer", subscriptions[1].Product.Name)
+		assert.Equal(t, "Editor", subscriptions[2].Product.Name)
+		assert.Nil(t, subscriptions[3].Product, "unknown products are left out")
+
+		assert.Equal(t, 1, products.listCalls)
+		assert.Equal(t, 1, products.offerCalls, "only the id missing from the list is looked up")
+	})
+
+	t.Run("single subscription", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/subscriptions/2?expand=product", nil), map[string]string{"id": "2"})
+		w := httptest.NewRecorder()
+		GetSubscriptionByID(s.Subscriptions(), s.UserSubscriptions(), products).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var subscription models.Subscription
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&subscription))
+		if assert.NotNil(t, subscription.Product) {
+			assert.Equal(t, 2.0, subscription.Product.Price)
+		}
+	})
+
+	t.Run("not expanded by default", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		GetSubscriptions(s.Subscriptions(), s.UserSubscriptions(), products).ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions", nil))
+		assert.NotContains(t, w.Body.String(), `"product"`)
+	})
+}
+
+func TestTransitionSubscription(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.Equal(t, models.StatusActive, subscription.Status)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions/{id}/pause", TransitionSubscription(s.Subscriptions(), models.EventPause)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/resume", TransitionSubscription(s.Subscriptions(), models.EventResume)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/cancel", TransitionSubscription(s.Subscriptions(), models.EventCancel)).Methods("POST")
+
+	steps := []struct {
+		name           string
+		target         string
+		expectedCode   int
+		expectedStatus models.SubscriptionStatus
+	}{
+		{name: "resume active", target: "/subscriptions/1/resume", expectedCode: http.StatusConflict},
+		{name: "pause", target: "/subscriptions/1/pause", expectedCode: http.StatusOK, expectedStatus: models.StatusPaused},
+		{name: "pause paused", target: "/subscriptions/1/pause", expectedCode: http.StatusConflict},
+		{name: "resume", target: "/subscriptions/1/resume", expectedCode: http.StatusOK, expectedStatus: models.StatusActive},
+		{name: "cancel", target: "/subscriptions/1/cancel", expectedCode: http.StatusOK, expectedStatus: models.StatusCanceled},
+		{name: "canceled is terminal", target: "/subscriptions/1/resume", expectedCode: http.StatusConflict},
+		{name: "missing", target: "/subscriptions/42/pause", expectedCode: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", step.target, nil))
+			assert.Equal(t, step.expectedCode, w.Code)
+			if step.expectedCode == http.StatusOK {
+				var got models.Subscription
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+				assert.Equal(t, step.expectedStatus, got.Status)
+			}
+		})
+	}
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		lockQuery := regexp.QuoteMeta(`SELECT status, billing_interval, interval_days FROM subscriptions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+		lockRows := func(status string) *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"status", "billing_interval", "interval_days"}).AddRow(status, "monthly", 0)
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(lockRows("active"))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE subscriptions SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING`)).
+			WithArgs(models.StatusPaused, 1).
+			WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+				AddRow(1, "Team", 101, 1, "paused", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now(), nil, 0, 0.0, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(lockRows("expired"))
+		mock.ExpectRollback()
+
+		handler := TransitionSubscription(store.NewPostgres(db).Subscriptions(), models.EventPause)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, mux.SetURLVars(httptest.NewRequest("POST", "/subscriptions/1/pause", nil), map[string]string{"id": "1"}))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, mux.SetURLVars(httptest.NewRequest("POST", "/subscriptions/1/pause", nil), map[string]string{"id": "1"}))
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "cannot pause a subscription that is expired")
+
+		// Resuming restarts the period instead of catching up on the paused months
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(lockRows("paused"))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE subscriptions SET status = $1, current_period_start = $2, current_period_end = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 RETURNING`)).
+			WithArgs(models.StatusActive, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnRows(sqlmock.NewRows(subscriptionColumns).
+				AddRow(1, "Team", 101, 1, "active", time.Now(), time.Now(), nil, "monthly", 0, true, time.Now(), time.Now().AddDate(0, 1, 0), nil, 0, 0.0, 1))
+		mock.ExpectCommit()
+
+		w = httptest.NewRecorder()
+		resume := TransitionSubscription(store.NewPostgres(db).Subscriptions(), models.EventResume)
+		resume.ServeHTTP(w, mux.SetURLVars(httptest.NewRequest("POST", "/subscriptions/1/resume", nil), map[string]string{"id": "1"}))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateSubscriptionBillingPeriod(t *testing.T) {
+	s := store.NewMemory()
+	organization := models.Organization{Name: "Acme"}
+	assert.NoError(t, s.Organizations().Create(context.Background(), &organization))
+	r := mux.NewRouter()
+	r.Use(OrganizationScope)
+	r.HandleFunc("/subscriptions", CreateSubscription(s.Subscriptions(), s.Organizations(), nil)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/renewals", GetSubscriptionRenewals(s.Subscriptions())).Methods("GET")
+
+	testCases := []struct {
+		name             string
+		requestBody      string
+		expectedCode     int
+		expectedInterval models.BillingInterval
+		expectedRenew    bool
+	}{
+		{name: "defaults to monthly auto-renewing", requestBody: `{"name": "Team", "product_id": 1, "license_count": 1}`, expectedCode: http.StatusCreated, expectedInterval: models.IntervalMonthly, expectedRenew: true},
+		{name: "annual without renewal", requestBody: `{"name": "Team", "product_id": 1, "license_count": 1, "billing_interval": "annual", "auto_renew": false}`, expectedCode: http.StatusCreated, expectedInterval: models.IntervalAnnual},
+		{name: "custom", requestBody: `{"name": "Team", "product_id": 1, "license_count": 1, "billing_interval": "custom", "interval_days": 14}`, expectedCode: http.StatusCreated, expectedInterval: models.IntervalCustom, expectedRenew: true},
+		{name: "custom without days", requestBody: `{"name": "Team", "product_id": 1, "license_count": 1, "billing_interval": "custom"}`, expectedCode: http.StatusBadRequest},
+		{name: "trial", requestBody: `{"name": "Team", "product_id": 1, "license_count": 5, "trial_end": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `", "trial_seat_limit": 2}`, expectedCode: http.StatusCreated, expectedInterval: models.IntervalMonthly, expectedRenew: true},
+		{name: "trial in the past", requestBody: `{"name": "Team", "product_id": 1, "license_count": 5, "trial_end": "2020-01-01T00:00:00Z"}`, expectedCode: http.StatusBadRequest},
+		{name: "trial seat limit without trial", requestBody: `{"name": "Team", "product_id": 1, "license_count": 5, "trial_seat_limit": 2}`, expectedCode: http.StatusBadRequest},
+		{name: "unknown interval", requestBody: `{"name": "Team", "product_id": 1, "license_count": 1, "billing_interval": "weekly"}`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/subscriptions", strings.NewReader(tc.requestBody))
+			req.Header.Set(OrganizationHeader, strconv.Itoa(organization.ID))
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusCreated {
+				return
+			}
+			var subscription models.Subscription
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&subscription))
+			assert.Equal(t, tc.expectedInterval, subscription.BillingInterval)
+			assert.Equal(t, tc.expectedRenew, subscription.AutoRenew)
+			if subscription.TrialEnd != nil {
+				assert.Equal(t, models.StatusTrialing, subscription.Status)
+				assert.Equal(t, *subscription.TrialEnd, subscription.CurrentPeriodEnd)
+				return
+			}
+			assert.Equal(t, models.StatusActive, subscription.Status)
+			assert.Equal(t, subscription.PeriodEnd(subscription.CurrentPeriodStart), subscription.CurrentPeriodEnd)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions/1/renewals", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/subscriptions/42/renewals", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
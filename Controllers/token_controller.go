@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"subscriptions/auth"
+	"subscriptions/tokens"
+	"time"
+)
+
+// tokenRequest is the body of POST /entitlements/tokens.
+type tokenRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// IssueEntitlementToken signs a short-lived token listing the products and
+// subscriptions a user is entitled to, for services to verify offline
+func IssueEntitlementToken(issuer *tokens.Issuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermEntitlementsRead) {
+			return
+		}
+
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if req.UserID < 1 {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+
+		token, claims, err := issuer.Issue(r.Context(), req.UserID)
+		if err != nil {
+			log.Printf("Issuing entitlement token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      token,
+			"expires_at": time.Unix(claims.ExpiresAt, 0).UTC(),
+			"claims":     claims,
+		})
+	}
+}
+
+// GetJWKS publishes the public keys entitlement tokens are verified with
+func GetJWKS(keys *tokens.KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// Short enough for verifiers to pick up a rotated key quickly
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(keys.JWKS())
+	}
+}
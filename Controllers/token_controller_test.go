@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/models"
+	"subscriptions/store"
+	"subscriptions/tokens"
+	"subscriptions/tokens/verify"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntitlementTokens(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+	keys, err := tokens.GenerateKeys()
+	assert.NoError(t, err)
+	issue := IssueEntitlementToken(tokens.NewIssuer(s.Subscriptions(), keys))
+
+	t.Run("issue", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		issue(w, httptest.NewRequest("POST", "/entitlements/tokens", strings.NewReader(`{"user_id":7}`)))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		claims, err := verify.NewVerifier(keys, tokens.DefaultIssuer).Verify(ctx, body.Token)
+		assert.NoError(t, err)
+		assert.True(t, claims.HasProduct(101))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, payload := range []string{`{}`, `{"user_id":0}`, `nope`} {
+			w := httptest.NewRecorder()
+			issue(w, httptest.NewRequest("POST", "/entitlements/tokens", strings.NewReader(payload)))
+			assert.Equal(t, http.StatusBadRequest, w.Code, payload)
+		}
+	})
+
+	t.Run("jwks", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		GetJWKS(keys)(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var set verify.JWKS
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&set))
+		if assert.Len(t, set.Keys, 1) {
+			_, err := set.Keys[0].PublicKey()
+			assert.NoError(t, err)
+		}
+	})
+}
@@ -1,108 +1,124 @@
 package controllers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
-
-	"github.com/gorilla/mux"
 	"net/http"
+	"net/url"
+	"subscriptions/auth"
 	"subscriptions/models"
+	"subscriptions/store"
 )
 
-// GetUserSubscriptions retrieves all user subscriptions
+// GetUserSubscriptions retrieves all user subscriptions. Callers who may only
+// read their own seats get theirs; filtering by user_id is for admins, users
+// have GET /me/subscriptions.
 
-func GetUserSubscriptions(db *sql.DB) http.HandlerFunc {
+func GetUserSubscriptions(userSubscriptions store.UserSubscriptionStore, products ProductLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := r.URL.Query().Get("user_id")   // Get user_id from query parameter
-		fmt.Println("UserID parameter:", userID) // Debugging log
-
-		query := `
-            SELECT 
-                us.id, us.user_id, us.subscription_id, us.created_at, us.updated_at, us.deleted_at, 
-                s.name, s.product_id
-            FROM 
-                user_subscriptions us
-            JOIN 
-                subscriptions s ON us.subscription_id = s.id
-            WHERE 
-                us.deleted_at IS NULL`
-
-		var rows *sql.Rows
-		var err error
-
-		// If user_id is provided, filter by user_id
-		if userID != "" {
-			query += " AND us.user_id = $1" // Add condition for user_id
-			rows, err = db.Query(query, userID)
-		} else {
-			rows, err = db.Query(query) // Fetch all if no user_id is provided
+		ownerID, ok := authorizeOwn(w, r, auth.PermSeatsRead, auth.PermSeatsReadOwn)
+		if !ok {
+			return
 		}
 
+		filter, err := userSubscriptionFilter(r.URL.Query())
 		if err != nil {
-			log.Println("Database query error:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
-
-		var userSubscriptions []models.UserSubscription
-		for rows.Next() {
-			var userSubscription models.UserSubscription
-			if err := rows.Scan(
-				&userSubscription.ID, &userSubscription.UserID, &userSubscription.SubscriptionID,
-				&userSubscription.CreatedAt, &userSubscription.UpdatedAt, &userSubscription.DeletedAt,
-				&userSubscription.SubscriptionName, &userSubscription.ProductID); err != nil {
-				log.Println("Error scanning row:", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if ownerID != 0 {
+			if filter.UserID != 0 {
+				writeForbidden(w, &auth.PermissionError{Permission: auth.PermSeatsRead})
 				return
 			}
-			userSubscriptions = append(userSubscriptions, userSubscription)
+			filter.UserID = ownerID
 		}
 
-		if err := rows.Err(); err != nil {
-			log.Println("Rows iteration error:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+		listUserSubscriptions(w, r, userSubscriptions, products, filter, wantsExpand(r, "product"))
+	}
+}
+
+// listUserSubscriptions writes the page of user subscriptions matching
+// filter that the query asks for, with their products when expandProduct is set.
+func listUserSubscriptions(w http.ResponseWriter, r *http.Request, userSubscriptions store.UserSubscriptionStore, products ProductLookup, filter store.UserSubscriptionFilter, expandProduct bool) {
+	req, err := pageRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := userSubscriptions.List(r.Context(), filter, req)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+
+	if expandProduct {
+		list := page.Items
+		ids := make([]int, len(list))
+		for i := range list {
+			ids[i] = list[i].ProductID
 		}
+		found := lookupProducts(r.Context(), products, ids)
+		for i := range list {
+			list[i].Product = found[list[i].ProductID]
+		}
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(userSubscriptions) // Send the result as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page) // Send the result as JSON
+}
+
+// userSubscriptionFilter reads user_id, subscription_id, product_id,
+// created_after and created_before from the query.
+func userSubscriptionFilter(query url.Values) (store.UserSubscriptionFilter, error) {
+	var filter store.UserSubscriptionFilter
+	var err error
+	if filter.UserID, err = queryInt(query, "user_id"); err != nil {
+		return filter, err
+	}
+	if filter.SubscriptionID, err = queryInt(query, "subscription_id"); err != nil {
+		return filter, err
 	}
+	if filter.ProductID, err = queryInt(query, "product_id"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedAfter, err = queryTime(query, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = queryTime(query, "created_before"); err != nil {
+		return filter, err
+	}
+	return filter, nil
 }
 
 // GetUserSubscriptionByID retrieves a user subscription by ID
 
-func GetUserSubscriptionByID(db *sql.DB) http.HandlerFunc {
+func GetUserSubscriptionByID(userSubscriptions store.UserSubscriptionStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		ownerID, ok := authorizeOwn(w, r, auth.PermSeatsRead, auth.PermSeatsReadOwn)
+		if !ok {
+			return
+		}
 
-		var userSubscription models.UserSubscription
-		var name string
-		var productID int
-
-		err := db.QueryRow(`
-			SELECT 
-				us.id, us.user_id, us.subscription_id, us.created_at, us.updated_at, us.deleted_at, 
-				s.name, s.product_id
-			FROM 
-				user_subscriptions us
-			JOIN 
-				subscriptions s ON us.subscription_id = s.id
-			WHERE 
-				us.id = $1 AND us.deleted_at IS NULL
-		`, id).
-			Scan(&userSubscription.ID, &userSubscription.UserID, &userSubscription.SubscriptionID, &userSubscription.CreatedAt, &userSubscription.UpdatedAt, &userSubscription.DeletedAt, &name, &productID)
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		userSubscription, err := userSubscriptions.Get(r.Context(), id)
 		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Println("Database query error:", err)
+			}
 			http.Error(w, "User subscription not found", http.StatusNotFound)
 			return
 		}
-
-		// Adding name and product_id to the response
-		userSubscription.SubscriptionName = name
-		userSubscription.ProductID = productID
+		if ownerID != 0 && userSubscription.UserID != ownerID {
+			writeForbidden(w, &auth.PermissionError{Permission: auth.PermSeatsRead})
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(userSubscription)
@@ -111,42 +127,35 @@ func GetUserSubscriptionByID(db *sql.DB) http.HandlerFunc {
 
 // CreateUserSubscription creates a new user subscription
 
-func CreateUserSubscription(db *sql.DB) http.HandlerFunc {
+func CreateUserSubscription(userSubscriptions store.UserSubscriptionStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, auth.PermSeatsWrite) {
+			return
+		}
+
 		var userSubscription models.UserSubscription
 		if err := json.NewDecoder(r.Body).Decode(&userSubscription); err != nil {
 			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
 
-		// Check if the subscription exists and get its license_count
-		var licenseCount int
-		err := db.QueryRow("SELECT license_count FROM subscriptions WHERE id = $1 AND deleted_at IS NULL", userSubscription.SubscriptionID).Scan(&licenseCount)
-		if err != nil {
+		// The store checks license availability and inserts atomically
+		err := userSubscriptions.Create(r.Context(), &userSubscription)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
 			http.Error(w, "Subscription not found", http.StatusNotFound)
 			return
-		}
-
-		// Count how many user subscriptions currently exist for this subscription
-		var currentSubscriptions int
-		err = db.QueryRow("SELECT COUNT(*) FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL", userSubscription.SubscriptionID).Scan(&currentSubscriptions)
-		if err != nil {
-			http.Error(w, "Failed to check current subscriptions", http.StatusInternalServerError)
+		case errors.Is(err, store.ErrSubscriptionNotSeatable):
+			http.Error(w, err.Error(), http.StatusConflict)
 			return
-		}
-
-		// Check if licenses are available
-		if currentSubscriptions >= licenseCount {
+		case errors.Is(err, store.ErrNoLicensesAvailable):
 			http.Error(w, "No licenses available for this subscription", http.StatusForbidden)
 			return
-		}
-
-		// Insert the new user subscription
-		err = db.QueryRow(
-			"INSERT INTO user_subscriptions (user_id, subscription_id, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) RETURNING id",
-			userSubscription.UserID, userSubscription.SubscriptionID,
-		).Scan(&userSubscription.ID)
-		if err != nil {
+		case errors.Is(err, store.ErrTrialAlreadyUsed):
+			http.Error(w, "User has already trialed this product", http.StatusConflict)
+			return
+		case err != nil:
+			log.Println("Failed to create user subscription:", err)
 			http.Error(w, "Failed to create user subscription", http.StatusInternalServerError)
 			return
 		}
@@ -157,10 +166,16 @@ func CreateUserSubscription(db *sql.DB) http.HandlerFunc {
 }
 
 // UpdateUserSubscription updates an existing user subscription
-func UpdateUserSubscription(db *sql.DB) http.HandlerFunc {
+func UpdateUserSubscription(userSubscriptions store.UserSubscriptionStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		if !authorize(w, r, auth.PermSeatsWrite) {
+			return
+		}
+
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
 
 		var userSubscription models.UserSubscription
 		if err := json.NewDecoder(r.Body).Decode(&userSubscription); err != nil {
@@ -168,24 +183,63 @@ func UpdateUserSubscription(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec("UPDATE user_subscriptions SET user_id = $1, subscription_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND deleted_at IS NULL", userSubscription.UserID, userSubscription.SubscriptionID, id)
-		if err != nil {
+		// The store checks the subscription the seat moves to like Create does
+		err := userSubscriptions.Update(r.Context(), id, &userSubscription)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "User subscription not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrSubscriptionNotFound):
+			http.Error(w, "Subscription not found", http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, store.ErrSubscriptionNotSeatable):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, store.ErrNoLicensesAvailable):
+			http.Error(w, "No licenses available for this subscription", http.StatusForbidden)
+			return
+		case errors.Is(err, store.ErrTrialAlreadyUsed):
+			http.Error(w, "User has already trialed this product", http.StatusConflict)
+			return
+		case err != nil:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// Respond with the seat as stored, not as requested
+		updated, err := userSubscriptions.Get(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "User subscription not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Database query error:", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(userSubscription)
+		json.NewEncoder(w).Encode(updated)
 	}
 }
 
 // DeleteUserSubscription deletes a user subscription (soft delete)
-func DeleteUserSubscription(db *sql.DB) http.HandlerFunc {
+func DeleteUserSubscription(userSubscriptions store.UserSubscriptionStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id := vars["id"]
+		if !authorize(w, r, auth.PermSeatsWrite) {
+			return
+		}
 
-		_, err := db.Exec("UPDATE user_subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		err := userSubscriptions.Delete(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "User subscription not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
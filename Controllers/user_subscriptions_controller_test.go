@@ -1,18 +1,579 @@
 package controllers
 
-// import (
-// 	"database/sql/driver"
-// 	"encoding/json"
-// 	"errors"
-// 	"fmt"
-// 	"net/http"
-// 	"net/http/httptest"
-// 	"subscriptions/models"
-// 	"testing"
-// 	"time"
-
-// 	"github.com/DATA-DOG/go-sqlmock"
-// 	"github.com/stretchr/testify/assert"
-// )
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"subscriptions/clients"
+	"subscriptions/migrations"
+	"subscriptions/models"
+	"subscriptions/store"
+	"sync"
+	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
 
+func TestCreateUserSubscription(t *testing.T) {
+	lockQuery := regexp.QuoteMeta(`SELECT license_count, status, trial_seat_limit, product_id FROM subscriptions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+	lockColumns := []string{"license_count", "status", "trial_seat_limit", "product_id"}
+	claimQuery := regexp.QuoteMeta(`INSERT INTO trial_claims (user_id, product_id, subscription_id) VALUES ($1, $2, $3) ON CONFLICT (user_id, product_id) DO NOTHING`)
+	claimedQuery := regexp.QuoteMeta(`SELECT subscription_id FROM trial_claims WHERE user_id = $1 AND product_id = $2`)
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO user_subscriptions (user_id, subscription_id, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) RETURNING id`)
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - seat available",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "active", 0, 101))
+				mock.ExpectQuery(countQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectQuery(insertQuery).WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - invalid JSON",
+			requestBody:  `{"user_id": }`,
+			expectedCode: http.StatusBadRequest,
+			mockQueries:  func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:         "failure - subscription not found",
+			requestBody:  `{"user_id": 7, "subscription_id": 99}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(99).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - subscription canceled",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "canceled", 0, 101))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - no licenses available",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusForbidden,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "active", 0, 101))
+				mock.ExpectQuery(countQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "success - trial seat claims the trial",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(10, "trialing", 2, 101))
+				mock.ExpectQuery(countQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectExec(claimQuery).WithArgs(7, 101, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(claimedQuery).WithArgs(7, 101).
+					WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(insertQuery).WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:         "failure - trial seat limit reached",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusForbidden,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(10, "trialing", 2, 101))
+				mock.ExpectQuery(countQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - product already trialed",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusConflict,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(10, "trialing", 0, 101))
+				mock.ExpectQuery(countQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectExec(claimQuery).WithArgs(7, 101, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(claimedQuery).WithArgs(7, 101).
+					WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(5))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - commit error",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusInternalServerError,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "active", 0, 101))
+				mock.ExpectQuery(countQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(insertQuery).WithArgs(7, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+				mock.ExpectCommit().WillReturnError(errors.New("commit error"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			tc.mockQueries(mock)
+
+			req := httptest.NewRequest("POST", "/user_subscriptions", strings.NewReader(tc.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler := CreateUserSubscription(store.NewPostgres(db).UserSubscriptions())
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUserSubscriptionsInMemory(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+
+	subscription := models.Subscription{Name: "Team Plan", ProductID: 101, LicenseCount: 2, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	solo := models.Subscription{Name: "Solo Plan", ProductID: 101, LicenseCount: 1, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &solo))
+	paused := models.Subscription{Name: "Paused Plan", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &paused))
+	_, err := s.Subscriptions().Transition(ctx, paused.ID, models.EventPause, time.Now())
+	assert.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/user_subscriptions", GetUserSubscriptions(s.UserSubscriptions(), nil)).Methods("GET")
+	r.HandleFunc("/user_subscriptions/{id}", GetUserSubscriptionByID(s.UserSubscriptions())).Methods("GET")
+	r.HandleFunc("/user_subscriptions", CreateUserSubscription(s.UserSubscriptions())).Methods("POST")
+	r.HandleFunc("/user_subscriptions/{id}", UpdateUserSubscription(s.UserSubscriptions())).Methods("PUT")
+	r.HandleFunc("/user_subscriptions/{id}", DeleteUserSubscription(s.UserSubscriptions())).Methods("DELETE")
+
+	steps := []struct {
+		name         string
+		method       string
+		target       string
+		requestBody  string
+		expectedCode int
+		expectedLen  int
+	}{
+		{name: "assign first seat", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 1, "subscription_id": 1}`, expectedCode: http.StatusOK},
+		{name: "assign second seat", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 2, "subscription_id": 1}`, expectedCode: http.StatusOK},
+		{name: "no seats left", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 3, "subscription_id": 1}`, expectedCode: http.StatusForbidden},
+		{name: "unknown subscription", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 3, "subscription_id": 99}`, expectedCode: http.StatusNotFound},
+		{name: "list all", method: "GET", target: "/user_subscriptions", expectedCode: http.StatusOK, expectedLen: 2},
+		{name: "list by user", method: "GET", target: "/user_subscriptions?user_id=2", expectedCode: http.StatusOK, expectedLen: 1},
+		{name: "invalid user_id", method: "GET", target: "/user_subscriptions?user_id=abc", expectedCode: http.StatusBadRequest},
+		{name: "get by id", method: "GET", target: "/user_subscriptions/1", expectedCode: http.StatusOK},
+		{name: "get missing", method: "GET", target: "/user_subscriptions/42", expectedCode: http.StatusNotFound},
+		{name: "update", method: "PUT", target: "/user_subscriptions/1", requestBody: `{"user_id": 5, "subscription_id": 1}`, expectedCode: http.StatusOK},
+		{name: "update missing", method: "PUT", target: "/user_subscriptions/42", requestBody: `{"user_id": 5, "subscription_id": 1}`, expectedCode: http.StatusNotFound},
+		{name: "move to unknown subscription", method: "PUT", target: "/user_subscriptions/1", requestBody: `{"user_id": 5, "subscription_id": 99}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "assign solo seat", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 6, "subscription_id": 2}`, expectedCode: http.StatusOK},
+		{name: "move to full subscription", method: "PUT", target: "/user_subscriptions/3", requestBody: `{"user_id": 6, "subscription_id": 1}`, expectedCode: http.StatusForbidden},
+		{name: "paused subscription takes no seats", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 7, "subscription_id": 3}`, expectedCode: http.StatusConflict},
+		{name: "move to paused subscription", method: "PUT", target: "/user_subscriptions/3", requestBody: `{"user_id": 6, "subscription_id": 3}`, expectedCode: http.StatusConflict},
+		{name: "delete frees a seat", method: "DELETE", target: "/user_subscriptions/2", expectedCode: http.StatusNoContent},
+		{name: "deleted is gone", method: "GET", target: "/user_subscriptions/2", expectedCode: http.StatusNotFound},
+		{name: "reassign freed seat", method: "POST", target: "/user_subscriptions", requestBody: `{"user_id": 3, "subscription_id": 1}`, expectedCode: http.StatusOK},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			req := httptest.NewRequest(step.method, step.target, strings.NewReader(step.requestBody))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, step.expectedCode, w.Code)
+			if step.method == "GET" && step.expectedLen > 0 {
+				var page store.Page[models.UserSubscription]
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+				userSubscriptions := page.Items
+				assert.Len(t, userSubscriptions, step.expectedLen)
+				for _, userSubscription := range userSubscriptions {
+					assert.Equal(t, "Team Plan", userSubscription.SubscriptionName)
+				}
+			}
+		})
+	}
+}
+
+func TestGetUserSubscriptionsExpandProduct(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+
+	subscription := models.Subscription{Name: "Team Plan", ProductID: 101, LicenseCount: 2, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	for _, userID := range []int{1, 2} {
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: userID, SubscriptionID: subscription.ID}))
+	}
+
+	products := &fakeProducts{products: map[int]clients.Product{101: {ID: 101, Name: "Editor", Price: 10}}}
+
+	w := httptest.NewRecorder()
+	GetUserSubscriptions(s.UserSubscriptions(), products).ServeHTTP(w, httptest.NewRequest("GET", "/user_subscriptions?expand=product", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var page store.Page[models.UserSubscription]
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	userSubscriptions := page.Items
+	assert.Len(t, userSubscriptions, 2)
+	for _, userSubscription := range userSubscriptions {
+		if assert.NotNil(t, userSubscription.Product) {
+			assert.Equal(t, "Editor", userSubscription.Product.Name)
+		}
+	}
+	assert.Equal(t, 1, products.offerCalls, "a single distinct product is fetched once")
+}
+
+func TestTrialSeatsInMemory(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	trialEnd := time.Now().Add(14 * 24 * time.Hour)
+	for _, name := range []string{"First trial", "Second trial"} {
+		subscription := models.Subscription{Name: name, ProductID: 101, LicenseCount: 5, TrialEnd: &trialEnd, TrialSeatLimit: 2, OrganizationID: 1}
+		assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	}
+	paid := models.Subscription{Name: "Paid", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &paid))
+
+	handler := CreateUserSubscription(s.UserSubscriptions())
+	steps := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "first trial seat", requestBody: `{"user_id": 1, "subscription_id": 1}`, expectedCode: http.StatusOK},
+		{name: "second trial seat", requestBody: `{"user_id": 2, "subscription_id": 1}`, expectedCode: http.StatusOK},
+		{name: "trial seat limit", requestBody: `{"user_id": 3, "subscription_id": 1}`, expectedCode: http.StatusForbidden},
+		{name: "same product trialed again", requestBody: `{"user_id": 1, "subscription_id": 2}`, expectedCode: http.StatusConflict},
+		{name: "new user may trial", requestBody: `{"user_id": 3, "subscription_id": 2}`, expectedCode: http.StatusOK},
+		{name: "paid seats are not trials", requestBody: `{"user_id": 1, "subscription_id": 3}`, expectedCode: http.StatusOK},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "/user_subscriptions", strings.NewReader(step.requestBody)))
+			assert.Equal(t, step.expectedCode, w.Code)
+		})
+	}
+
+	// Moving a seat onto a trial claims the trial like assigning one does
+	r := mux.NewRouter()
+	r.HandleFunc("/user_subscriptions/{id}", UpdateUserSubscription(s.UserSubscriptions())).Methods("PUT")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("PUT", "/user_subscriptions/4", strings.NewReader(`{"user_id": 1, "subscription_id": 2}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUpdateUserSubscription(t *testing.T) {
+	seatQuery := regexp.QuoteMeta(`SELECT subscription_id FROM user_subscriptions WHERE id = $1 AND deleted_at IS NULL`)
+	subscriptionsLockQuery := regexp.QuoteMeta(`SELECT id FROM subscriptions WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`)
+	subscriptionLockQuery := regexp.QuoteMeta(`SELECT id FROM subscriptions WHERE id IN ($1) ORDER BY id FOR UPDATE`)
+	seatLockQuery := regexp.QuoteMeta(`SELECT subscription_id FROM user_subscriptions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+	lockQuery := regexp.QuoteMeta(`SELECT license_count, status, trial_seat_limit, product_id FROM subscriptions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`)
+	lockColumns := []string{"license_count", "status", "trial_seat_limit", "product_id"}
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL`)
+	updateQuery := regexp.QuoteMeta(`UPDATE user_subscriptions SET user_id = $1, subscription_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3`)
+	getQuery := regexp.QuoteMeta(`AND us.id = $1`)
+
+	testCases := []struct {
+		name         string
+		requestBody  string
+		expectedCode int
+		expectedBody string
+		mockQueries  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "success - new user on the same subscription",
+			requestBody:  `{"user_id": 8, "subscription_id": 1}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `"name":"Team Plan"`,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(seatQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(subscriptionLockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(seatLockQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(lockQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "active", 0, 101))
+				mock.ExpectExec(updateQuery).WithArgs(8, 1, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery(getQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{
+					"id", "user_id", "subscription_id", "created_at", "updated_at", "deleted_at", "name", "product_id",
+				}).AddRow(5, 8, 1, time.Now(), time.Now(), nil, "Team Plan", 101))
+			},
+		},
+		{
+			name:         "success - seat moved to the target before the locks",
+			requestBody:  `{"user_id": 7, "subscription_id": 2}`,
+			expectedCode: http.StatusOK,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(seatQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(subscriptionsLockQuery).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(seatLockQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(2))
+				mock.ExpectQuery(lockQuery).WithArgs(2).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "active", 0, 101))
+				mock.ExpectExec(updateQuery).WithArgs(7, 2, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery(getQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{
+					"id", "user_id", "subscription_id", "created_at", "updated_at", "deleted_at", "name", "product_id",
+				}).AddRow(5, 7, 2, time.Now(), time.Now(), nil, "Team Plan", 101))
+			},
+		},
+		{
+			name:         "failure - target subscription is full",
+			requestBody:  `{"user_id": 7, "subscription_id": 2}`,
+			expectedCode: http.StatusForbidden,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(seatQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(subscriptionsLockQuery).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(seatLockQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(lockQuery).WithArgs(2).
+					WillReturnRows(sqlmock.NewRows(lockColumns).AddRow(2, "active", 0, 101))
+				mock.ExpectQuery(countQuery).WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - target subscription not found",
+			requestBody:  `{"user_id": 7, "subscription_id": 99}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(seatQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(subscriptionsLockQuery).WithArgs(1, 99).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(seatLockQuery).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"subscription_id"}).AddRow(1))
+				mock.ExpectQuery(lockQuery).WithArgs(99).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:         "failure - seat not found",
+			requestBody:  `{"user_id": 7, "subscription_id": 1}`,
+			expectedCode: http.StatusNotFound,
+			mockQueries: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(seatQuery).WithArgs(5).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error initializing sqlmock: %v", err)
+			}
+			defer db.Close()
+			tc.mockQueries(mock)
+
+			r := mux.NewRouter()
+			r.HandleFunc("/user_subscriptions/{id}", UpdateUserSubscription(store.NewPostgres(db).UserSubscriptions())).Methods("PUT")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("PUT", "/user_subscriptions/5", strings.NewReader(tc.requestBody)))
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.expectedBody)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateUserSubscriptionConcurrentInMemory(t *testing.T) {
+	assertSeatsNeverOversold(t, store.NewMemory())
+}
+
+// TestCreateUserSubscriptionConcurrent runs the same check against a real
+// Postgres database. It only runs when TEST_DATABASE_URL points at a
+// disposable database.
+func TestCreateUserSubscriptionConcurrent(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("error loading migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("error migrating database: %v", err)
+	}
+
+	assertSeatsNeverOversold(t, store.NewPostgres(db))
+}
+
+// assertSeatsNeverOversold fires many parallel POST /user_subscriptions
+// requests at one subscription and checks that license_count is never exceeded.
+func assertSeatsNeverOversold(t *testing.T, s store.Store) {
+	const licenseCount = 5
+	const requests = 50
+	ctx := context.Background()
+
+	organization := models.Organization{Name: "Concurrency Test"}
+	if err := s.Organizations().Create(ctx, &organization); err != nil {
+		t.Fatalf("error creating organization: %v", err)
+	}
+	subscription := models.Subscription{Name: "Concurrency Test", ProductID: 1, LicenseCount: licenseCount, OrganizationID: organization.ID}
+	if err := s.Subscriptions().Create(ctx, &subscription); err != nil {
+		t.Fatalf("error creating subscription: %v", err)
+	}
+	defer s.Subscriptions().Delete(ctx, subscription.ID)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/user_subscriptions", CreateUserSubscription(s.UserSubscriptions())).Methods("POST")
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"user_id": %d, "subscription_id": %d}`, userID, subscription.ID)
+			resp, err := http.Post(server.URL+"/user_subscriptions", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			codes <- resp.StatusCode
+		}(i + 1)
+	}
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			created++
+		case http.StatusForbidden:
+		default:
+			t.Errorf("unexpected status code %d", code)
+		}
+	}
+	assert.Equal(t, licenseCount, created)
+
+	assigned := 0
+	page, err := s.UserSubscriptions().List(ctx, store.UserSubscriptionFilter{SubscriptionID: subscription.ID}, store.PageRequest{Limit: store.MaxLimit})
+	assert.NoError(t, err)
+	for _, userSubscription := range page.Items {
+		if userSubscription.SubscriptionID == subscription.ID {
+			assigned++
+			defer s.UserSubscriptions().Delete(ctx, userSubscription.ID)
+		}
+	}
+	assert.Equal(t, licenseCount, assigned)
+}
+
+func TestShrinkLicenseCountInMemory(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 4, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	for userID := 1; userID <= 3; userID++ {
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: userID, SubscriptionID: subscription.ID}))
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/subscriptions/{id}/change_plan", ChangeSubscriptionPlan(s.Subscriptions(), nil)).Methods("POST")
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("POST", "/subscriptions/1/change_plan", `{"license_count": 1}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error": "license_count is below the assigned seats: 3 seats assigned, license_count 1 is 2 short", "license_count": 1, "assigned_seats": 3, "overage": 2}`, w.Body.String())
+
+	w = do("POST", "/subscriptions/1/change_plan", `{"license_count": 2, "timing": "at_period_end"}`)
+	assert.Equal(t, http.StatusConflict, w.Code, "scheduled plan changes are checked too")
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/subscriptions/1/change_plan?seat_policy=drop", `{"license_count": 2}`).Code)
+
+	got, err := s.Subscriptions().Get(ctx, subscription.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, got.LicenseCount, "rejected updates change nothing")
+
+	w = do("POST", "/subscriptions/1/change_plan?seat_policy=revoke", `{"license_count": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var body struct {
+		PlanChange models.PlanChange `json:"plan_change"`
+		Revoked    []int             `json:"revoked_user_subscription_ids"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, 1, body.PlanChange.ToLicenseCount)
+	assert.Equal(t, []int{2, 3}, body.Revoked, "the most recently assigned seats go first")
+
+	page, err := s.UserSubscriptions().List(ctx, store.UserSubscriptionFilter{SubscriptionID: subscription.ID}, store.PageRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Items, 1) {
+		assert.Equal(t, 1, page.Items[0].UserID)
+	}
+}
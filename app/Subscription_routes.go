@@ -1,16 +1,30 @@
 package app
 
 import (
-	"database/sql"
 	"subscriptions/Controllers"
+	"subscriptions/models"
 	"github.com/gorilla/mux"
 )
 
-func SubscriptionRoutes(db *sql.DB, r *mux.Router) {
+func SubscriptionRoutes(deps Dependencies, r *mux.Router) {
+	subscriptions := deps.Store.Subscriptions()
+	seats := deps.Store.UserSubscriptions()
+
 	// Subscription Routes
-	r.HandleFunc("/subscriptions", controllers.GetSubscriptions(db)).Methods("GET")
-	r.HandleFunc("/subscriptions/{id}", controllers.GetSubscriptionByID(db)).Methods("GET")
-	r.HandleFunc("/subscriptions", controllers.CreateSubscription(db)).Methods("POST")
-	r.HandleFunc("/subscriptions/{id}", controllers.UpdateSubscription(db)).Methods("PUT")
-	r.HandleFunc("/subscriptions/{id}", controllers.DeleteSubscription(db)).Methods("DELETE")
+	r.HandleFunc("/subscriptions", controllers.GetSubscriptions(subscriptions, seats, deps.Products)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id}", controllers.GetSubscriptionByID(subscriptions, seats, deps.Products)).Methods("GET")
+	r.HandleFunc("/subscriptions", deps.Idempotency.Wrap(controllers.CreateSubscription(subscriptions, deps.Store.Organizations(), deps.ProductValidator))).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}", controllers.UpdateSubscription(subscriptions, deps.ProductValidator)).Methods("PUT")
+	r.HandleFunc("/subscriptions/{id}", controllers.DeleteSubscription(subscriptions)).Methods("DELETE")
+	r.HandleFunc("/subscriptions/{id}/renewals", controllers.GetSubscriptionRenewals(subscriptions)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id}/seats", controllers.GetSubscriptionSeats(subscriptions, seats)).Methods("GET")
+
+	// Plan changes
+	r.HandleFunc("/subscriptions/{id}/change_plan", controllers.ChangeSubscriptionPlan(subscriptions, deps.ProductValidator)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/plan_changes", controllers.GetSubscriptionPlanChanges(subscriptions)).Methods("GET")
+
+	// Lifecycle transitions
+	r.HandleFunc("/subscriptions/{id}/pause", controllers.TransitionSubscription(subscriptions, models.EventPause)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/resume", controllers.TransitionSubscription(subscriptions, models.EventResume)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id}/cancel", controllers.TransitionSubscription(subscriptions, models.EventCancel)).Methods("POST")
 }
@@ -0,0 +1,15 @@
+package app
+
+import (
+	"subscriptions/Controllers"
+	"github.com/gorilla/mux"
+)
+
+func APIKeyRoutes(deps Dependencies, r *mux.Router) {
+	keys := deps.Store.APIKeys()
+
+	// API key Routes
+	r.HandleFunc("/api_keys", controllers.CreateAPIKey(keys, deps.Store.Organizations())).Methods("POST")
+	r.HandleFunc("/api_keys", controllers.GetAPIKeys(keys)).Methods("GET")
+	r.HandleFunc("/api_keys/{id}", controllers.RevokeAPIKey(keys)).Methods("DELETE")
+}
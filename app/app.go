@@ -1,21 +1,212 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"net"
+	"subscriptions/Controllers"
+	"subscriptions/auth"
+	"subscriptions/billing"
+	"subscriptions/clients"
+	"subscriptions/entitlements"
+	"subscriptions/store"
+	"subscriptions/tokens"
+	"subscriptions/tokens/verify"
 	"subscriptions/utils"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-func InitializeRoute(db *sql.DB) {
+// Dependencies are the collaborators the routes are built from.
+type Dependencies struct {
+	Store store.Store
+	// Readiness backs /readyz; nil reports ready with no checks.
+	Readiness *controllers.Readiness
+	// Products resolves expand=product; nil leaves products out of responses.
+	Products controllers.ProductLookup
+	// ProductValidator validates product_id on subscription writes; nil skips validation.
+	ProductValidator *controllers.ProductValidator
+	// Entitlements answers entitlement checks; nil uses a checker with the
+	// default cache TTL.
+	Entitlements *entitlements.Checker
+	// Tokens issues entitlement tokens; nil signs them with a throwaway key.
+	Tokens *tokens.Issuer
+	// Idempotency replays responses to retried subscription and seat
+	// creations; nil remembers keys for the default TTL.
+	Idempotency *controllers.Idempotency
+	// Auth authenticates every route but health checks and the JWKS; nil
+	// leaves the API open, which only suits tests.
+	Auth *auth.Authenticator
+}
+
+// NewRouter registers every route of the API on top of deps.
+func NewRouter(deps Dependencies) http.Handler {
+	deps.Tokens = tokenIssuer(deps)
+	deps.Entitlements = entitlementChecker(deps)
+	if deps.Readiness == nil {
+		deps.Readiness = controllers.NewReadiness()
+	}
+	if deps.Idempotency == nil {
+		deps.Idempotency = controllers.NewIdempotency(deps.Store.IdempotencyKeys(), controllers.DefaultIdempotencyTTL)
+	}
 	r := mux.NewRouter()
 
+	// Public routes
+	HealthRoutes(deps, r)
+	JWKSRoutes(deps, r)
+
+	// Every other route needs credentials, and every store call it makes is
+	// scoped to the organization it acts for
+	api := r.NewRoute().Subrouter()
+	if deps.Auth != nil {
+		api.Use(deps.Auth.Middleware)
+	}
+	api.Use(controllers.OrganizationScope)
+
 	// Register subscription routes
-	SubscriptionRoutes(db, r)
-	UserSubscriptionRoutes(db, r)
+	OrganizationRoutes(deps, api)
+	APIKeyRoutes(deps, api)
+	SubscriptionRoutes(deps, api)
+	UserSubscriptionRoutes(deps, api)
+	InvoiceRoutes(deps, api)
+	EntitlementRoutes(deps, api)
+	MeRoutes(deps, api)
+	TokenRoutes(deps, api)
+
+	return utils.JsonContentTypeMiddleware(r)
+}
+
+// Options configure how InitializeRoute wires the API.
+type Options struct {
+	Server ServerConfig
+	// Products is the products service client; nil when none is configured.
+	Products clients.ProductSource
+	// AllowProductsUnavailable accepts subscription writes whose product_id
+	// cannot be verified because the products service is down.
+	AllowProductsUnavailable bool
+	// RenewalInterval is how often the renewal worker runs; 0 disables it.
+	RenewalInterval  time.Duration
+	RenewalBatchSize int
+	// EntitlementCacheTTL is how long entitlement answers are cached; 0
+	// disables the cache.
+	EntitlementCacheTTL time.Duration
+	// SigningKeys are the entitlement token keys as kid=seed pairs, the
+	// signing key first; empty generates a key that lasts until exit.
+	SigningKeys string
+	TokenTTL    time.Duration
+	TokenIssuer string
+	// JWTIssuer and JWTAudience are what bearer JWTs must be issued by and
+	// for; JWTs are verified with the keys at JWKSURL or with the static
+	// JWTKeys (kid=PEM path pairs). Without either only API keys are accepted.
+	JWTIssuer   string
+	JWTAudience string
+	JWKSURL     string
+	JWTKeys     string
+	// IdempotencyTTL is how long Idempotency-Key responses are replayed.
+	IdempotencyTTL time.Duration
+}
+
+// idempotencyPurgeInterval is how often expired idempotency keys are deleted.
+const idempotencyPurgeInterval = time.Hour
+
+// InitializeRoute wires the API to Postgres and serves it until ctx is
+// cancelled, then shuts down gracefully.
+func InitializeRoute(ctx context.Context, db *sql.DB, opts Options) error {
+	deps := Dependencies{Store: store.NewPostgres(db)}
+	deps.Entitlements = entitlements.NewChecker(deps.Store.Subscriptions(), opts.EntitlementCacheTTL)
+
+	var keys *tokens.KeySet
+	var err error
+	if opts.SigningKeys != "" {
+		keys, err = tokens.ParseKeys(opts.SigningKeys)
+	} else {
+		log.Printf("No entitlement token signing keys configured, generating a key that lasts until exit")
+		keys, err = tokens.GenerateKeys()
+	}
+	if err != nil {
+		return err
+	}
+	deps.Tokens = tokens.NewIssuer(deps.Store.Subscriptions(), keys)
+	deps.Tokens.TTL = opts.TokenTTL
+	deps.Tokens.Name = opts.TokenIssuer
+
+	deps.Idempotency = controllers.NewIdempotency(deps.Store.IdempotencyKeys(), opts.IdempotencyTTL)
+
+	deps.Auth, err = newAuthenticator(deps.Store, opts)
+	if err != nil {
+		return err
+	}
+
+	checks := []controllers.DependencyCheck{{Name: "postgres", Check: db.PingContext}}
+	if opts.Products != nil {
+		checks = append(checks, controllers.DependencyCheck{Name: "products", Check: opts.Products.Ping, Optional: true})
+		deps.Products = opts.Products
+		deps.ProductValidator = &controllers.ProductValidator{Products: opts.Products, AllowUnavailable: opts.AllowProductsUnavailable}
+	}
+	deps.Readiness = controllers.NewReadiness(checks...)
+
+	l, err := net.Listen("tcp", opts.Server.Addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening on %s", l.Addr())
+
+	if opts.RenewalInterval > 0 {
+		renewer := billing.NewRenewer(deps.Store.Subscriptions())
+		renewer.BatchSize = opts.RenewalBatchSize
+		if opts.Products != nil {
+			renewer.Invoices = billing.NewInvoiceGenerator(deps.Store, opts.Products)
+		}
+		renewCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			renewer.Run(renewCtx, opts.RenewalInterval)
+		}()
+		// Let an in-flight renewal finish before the caller closes the database
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
+
+	purgeCtx, cancelPurge := context.WithCancel(ctx)
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		deps.Idempotency.RunPurge(purgeCtx, idempotencyPurgeInterval)
+	}()
+	defer func() {
+		cancelPurge()
+		<-purged
+	}()
+
+	srv := NewServer(opts.Server, NewRouter(deps))
+	return Serve(ctx, srv, l, deps.Readiness, opts.Server)
+}
 
-	// Start the server
-	log.Fatal(http.ListenAndServe(":8002", utils.JsonContentTypeMiddleware(r))) // Running on port 8002
+// newAuthenticator accepts the API keys in s and, when keys are configured,
+// bearer JWTs.
+func newAuthenticator(s store.Store, opts Options) (*auth.Authenticator, error) {
+	authenticator := &auth.Authenticator{APIKeys: s.APIKeys()}
+	var keys verify.KeySource
+	switch {
+	case opts.JWKSURL != "":
+		keys = verify.NewRemoteKeys(opts.JWKSURL)
+	case opts.JWTKeys != "":
+		static, err := auth.ParseKeyFiles(opts.JWTKeys)
+		if err != nil {
+			return nil, err
+		}
+		keys = static
+	default:
+		log.Printf("No JWT keys configured, only API keys are accepted")
+		return authenticator, nil
+	}
+	authenticator.JWT = verify.NewVerifier(keys, opts.JWTIssuer)
+	authenticator.JWT.Audience = opts.JWTAudience
+	return authenticator, nil
 }
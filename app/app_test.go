@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/Controllers"
+	"subscriptions/auth"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterIdempotency(t *testing.T) {
+	s := store.NewMemory()
+	router := NewRouter(Dependencies{Store: s, Readiness: controllers.NewReadiness()})
+	ctx := store.WithOrganization(context.Background(), 1)
+	assert.NoError(t, s.Organizations().Create(context.Background(), &models.Organization{Name: "Acme"}))
+
+	create := func() models.Subscription {
+		req := httptest.NewRequest("POST", "/subscriptions", strings.NewReader(`{"name": "Team", "product_id": 101, "license_count": 1}`))
+		req.Header.Set(controllers.OrganizationHeader, "1")
+		req.Header.Set(controllers.IdempotencyKeyHeader, "retry-me")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var subscription models.Subscription
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&subscription))
+		return subscription
+	}
+	assert.Equal(t, create().ID, create().ID)
+
+	page, err := s.Subscriptions().List(ctx, store.SubscriptionFilter{}, store.PageRequest{Limit: store.MaxLimit})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 1, "the retry created nothing")
+}
+
+func TestRouterInMemory(t *testing.T) {
+	router := NewRouter(Dependencies{Store: store.NewMemory(), Readiness: controllers.NewReadiness()})
+
+	as := func(organization, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if organization != "" {
+			req.Header.Set(controllers.OrganizationHeader, organization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		return as("1", method, target, body)
+	}
+
+	assert.Equal(t, http.StatusCreated, as("", "POST", "/organizations", `{"name": "Acme"}`).Code)
+	assert.Equal(t, http.StatusCreated, as("", "POST", "/organizations", `{"name": "Globex"}`).Code)
+
+	w := do("POST", "/subscriptions", `{"name": "Basic Plan", "product_id": 101, "license_count": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var subscription models.Subscription
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&subscription))
+	assert.Equal(t, 1, subscription.ID)
+
+	assert.Equal(t, http.StatusOK, do("POST", "/user_subscriptions", `{"user_id": 7, "subscription_id": 1}`).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/user_subscriptions", `{"user_id": 8, "subscription_id": 1}`).Code)
+
+	assert.Equal(t, http.StatusOK, do("PUT", "/subscriptions/1", `{"name": "Basic Plan", "product_id": 101, "license_count": 2}`).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/user_subscriptions", `{"user_id": 8, "subscription_id": 1}`).Code)
+	assert.Equal(t, http.StatusCreated, do("POST", "/subscriptions/1/change_plan", `{"license_count": 3}`).Code)
+
+	w = do("GET", "/user_subscriptions?user_id=8", "")
+	var page store.Page[models.UserSubscription]
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	userSubscriptions := page.Items
+	if assert.Len(t, userSubscriptions, 1) {
+		assert.Equal(t, "Basic Plan", userSubscriptions[0].SubscriptionName)
+	}
+
+	// Another organization can neither see nor touch the subscription
+	w = as("2", "GET", "/subscriptions", "")
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	assert.Empty(t, page.Items)
+	assert.Equal(t, http.StatusNotFound, as("2", "GET", "/subscriptions/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, as("2", "PUT", "/subscriptions/1", `{"name": "Mine"}`).Code)
+	assert.Equal(t, http.StatusNotFound, as("2", "POST", "/user_subscriptions", `{"user_id": 9, "subscription_id": 1}`).Code)
+	assert.Equal(t, http.StatusNotFound, as("2", "GET", "/subscriptions/1/seats", "").Code)
+	assert.Equal(t, http.StatusNotFound, as("2", "DELETE", "/user_subscriptions/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, as("2", "GET", "/organizations/1/subscriptions", "").Code)
+	assert.Equal(t, http.StatusForbidden, as("2", "POST", "/subscriptions", `{"name": "Team", "product_id": 101, "license_count": 1, "organization_id": 1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, as("x", "GET", "/subscriptions", "").Code)
+
+	w = as("", "GET", "/organizations/1/subscriptions", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed store.Page[models.Subscription]
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	if assert.Len(t, listed.Items, 1) {
+		assert.Equal(t, 1, listed.Items[0].OrganizationID)
+	}
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/subscriptions/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/subscriptions/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/subscriptions/1", "").Code, "a deleted subscription is not deleted again")
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/subscriptions/abc", "").Code)
+}
+
+func TestRouterAuthentication(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	router := NewRouter(Dependencies{Store: s, Readiness: controllers.NewReadiness(), Auth: &auth.Authenticator{APIKeys: s.APIKeys()}})
+
+	newKey := func(organizationID *int, role auth.Role) string {
+		secret, prefix, hash, err := auth.GenerateAPIKey()
+		assert.NoError(t, err)
+		assert.NoError(t, s.APIKeys().Create(ctx, &models.APIKey{Name: "test", Prefix: prefix, Hash: hash, OrganizationID: organizationID, Roles: []string{string(role)}}))
+		return secret
+	}
+	acme := 1
+	platformKey, acmeKey := newKey(nil, auth.RolePlatformAdmin), newKey(&acme, auth.RoleBillingAdmin)
+
+	do := func(secret, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Health checks and the token keys need no credentials
+	assert.Equal(t, http.StatusOK, do("", "GET", "/healthz", "").Code)
+	assert.Equal(t, http.StatusOK, do("", "GET", "/.well-known/jwks.json", "").Code)
+
+	assert.Equal(t, http.StatusUnauthorized, do("", "GET", "/subscriptions", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("sk_wrong", "GET", "/subscriptions", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("", "GET", "/nowhere", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(newKey(nil, auth.RoleBillingAdmin), "GET", "/subscriptions", "").Code, "only the platform acts outside of organizations")
+
+	// An organization's key is scoped to it without a header
+	w := do(acmeKey, "POST", "/subscriptions", `{"name": "Team", "product_id": 101, "license_count": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var subscription models.Subscription
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&subscription))
+	assert.Equal(t, 1, subscription.OrganizationID)
+	assert.Equal(t, http.StatusForbidden, do(acmeKey, "POST", "/organizations", `{"name": "Globex"}`).Code)
+	w = do(acmeKey, "POST", "/user_subscriptions", `{"user_id": 7, "subscription_id": 1}`)
+	assert.Equal(t, http.StatusForbidden, w.Code, "billing admins do not assign seats")
+	assert.Equal(t, "seats:write", w.Header().Get("X-Missing-Permission"))
+
+	assert.Equal(t, http.StatusCreated, do(platformKey, "POST", "/organizations", `{"name": "Globex"}`).Code)
+	assert.Equal(t, http.StatusOK, do(platformKey, "GET", "/subscriptions/1", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("", "GET", "/me/subscriptions", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(platformKey, "GET", "/me/entitlements", "").Code, "API keys are not users")
+}
+
+func TestRouterDefaultsReadiness(t *testing.T) {
+	router := NewRouter(Dependencies{Store: store.NewMemory()})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"subscriptions/Controllers"
+	"subscriptions/entitlements"
+	"github.com/gorilla/mux"
+)
+
+// entitlementChecker returns the checker of deps, or one with the default
+// cache TTL when there is none.
+func entitlementChecker(deps Dependencies) *entitlements.Checker {
+	if deps.Entitlements != nil {
+		return deps.Entitlements
+	}
+	return entitlements.NewChecker(deps.Store.Subscriptions(), entitlements.DefaultCacheTTL)
+}
+
+func EntitlementRoutes(deps Dependencies, r *mux.Router) {
+	// Entitlement Routes
+	r.HandleFunc("/entitlements", controllers.GetEntitlement(deps.Entitlements)).Methods("GET")
+	r.HandleFunc("/entitlements/check", controllers.CheckEntitlements(deps.Entitlements)).Methods("POST")
+}
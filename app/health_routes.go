@@ -0,0 +1,12 @@
+package app
+
+import (
+	"subscriptions/Controllers"
+	"github.com/gorilla/mux"
+)
+
+func HealthRoutes(deps Dependencies, r *mux.Router) {
+	// Health Routes
+	r.HandleFunc("/healthz", controllers.Healthz()).Methods("GET")
+	r.HandleFunc("/readyz", controllers.Readyz(deps.Readiness)).Methods("GET")
+}
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"subscriptions/Controllers"
+	"subscriptions/billing"
+	"subscriptions/models"
+	"github.com/gorilla/mux"
+)
+
+func InvoiceRoutes(deps Dependencies, r *mux.Router) {
+	invoices := deps.Store.Invoices()
+
+	// Invoices are priced from the products service
+	var generator *billing.InvoiceGenerator
+	if deps.Products != nil {
+		generator = billing.NewInvoiceGenerator(deps.Store, deps.Products)
+	}
+
+	// Invoice Routes
+	r.HandleFunc("/subscriptions/{id}/invoices", controllers.GetSubscriptionInvoices(deps.Store.Subscriptions(), invoices)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id}/invoices", controllers.CreateSubscriptionInvoice(generator)).Methods("POST")
+	r.HandleFunc("/invoices/{id}", controllers.GetInvoice(invoices)).Methods("GET")
+	r.HandleFunc("/invoices/{id}/html", controllers.GetInvoiceHTML(invoices)).Methods("GET")
+
+	// Invoice lifecycle
+	r.HandleFunc("/invoices/{id}/finalize", controllers.TransitionInvoice(invoices, models.InvoiceOpen)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/pay", controllers.TransitionInvoice(invoices, models.InvoicePaid)).Methods("POST")
+	r.HandleFunc("/invoices/{id}/void", controllers.TransitionInvoice(invoices, models.InvoiceVoid)).Methods("POST")
+}
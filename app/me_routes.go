@@ -0,0 +1,12 @@
+package app
+
+import (
+	"subscriptions/Controllers"
+	"github.com/gorilla/mux"
+)
+
+func MeRoutes(deps Dependencies, r *mux.Router) {
+	// Routes about the authenticated user
+	r.HandleFunc("/me/subscriptions", controllers.GetMySubscriptions(deps.Store.UserSubscriptions(), deps.Products)).Methods("GET")
+	r.HandleFunc("/me/entitlements", controllers.GetMyEntitlements(deps.Entitlements)).Methods("GET")
+}
@@ -0,0 +1,15 @@
+package app
+
+import (
+	"subscriptions/Controllers"
+	"github.com/gorilla/mux"
+)
+
+func OrganizationRoutes(deps Dependencies, r *mux.Router) {
+	organizations := deps.Store.Organizations()
+
+	// Organization Routes
+	r.HandleFunc("/organizations", controllers.CreateOrganization(organizations)).Methods("POST")
+	r.HandleFunc("/organizations/{id}", controllers.GetOrganization(organizations)).Methods("GET")
+	r.HandleFunc("/organizations/{id}/subscriptions", controllers.GetOrganizationSubscriptions(organizations, deps.Store.Subscriptions(), deps.Store.UserSubscriptions(), deps.Products)).Methods("GET")
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"subscriptions/Controllers"
+	"subscriptions/config"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+
+	defaults := config.Default().Server
+	cfg := ServerConfig{
+		Addr:              defaults.Addr,
+		ReadTimeout:       defaults.ReadTimeout,
+		ReadHeaderTimeout: defaults.ReadHeaderTimeout,
+		WriteTimeout:      defaults.WriteTimeout,
+		IdleTimeout:       defaults.IdleTimeout,
+		MaxHeaderBytes:    defaults.MaxHeaderBytes,
+		ShutdownTimeout:   5 * time.Second,
+	}
+	readiness := controllers.NewReadiness()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, NewServer(cfg, handler), l, readiness, cfg)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-responses
+	assert.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-served)
+	assert.True(t, readiness.Draining())
+
+	_, err = http.Get("http://" + l.Addr().String())
+	assert.Error(t, err, "listener should be closed after shutdown")
+}
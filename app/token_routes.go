@@ -0,0 +1,31 @@
+package app
+
+import (
+	"log"
+	"subscriptions/Controllers"
+	"subscriptions/tokens"
+	"github.com/gorilla/mux"
+)
+
+// tokenIssuer returns the issuer of deps, or one signing with a throwaway
+// key when there is none.
+func tokenIssuer(deps Dependencies) *tokens.Issuer {
+	if deps.Tokens != nil {
+		return deps.Tokens
+	}
+	keys, err := tokens.GenerateKeys()
+	if err != nil {
+		log.Fatalf("Generating entitlement token key: %v", err)
+	}
+	return tokens.NewIssuer(deps.Store.Subscriptions(), keys)
+}
+
+func TokenRoutes(deps Dependencies, r *mux.Router) {
+	// Entitlement token Routes
+	r.HandleFunc("/entitlements/tokens", controllers.IssueEntitlementToken(deps.Tokens)).Methods("POST")
+}
+
+// JWKSRoutes publishes the token keys; verifiers fetch them without credentials.
+func JWKSRoutes(deps Dependencies, r *mux.Router) {
+	r.HandleFunc("/.well-known/jwks.json", controllers.GetJWKS(deps.Tokens.Keys)).Methods("GET")
+}
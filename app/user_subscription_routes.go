@@ -1,16 +1,17 @@
 package app
 
 import (
-	"database/sql"
 	"subscriptions/Controllers"
 	"github.com/gorilla/mux"
 )
 
-func UserSubscriptionRoutes(db *sql.DB, r *mux.Router) {
+func UserSubscriptionRoutes(deps Dependencies, r *mux.Router) {
+	userSubscriptions := deps.Store.UserSubscriptions()
+
 	// User Subscription Routes
-	r.HandleFunc("/user_subscriptions", controllers.GetUserSubscriptions(db)).Methods("GET")
-	r.HandleFunc("/user_subscriptions/{id}", controllers.GetUserSubscriptionByID(db)).Methods("GET")
-	r.HandleFunc("/user_subscriptions", controllers.CreateUserSubscription(db)).Methods("POST")
-	r.HandleFunc("/user_subscriptions/{id}", controllers.UpdateUserSubscription(db)).Methods("PUT")
-	r.HandleFunc("/user_subscriptions/{id}", controllers.DeleteUserSubscription(db)).Methods("DELETE")
+	r.HandleFunc("/user_subscriptions", controllers.GetUserSubscriptions(userSubscriptions, deps.Products)).Methods("GET")
+	r.HandleFunc("/user_subscriptions/{id}", controllers.GetUserSubscriptionByID(userSubscriptions)).Methods("GET")
+	r.HandleFunc("/user_subscriptions", deps.Idempotency.Wrap(controllers.CreateUserSubscription(userSubscriptions))).Methods("POST")
+	r.HandleFunc("/user_subscriptions/{id}", controllers.UpdateUserSubscription(userSubscriptions)).Methods("PUT")
+	r.HandleFunc("/user_subscriptions/{id}", controllers.DeleteUserSubscription(userSubscriptions)).Methods("DELETE")
 }
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key secret, which tells them apart from JWTs.
+const APIKeyPrefix = "sk_"
+
+// displayPrefixLength is how much of a secret is kept in the clear to tell
+// keys apart.
+const displayPrefixLength = len(APIKeyPrefix) + 6
+
+// GenerateAPIKey returns a new random secret, the prefix shown for it and
+// the hash stored in its place.
+func GenerateAPIKey() (secret, prefix, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", err
+	}
+	secret = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	return secret, secret[:displayPrefixLength], HashAPIKey(secret), nil
+}
+
+// HashAPIKey returns the hex SHA-256 of secret. Secrets are random, so an
+// unsalted fast hash is enough to keep a database leak from exposing them.
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// isAPIKey reports whether a bearer credential is an API key rather than a JWT.
+func isAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
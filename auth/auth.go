@@ -0,0 +1,192 @@
+// Package auth authenticates API callers, either users holding a bearer JWT
+// from the identity provider or services holding an API key, and attaches
+// the resulting Principal to the request context.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"subscriptions/store"
+	"subscriptions/tokens/verify"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no bearer credential.
+	ErrNoCredentials = errors.New("missing bearer credentials")
+	// ErrInvalidCredentials is returned for unknown or revoked API keys and
+	// for JWTs that do not verify.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator authenticates requests from their Authorization header.
+type Authenticator struct {
+	// JWT verifies bearer JWTs; nil rejects them
+	JWT *verify.Verifier
+	// APIKeys looks up API keys; nil rejects them
+	APIKeys store.APIKeyStore
+}
+
+// userClaims are the claims a user JWT is read from besides the registered
+// ones. The user is user_id or, when absent, a numeric sub. Identity
+// providers often encode custom claims as strings, so both forms are read.
+type userClaims struct {
+	Subject        string      `json:"sub"`
+	UserID         json.Number `json:"user_id"`
+	OrganizationID json.Number `json:"organization_id"`
+	Roles          []string    `json:"roles"`
+}
+
+// Authenticate returns the principal of r. Credentials that fail to verify,
+// or that name no organization without being platform_admin, wrap
+// ErrInvalidCredentials.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	scheme, credential, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	credential = strings.TrimSpace(credential)
+	if !strings.EqualFold(scheme, "Bearer") || credential == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	var principal Principal
+	var err error
+	if isAPIKey(credential) {
+		principal, err = a.authenticateAPIKey(r.Context(), credential)
+	} else {
+		principal, err = a.authenticateJWT(r.Context(), credential)
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	// Requests without an organization are not scoped and see every
+	// organization's data, which only the platform may
+	if principal.OrganizationID == 0 && !principal.IsPlatform() {
+		return Principal{}, fmt.Errorf("%w: credentials without an organization must carry the %s role", ErrInvalidCredentials, RolePlatformAdmin)
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, secret string) (Principal, error) {
+	if a.APIKeys == nil {
+		return Principal{}, fmt.Errorf("%w: API keys are not accepted", ErrInvalidCredentials)
+	}
+	key, err := a.APIKeys.FindByHash(ctx, HashAPIKey(secret))
+	if errors.Is(err, store.ErrNotFound) {
+		return Principal{}, fmt.Errorf("%w: unknown or revoked API key", ErrInvalidCredentials)
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	principal := Principal{Kind: KindService, Subject: "api_key:" + strconv.Itoa(key.ID), APIKeyID: key.ID, Roles: knownRoles(key.Roles)}
+	if key.OrganizationID != nil {
+		principal.OrganizationID = *key.OrganizationID
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) authenticateJWT(ctx context.Context, token string) (Principal, error) {
+	if a.JWT == nil {
+		return Principal{}, fmt.Errorf("%w: bearer JWTs are not accepted", ErrInvalidCredentials)
+	}
+	var claims userClaims
+	if err := a.JWT.VerifyInto(ctx, token, &claims); err != nil {
+		if !isTokenError(err) {
+			// Such as the JWKS endpoint being down
+			return Principal{}, err
+		}
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Subject == "" {
+		return Principal{}, fmt.Errorf("%w: token has no sub claim", ErrInvalidCredentials)
+	}
+	principal := Principal{Kind: KindUser, Subject: claims.Subject, Roles: knownRoles(claims.Roles)}
+	userID := claims.UserID.String()
+	if userID == "" {
+		userID = claims.Subject
+	}
+	principal.UserID, _ = strconv.Atoi(userID)
+	if claims.OrganizationID != "" {
+		id, err := strconv.Atoi(claims.OrganizationID.String())
+		if err != nil || id < 1 {
+			return Principal{}, fmt.Errorf("%w: invalid organization_id claim", ErrInvalidCredentials)
+		}
+		principal.OrganizationID = id
+	}
+	return principal, nil
+}
+
+// isTokenError reports whether err is the token's fault rather than the
+// verifier's.
+func isTokenError(err error) bool {
+	for _, target := range []error{verify.ErrMalformed, verify.ErrSignature, verify.ErrExpired, verify.ErrIssuer, verify.ErrAudience, verify.ErrUnknownKey} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests that do not authenticate with 401 and passes
+// the others on with their principal attached.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		switch {
+		case errors.Is(err, ErrNoCredentials):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="subscriptions"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrInvalidCredentials):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="subscriptions", error="invalid_token"`)
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Printf("Authentication error: %v", err)
+			http.Error(w, "Authentication unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// ParseKeyFiles reads a comma separated list of kid=path pairs naming PEM
+// encoded public keys (RSA, P-256 or Ed25519) that bearer JWTs are signed with.
+func ParseKeyFiles(spec string) (verify.StaticKeys, error) {
+	keys := verify.StaticKeys{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(pair, "=")
+		if !ok || kid == "" || path == "" {
+			return nil, errors.New("JWT keys must be kid=path pairs")
+		}
+		if _, ok := keys[kid]; ok {
+			return nil, fmt.Errorf("duplicate JWT key id %q", kid)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("JWT key %q: %s is not PEM encoded", kid, path)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT keys")
+	}
+	return keys, nil
+}
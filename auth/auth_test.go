@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"subscriptions/models"
+	"subscriptions/store"
+	"subscriptions/tokens/verify"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signES256 encodes claims as a JWT the way an identity provider would.
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(verify.Header{Algorithm: verify.ES256, Type: "JWT", KeyID: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	organizationID := 3
+	secret, prefix, hash, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	assert.Equal(t, secret[:len(prefix)], prefix)
+	key := models.APIKey{Name: "billing-worker", Prefix: prefix, Hash: hash, OrganizationID: &organizationID, Roles: []string{"billing_admin"}}
+	assert.NoError(t, s.APIKeys().Create(ctx, &key))
+
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	verifier := verify.NewVerifier(verify.StaticKeys{"idp": &signingKey.PublicKey}, "https://idp.example.com")
+	verifier.Audience = "subscriptions"
+	authenticator := &Authenticator{JWT: verifier, APIKeys: s.APIKeys()}
+
+	authenticate := func(authorization string) (Principal, error) {
+		req := httptest.NewRequest("GET", "/subscriptions", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return authenticator.Authenticate(req)
+	}
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": "https://idp.example.com", "aud": "subscriptions", "sub": "42", "organization_id": 1, "iat": now, "exp": now + 60}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	t.Run("api key", func(t *testing.T) {
+		principal, err := authenticate("Bearer " + secret)
+		assert.NoError(t, err)
+		assert.Equal(t, Principal{Kind: KindService, Subject: "api_key:1", OrganizationID: 3, APIKeyID: 1, Roles: []Role{RoleBillingAdmin}}, principal)
+
+		_, err = authenticate("Bearer sk_unknown")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		_, err = s.APIKeys().Revoke(ctx, key.ID, time.Now())
+		assert.NoError(t, err)
+		_, err = authenticate("Bearer " + secret)
+		assert.ErrorIs(t, err, ErrInvalidCredentials, "revoked")
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		principal, err := authenticate("Bearer " + signES256(t, signingKey, "idp", claims(nil)))
+		assert.NoError(t, err)
+		assert.Equal(t, Principal{Kind: KindUser, Subject: "42", UserID: 42, OrganizationID: 1, Roles: []Role{}}, principal, "numeric sub is the user")
+
+		principal, err = authenticate("bearer " + signES256(t, signingKey, "idp", claims(map[string]interface{}{"sub": "auth0|abc", "user_id": "7", "organization_id": 2,
+			"roles": []string{"org_admin", "wiki_editor"}})))
+		assert.NoError(t, err)
+		assert.Equal(t, Principal{Kind: KindUser, Subject: "auth0|abc", UserID: 7, OrganizationID: 2, Roles: []Role{RoleOrgAdmin}}, principal, "unknown roles are dropped")
+
+		for name, token := range map[string]string{
+			"wrong audience":   signES256(t, signingKey, "idp", claims(map[string]interface{}{"aud": "billing"})),
+			"wrong issuer":     signES256(t, signingKey, "idp", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+			"expired":          signES256(t, signingKey, "idp", claims(map[string]interface{}{"exp": now - 600})),
+			"unknown key":      signES256(t, signingKey, "other", claims(nil)),
+			"no subject":       signES256(t, signingKey, "idp", claims(map[string]interface{}{"sub": ""})),
+			"bad organization": signES256(t, signingKey, "idp", claims(map[string]interface{}{"organization_id": "acme"})),
+			"no organization":  signES256(t, signingKey, "idp", claims(map[string]interface{}{"organization_id": nil, "roles": []string{"org_admin", "billing_admin"}})),
+			"garbage":          "not-a-token",
+		} {
+			_, err := authenticate("Bearer " + token)
+			assert.ErrorIs(t, err, ErrInvalidCredentials, name)
+		}
+
+		principal, err = authenticate("Bearer " + signES256(t, signingKey, "idp", claims(map[string]interface{}{"organization_id": nil, "roles": []string{"platform_admin"}})))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, principal.OrganizationID, "the platform acts outside of organizations")
+	})
+
+	t.Run("api key without organization", func(t *testing.T) {
+		secret, prefix, hash, err := GenerateAPIKey()
+		assert.NoError(t, err)
+		assert.NoError(t, s.APIKeys().Create(ctx, &models.APIKey{Name: "stray", Prefix: prefix, Hash: hash, Roles: []string{"org_admin"}}))
+		_, err = authenticate("Bearer " + secret)
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		for _, authorization := range []string{"", "Basic dXNlcjpwYXNz", "Bearer "} {
+			_, err := authenticate(authorization)
+			assert.ErrorIs(t, err, ErrNoCredentials, authorization)
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	s := store.NewMemory()
+	secret, prefix, hash, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	assert.NoError(t, s.APIKeys().Create(context.Background(), &models.APIKey{Name: "ci", Prefix: prefix, Hash: hash, Roles: []string{"platform_admin"}}))
+
+	handler := (&Authenticator{APIKeys: s.APIKeys()}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, KindService, principal.Kind)
+	}))
+	do := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("Bearer "+secret).Code)
+
+	w := do("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="subscriptions"`, w.Header().Get("WWW-Authenticate"))
+
+	w = do("Bearer eyJhbGciOiJFUzI1NiJ9.e30.c2ln")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "JWTs are not accepted without a verifier")
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+func TestParseKeyFiles(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "idp.pem")
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	keys, err := ParseKeyFiles(" idp=" + path + " ,")
+	assert.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(keys["idp"]))
+
+	for _, spec := range []string{"", "idp", "idp=" + path + ",idp=" + path, "idp=/does/not/exist", "idp=" + os.Args[0]} {
+		_, err := ParseKeyFiles(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	billing := Principal{Kind: KindUser, Roles: []Role{RoleBillingAdmin}}
+	assert.True(t, billing.Has(PermSubscriptionsWrite))
+	assert.False(t, billing.Has(PermSeatsWrite), "billing admins do not assign seats")
+	billingCtx := WithPrincipal(context.Background(), billing)
+	assert.NoError(t, AuthorizeGrant(billingCtx, RoleService))
+	assert.Error(t, AuthorizeGrant(billingCtx, RoleOrgAdmin))
+	assert.Error(t, AuthorizeGrant(billingCtx, RolePlatformAdmin))
+
+	ctx := WithPrincipal(context.Background(), Principal{Kind: KindUser, UserID: 7, Roles: []Role{RoleUser}})
+	assert.NoError(t, Authorize(ctx, PermSeatsReadOwn))
+	var missing *PermissionError
+	if assert.ErrorAs(t, Authorize(ctx, PermSeatsRead), &missing) {
+		assert.Equal(t, PermSeatsRead, missing.Permission)
+		assert.Equal(t, "missing permission seats:read", missing.Error())
+	}
+	assert.NoError(t, Authorize(context.Background(), PermOrganizationsWrite), "authentication is off")
+
+	roles, err := ParseRoles([]string{"org_admin", "user"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Role{RoleOrgAdmin, RoleUser}, roles)
+	_, err = ParseRoles([]string{"root"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,42 @@
+package auth
+
+import "context"
+
+// Kind says how a principal authenticated.
+type Kind string
+
+const (
+	// KindUser is a person holding a bearer JWT from the identity provider.
+	KindUser Kind = "user"
+	// KindService is another service holding an API key.
+	KindService Kind = "service"
+)
+
+// Principal is the caller a request acts for.
+type Principal struct {
+	Kind Kind `json:"kind"`
+	// Subject is the sub claim of a JWT, or api_key:<id> for services
+	Subject string `json:"subject"`
+	// UserID is the user a JWT was issued to; 0 for services
+	UserID int `json:"user_id,omitempty"`
+	// OrganizationID is the organization the caller belongs to; 0 for
+	// platform callers, which may act for any organization.
+	OrganizationID int `json:"organization_id,omitempty"`
+	// APIKeyID is the key a service authenticated with
+	APIKeyID int    `json:"api_key_id,omitempty"`
+	Roles    []Role `json:"roles"`
+}
+
+// principalKey is the context key of the principal.
+type principalKey struct{}
+
+// WithPrincipal attaches the authenticated caller to ctx.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the caller attached to ctx, if any.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+)
+
+// Permission is an action a caller may be allowed to take.
+type Permission string
+
+const (
+	PermOrganizationsRead  Permission = "organizations:read"
+	PermOrganizationsWrite Permission = "organizations:write"
+	PermAPIKeysManage      Permission = "api_keys:manage"
+	PermSubscriptionsRead  Permission = "subscriptions:read"
+	PermSubscriptionsWrite Permission = "subscriptions:write"
+	PermSeatsRead          Permission = "seats:read"
+	// PermSeatsReadOwn allows reading only the seats the caller holds
+	PermSeatsReadOwn     Permission = "seats:read:own"
+	PermSeatsWrite       Permission = "seats:write"
+	PermInvoicesRead     Permission = "invoices:read"
+	PermInvoicesWrite    Permission = "invoices:write"
+	PermEntitlementsRead Permission = "entitlements:read"
+	// PermEntitlementsReadOwn allows reading only the caller's entitlements
+	PermEntitlementsReadOwn Permission = "entitlements:read:own"
+)
+
+// Role is a named set of permissions given to callers.
+type Role string
+
+const (
+	// RolePlatformAdmin runs the service and may do anything.
+	RolePlatformAdmin Role = "platform_admin"
+	// RoleBillingAdmin creates, resizes and bills subscriptions.
+	RoleBillingAdmin Role = "billing_admin"
+	// RoleOrgAdmin assigns the seats of their organization's subscriptions.
+	RoleOrgAdmin Role = "org_admin"
+	// RoleService is a service checking entitlements.
+	RoleService Role = "service"
+	// RoleUser is an end user holding seats.
+	RoleUser Role = "user"
+)
+
+// rolePermissions is the permission model. Permissions are checked within
+// the organization scope, so the same role gives an organization's callers
+// power over that organization only.
+var rolePermissions = map[Role][]Permission{
+	RolePlatformAdmin: {
+		PermOrganizationsRead, PermOrganizationsWrite, PermAPIKeysManage,
+		PermSubscriptionsRead, PermSubscriptionsWrite, PermSeatsRead, PermSeatsReadOwn, PermSeatsWrite,
+		PermInvoicesRead, PermInvoicesWrite, PermEntitlementsRead, PermEntitlementsReadOwn,
+	},
+	RoleBillingAdmin: {
+		PermOrganizationsRead, PermSubscriptionsRead, PermSubscriptionsWrite, PermSeatsRead,
+		PermInvoicesRead, PermInvoicesWrite, PermEntitlementsRead,
+	},
+	RoleOrgAdmin: {
+		PermOrganizationsRead, PermAPIKeysManage, PermSubscriptionsRead, PermSeatsRead, PermSeatsWrite,
+		PermInvoicesRead, PermEntitlementsRead,
+	},
+	RoleService: {PermEntitlementsRead},
+	RoleUser:    {PermSeatsReadOwn, PermEntitlementsReadOwn},
+}
+
+// ParseRoles converts role names, failing on unknown ones.
+func ParseRoles(names []string) ([]Role, error) {
+	roles := make([]Role, 0, len(names))
+	for _, name := range names {
+		role := Role(name)
+		if _, ok := rolePermissions[role]; !ok {
+			return nil, fmt.Errorf("unknown role %q", name)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// knownRoles converts role names, dropping the ones this service does not
+// know, such as roles the identity provider issues for other services.
+func knownRoles(names []string) []Role {
+	roles := []Role{}
+	for _, name := range names {
+		if _, ok := rolePermissions[Role(name)]; ok {
+			roles = append(roles, Role(name))
+		}
+	}
+	return roles
+}
+
+// IsPlatform reports whether the principal may act outside of any
+// organization, which only platform_admin allows.
+func (p Principal) IsPlatform() bool {
+	return HasPlatformRole(p.Roles)
+}
+
+// HasPlatformRole reports whether roles include platform_admin.
+func HasPlatformRole(roles []Role) bool {
+	for _, role := range roles {
+		if role == RolePlatformAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether one of the principal's roles grants permission.
+func (p Principal) Has(permission Permission) bool {
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PermissionError reports the permission a caller lacks.
+type PermissionError struct {
+	Permission Permission
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("missing permission %s", e.Permission)
+}
+
+// AuthorizeGrant fails with a *PermissionError unless the caller of ctx
+// holds every permission of role, so handing it out, say on an API key,
+// gives no one more power than the caller has.
+func AuthorizeGrant(ctx context.Context, role Role) error {
+	for _, permission := range rolePermissions[role] {
+		if err := Authorize(ctx, permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Authorize fails with a *PermissionError unless the caller of ctx has
+// permission. Contexts without a principal are let through: they only
+// reach handlers when authentication is turned off.
+func Authorize(ctx context.Context, permission Permission) error {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok || principal.Has(permission) {
+		return nil
+	}
+	return &PermissionError{Permission: permission}
+}
@@ -0,0 +1,121 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"subscriptions/clients"
+	"subscriptions/models"
+	"subscriptions/store"
+	"time"
+)
+
+// ErrNotBillable is returned for subscriptions whose current period is not
+// charged: trials and subscriptions that are paused, canceled or expired.
+var ErrNotBillable = errors.New("subscription is not billable")
+
+// PriceLookup resolves the products subscriptions are billed for.
+type PriceLookup interface {
+	GetOfferById(ctx context.Context, id int) (*clients.Product, error)
+}
+
+// InvoiceGenerator drafts the invoices of subscription periods.
+type InvoiceGenerator struct {
+	Subscriptions store.SubscriptionStore
+	Invoices      store.InvoiceStore
+	Products      PriceLookup
+}
+
+// NewInvoiceGenerator creates an InvoiceGenerator pricing subscriptions with products.
+func NewInvoiceGenerator(s store.Store, products PriceLookup) *InvoiceGenerator {
+	return &InvoiceGenerator{Subscriptions: s.Subscriptions(), Invoices: s.Invoices(), Products: products}
+}
+
+// Generate drafts the invoice of the current period of a subscription. It
+// fails with store.ErrInvoiceExists when the period is already invoiced and
+// with ErrNotBillable when the subscription is not charged for it. Errors
+// looking up the product wrap the products client error.
+func (g *InvoiceGenerator) Generate(ctx context.Context, subscriptionID int) (models.Invoice, error) {
+	subscription, err := g.Subscriptions.Get(ctx, subscriptionID)
+	if err != nil {
+		return models.Invoice{}, err
+	}
+	if subscription.Status != models.StatusActive && subscription.Status != models.StatusPastDue {
+		return models.Invoice{}, fmt.Errorf("%w: subscription is %s", ErrNotBillable, subscription.Status)
+	}
+
+	product, err := g.Products.GetOfferById(ctx, subscription.ProductID)
+	if err != nil {
+		return models.Invoice{}, fmt.Errorf("pricing product %d: %w", subscription.ProductID, err)
+	}
+	changes, err := g.Invoices.UnbilledPlanChanges(ctx, subscriptionID)
+	if err != nil {
+		return models.Invoice{}, err
+	}
+
+	invoice := BuildInvoice(subscription, *product, changes)
+	if err := g.Invoices.Create(ctx, &invoice); err != nil {
+		return models.Invoice{}, err
+	}
+	return invoice, nil
+}
+
+// BuildInvoice computes the invoice of the current period of subscription:
+// the product price for every licensed seat, the prorations of plan changes
+// not billed yet and the subscription's discount on top.
+func BuildInvoice(subscription models.Subscription, product clients.Product, changes []models.PlanChange) models.Invoice {
+	invoice := models.Invoice{
+		SubscriptionID: subscription.ID,
+		PeriodStart:    subscription.CurrentPeriodStart,
+		PeriodEnd:      subscription.CurrentPeriodEnd,
+		Lines: []models.InvoiceLine{{
+			Kind:        models.LineBase,
+			Description: fmt.Sprintf("%s, %d seat(s), %s to %s", product.Name, subscription.LicenseCount, formatDate(subscription.CurrentPeriodStart), formatDate(subscription.CurrentPeriodEnd)),
+			Quantity:    subscription.LicenseCount,
+			UnitPrice:   product.Price,
+			Amount:      roundCents(product.Price * float64(subscription.LicenseCount)),
+		}},
+	}
+
+	for _, change := range changes {
+		planChangeID := change.ID
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Kind:         models.LineProration,
+			Description:  fmt.Sprintf("Plan change on %s: product %d, %d seat(s) to product %d, %d seat(s)", formatDate(change.EffectiveAt), change.FromProductID, change.FromLicenseCount, change.ToProductID, change.ToLicenseCount),
+			Quantity:     1,
+			UnitPrice:    change.Proration,
+			Amount:       change.Proration,
+			PlanChangeID: &planChangeID,
+		})
+	}
+
+	for _, line := range invoice.Lines {
+		invoice.Subtotal += line.Amount
+	}
+	invoice.Subtotal = roundCents(invoice.Subtotal)
+	invoice.Total = invoice.Subtotal
+
+	// Credits from downgrades can outweigh the charges; only a positive
+	// subtotal is discounted.
+	if subscription.DiscountPercent > 0 && invoice.Subtotal > 0 {
+		discount := roundCents(invoice.Subtotal * subscription.DiscountPercent / 100)
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Kind:        models.LineDiscount,
+			Description: fmt.Sprintf("Discount (%g%%)", subscription.DiscountPercent),
+			Quantity:    1,
+			UnitPrice:   -discount,
+			Amount:      -discount,
+		})
+		invoice.Total = roundCents(invoice.Subtotal - discount)
+	}
+	return invoice
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
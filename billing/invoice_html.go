@@ -0,0 +1,55 @@
+package billing
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"subscriptions/models"
+)
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money": func(amount float64) string { return fmt.Sprintf("%.2f", amount) },
+	"date":  formatDate,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Invoice {{if .Number}}{{.Number}}{{else}}draft {{.ID}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.4em; text-align: left; }
+td.amount, th.amount { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Invoice {{if .Number}}{{.Number}}{{else}}(draft){{end}}</h1>
+<p>Status: {{.Status}}<br>
+Subscription: {{.SubscriptionID}}<br>
+Period: {{date .PeriodStart}} to {{date .PeriodEnd}}
+{{- with .IssuedAt}}<br>
+Issued: {{date .}}{{end}}
+{{- with .PaidAt}}<br>
+Paid: {{date .}}{{end}}
+{{- with .VoidedAt}}<br>
+Voided: {{date .}}{{end}}</p>
+<table>
+<thead><tr><th>Description</th><th class="amount">Quantity</th><th class="amount">Unit price</th><th class="amount">Amount</th></tr></thead>
+<tbody>
+{{- range .Lines}}
+<tr><td>{{.Description}}</td><td class="amount">{{.Quantity}}</td><td class="amount">{{money .UnitPrice}}</td><td class="amount">{{money .Amount}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot>
+<tr><th colspan="3">Subtotal</th><td class="amount">{{money .Subtotal}}</td></tr>
+<tr><th colspan="3">Total</th><td class="amount">{{money .Total}}</td></tr>
+</tfoot>
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML writes invoice as a standalone HTML page.
+func RenderHTML(w io.Writer, invoice models.Invoice) error {
+	return invoiceTemplate.Execute(w, invoice)
+}
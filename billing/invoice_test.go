@@ -0,0 +1,187 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"subscriptions/clients"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prices answers product lookups from a fixed catalog.
+type prices map[int]clients.Product
+
+func (p prices) GetOfferById(ctx context.Context, id int) (*clients.Product, error) {
+	product, ok := p[id]
+	if !ok {
+		return nil, clients.ErrNotFound
+	}
+	return &product, nil
+}
+
+func TestBuildInvoice(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	subscription := models.Subscription{ID: 7, ProductID: 101, LicenseCount: 3, DiscountPercent: 10,
+		CurrentPeriodStart: start, CurrentPeriodEnd: start.AddDate(0, 1, 0)}
+	changes := []models.PlanChange{{ID: 4, FromProductID: 101, FromLicenseCount: 2, ToProductID: 101, ToLicenseCount: 3, Proration: 5.5, EffectiveAt: start.AddDate(0, 0, -15)}}
+
+	invoice := BuildInvoice(subscription, clients.Product{ID: 101, Name: "Editor", Price: 10}, changes)
+	assert.Equal(t, 7, invoice.SubscriptionID)
+	assert.Equal(t, start, invoice.PeriodStart)
+	if assert.Len(t, invoice.Lines, 3) {
+		assert.Equal(t, models.LineBase, invoice.Lines[0].Kind)
+		assert.Equal(t, 3, invoice.Lines[0].Quantity)
+		assert.Equal(t, 30.0, invoice.Lines[0].Amount)
+		assert.Equal(t, models.LineProration, invoice.Lines[1].Kind)
+		assert.Equal(t, 4, *invoice.Lines[1].PlanChangeID)
+		assert.Equal(t, models.LineDiscount, invoice.Lines[2].Kind)
+		assert.Equal(t, -3.55, invoice.Lines[2].Amount)
+	}
+	assert.Equal(t, 35.5, invoice.Subtotal)
+	assert.Equal(t, 31.95, invoice.Total)
+
+	// A credit larger than the charges is not discounted
+	changes[0].Proration = -40
+	invoice = BuildInvoice(subscription, clients.Product{ID: 101, Name: "Editor", Price: 10}, changes)
+	assert.Len(t, invoice.Lines, 2)
+	assert.Equal(t, -10.0, invoice.Total)
+}
+
+func TestInvoiceGenerator(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	generator := NewInvoiceGenerator(s, prices{101: {ID: 101, Name: "Editor", Price: 10}})
+
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 2, AutoRenew: true}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	change := models.PlanChange{SubscriptionID: subscription.ID, FromProductID: 101, FromLicenseCount: 2, ToProductID: 101, ToLicenseCount: 3, Timing: models.ChangeImmediately, Proration: 9.5}
+	_, err := s.Subscriptions().ChangePlan(ctx, &change, store.SeatPolicyReject, time.Now())
+	assert.NoError(t, err)
+
+	invoice, err := generator.Generate(ctx, subscription.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.InvoiceDraft, invoice.Status)
+	assert.Empty(t, invoice.Number, "drafts are not numbered")
+	assert.Equal(t, 39.5, invoice.Total)
+
+	_, err = generator.Generate(ctx, subscription.ID)
+	assert.ErrorIs(t, err, store.ErrInvoiceExists)
+
+	// Voiding frees the period and the proration for a new invoice
+	_, err = s.Invoices().Transition(ctx, invoice.ID, models.InvoiceVoid, time.Now())
+	assert.NoError(t, err)
+	again, err := generator.Generate(ctx, subscription.ID)
+	assert.NoError(t, err)
+	assert.Len(t, again.Lines, 2)
+
+	first, err := s.Invoices().Transition(ctx, again.ID, models.InvoiceOpen, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "INV-000001", first.Number)
+	assert.NotNil(t, first.IssuedAt)
+	_, err = s.Invoices().Transition(ctx, again.ID, models.InvoiceDraft, time.Now())
+	assert.ErrorIs(t, err, store.ErrInvalidTransition)
+
+	trial := models.Subscription{Name: "Trial", ProductID: 101, LicenseCount: 2}
+	trialEnd := time.Now().Add(time.Hour)
+	trial.TrialEnd = &trialEnd
+	assert.NoError(t, s.Subscriptions().Create(ctx, &trial))
+	_, err = generator.Generate(ctx, trial.ID)
+	assert.ErrorIs(t, err, ErrNotBillable)
+
+	unknown := models.Subscription{Name: "Retired", ProductID: 999, LicenseCount: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &unknown))
+	_, err = generator.Generate(ctx, unknown.ID)
+	assert.ErrorIs(t, err, clients.ErrNotFound)
+}
+
+func TestRenewerInvoicesRenewedPeriods(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 2, AutoRenew: true}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	oneOff := models.Subscription{Name: "One-off", ProductID: 101, LicenseCount: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &oneOff))
+
+	fake := clock.NewFake(subscription.CurrentPeriodStart.AddDate(0, 2, 0))
+	renewer := &Renewer{Subscriptions: s.Subscriptions(), Clock: fake, Invoices: NewInvoiceGenerator(s, prices{101: {ID: 101, Name: "Editor", Price: 10}})}
+
+	result, err := renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Renewed)
+	assert.Equal(t, 2, result.Invoiced, "one invoice per renewed period")
+
+	invoices, err := s.Invoices().ListBySubscription(ctx, subscription.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, invoices, 2) {
+		assert.Equal(t, models.InvoiceOpen, invoices[0].Status)
+		assert.Equal(t, "INV-000001", invoices[0].Number)
+		assert.Equal(t, "INV-000002", invoices[1].Number)
+		assert.Equal(t, subscription.CurrentPeriodEnd, invoices[0].PeriodStart)
+	}
+
+	invoices, err = s.Invoices().ListBySubscription(ctx, oneOff.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, invoices, "expired subscriptions are not invoiced")
+}
+
+func TestRenewerDoesNotBackBillPausedPeriods(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	subscriptions := s.Subscriptions()
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 2, AutoRenew: true}
+	assert.NoError(t, subscriptions.Create(ctx, &subscription))
+	oneOff := models.Subscription{Name: "One-off", ProductID: 101, LicenseCount: 1}
+	assert.NoError(t, subscriptions.Create(ctx, &oneOff))
+
+	fake := clock.NewFake(subscription.CurrentPeriodStart)
+	for _, id := range []int{subscription.ID, oneOff.ID} {
+		_, err := subscriptions.Transition(ctx, id, models.EventPause, fake.Now())
+		assert.NoError(t, err)
+	}
+
+	// Six months paused, then resumed
+	fake.Set(subscription.CurrentPeriodStart.AddDate(0, 6, 0))
+	resumedAt := fake.Now()
+	for _, id := range []int{subscription.ID, oneOff.ID} {
+		resumed, err := subscriptions.Transition(ctx, id, models.EventResume, resumedAt)
+		assert.NoError(t, err)
+		assert.Equal(t, resumedAt, resumed.CurrentPeriodStart, "the period restarts on resume")
+	}
+
+	renewer := &Renewer{Subscriptions: subscriptions, Clock: fake, Invoices: NewInvoiceGenerator(s, prices{101: {ID: 101, Name: "Editor", Price: 10}})}
+	result, err := renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result, "nothing is due right after resuming")
+
+	fake.Set(resumedAt.AddDate(0, 1, 0))
+	result, err = renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Renewed: 1, Expired: 1, Invoiced: 1}, result)
+
+	renewals, err := subscriptions.Renewals(ctx, subscription.ID)
+	assert.NoError(t, err)
+	assert.Len(t, renewals, 1)
+	invoices, err := s.Invoices().ListBySubscription(ctx, subscription.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, invoices, 1, "no invoices for the paused months") {
+		assert.Equal(t, resumedAt.AddDate(0, 1, 0), invoices[0].PeriodStart)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	issued := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	invoice := models.Invoice{ID: 3, Number: "INV-000042", Status: models.InvoiceOpen, IssuedAt: &issued, Total: 31.95,
+		Lines: []models.InvoiceLine{{Description: "<Editor>", Quantity: 3, UnitPrice: 10, Amount: 30}}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, RenderHTML(&buf, invoice))
+	assert.Contains(t, buf.String(), "Invoice INV-000042")
+	assert.Contains(t, buf.String(), "Issued: 2024-03-01")
+	assert.Contains(t, buf.String(), "31.95")
+	assert.Contains(t, buf.String(), "&lt;Editor&gt;", "descriptions are escaped")
+}
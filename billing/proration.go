@@ -0,0 +1,28 @@
+package billing
+
+import (
+	"subscriptions/models"
+	"time"
+)
+
+// Prorate returns what moving subscription to toPrice per seat for toSeats
+// seats costs for the rest of its current period at now. Prices are per seat
+// per billing period. A positive amount is charged, a negative one credited;
+// trials are free and never prorated. The result is rounded to cents.
+func Prorate(subscription models.Subscription, fromPrice, toPrice float64, toSeats int, now time.Time) float64 {
+	if subscription.Status == models.StatusTrialing {
+		return 0
+	}
+
+	period := subscription.CurrentPeriodEnd.Sub(subscription.CurrentPeriodStart)
+	remaining := subscription.CurrentPeriodEnd.Sub(now)
+	if period <= 0 || remaining <= 0 {
+		return 0
+	}
+	if remaining > period {
+		remaining = period
+	}
+
+	delta := toPrice*float64(toSeats) - fromPrice*float64(subscription.LicenseCount)
+	return roundCents(delta * float64(remaining) / float64(period))
+}
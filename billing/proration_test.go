@@ -0,0 +1,46 @@
+package billing
+
+import (
+	"subscriptions/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	subscription := models.Subscription{
+		Status:             models.StatusActive,
+		LicenseCount:       2,
+		CurrentPeriodStart: start,
+		CurrentPeriodEnd:   start.AddDate(0, 0, 30),
+	}
+
+	testCases := []struct {
+		name      string
+		fromPrice float64
+		toPrice   float64
+		toSeats   int
+		now       time.Time
+		status    models.SubscriptionStatus
+		expected  float64
+	}{
+		{name: "upgrade half way", fromPrice: 10, toPrice: 20, toSeats: 3, now: start.AddDate(0, 0, 15), expected: 20},
+		{name: "downgrade half way is credited", fromPrice: 20, toPrice: 10, toSeats: 2, now: start.AddDate(0, 0, 15), expected: -10},
+		{name: "more seats at the start", fromPrice: 10, toPrice: 10, toSeats: 5, now: start, expected: 30},
+		{name: "rounded to cents", fromPrice: 10, toPrice: 10, toSeats: 3, now: start.AddDate(0, 0, 20), expected: 3.33},
+		{name: "period already over", fromPrice: 10, toPrice: 20, toSeats: 3, now: start.AddDate(0, 0, 31), expected: 0},
+		{name: "before the period is charged in full", fromPrice: 10, toPrice: 20, toSeats: 2, now: start.AddDate(0, 0, -1), expected: 20},
+		{name: "trials are free", fromPrice: 10, toPrice: 20, toSeats: 3, now: start, status: models.StatusTrialing, expected: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subscription := subscription
+			if tc.status != "" {
+				subscription.Status = tc.status
+			}
+			assert.Equal(t, tc.expected, Prorate(subscription, tc.fromPrice, tc.toPrice, tc.toSeats, tc.now))
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Package billing runs the background jobs that move subscriptions through
+// their billing periods and prices and invoices those periods.
+package billing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"time"
+)
+
+// DefaultBatchSize is how many due subscriptions a Renewer loads at a time.
+const DefaultBatchSize = 100
+
+// Renewer renews or expires subscriptions whose billing period has ended.
+type Renewer struct {
+	Subscriptions store.SubscriptionStore
+	Clock         clock.Clock
+	// BatchSize limits how many due subscriptions are loaded per query.
+	BatchSize int
+	// Invoices, when set, invoices and finalizes every renewed period.
+	Invoices *InvoiceGenerator
+}
+
+// NewRenewer creates a Renewer using the system clock.
+func NewRenewer(subscriptions store.SubscriptionStore) *Renewer {
+	return &Renewer{Subscriptions: subscriptions, Clock: clock.Real{}, BatchSize: DefaultBatchSize}
+}
+
+// Result counts what one RunOnce did.
+type Result struct {
+	Renewed int
+	Expired int
+	Failed  int
+	// Invoiced counts the renewed periods that were invoiced.
+	Invoiced int
+}
+
+// RunOnce processes every subscription whose period has ended by now. A
+// subscription that is several periods behind is renewed once per period,
+// so each missed period gets its own renewal record.
+func (r *Renewer) RunOnce(ctx context.Context) (Result, error) {
+	var result Result
+	now := r.Clock.Now()
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	failed := map[int]bool{}
+	for {
+		due, err := r.Subscriptions.ListDue(ctx, now, batchSize+len(failed))
+		if err != nil {
+			return result, err
+		}
+
+		progress := false
+		for _, subscription := range due {
+			if failed[subscription.ID] {
+				continue
+			}
+			next, err := r.Subscriptions.EndPeriod(ctx, subscription.ID, now)
+			switch {
+			case errors.Is(err, store.ErrNotDue), errors.Is(err, store.ErrNotFound):
+				// Handled concurrently by another worker or deleted meanwhile
+				continue
+			case err != nil:
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				log.Printf("Failed to end billing period of subscription %d: %v", subscription.ID, err)
+				failed[subscription.ID] = true
+				result.Failed++
+				continue
+			}
+
+			progress = true
+			if !next.CurrentPeriodEnd.After(subscription.CurrentPeriodEnd) {
+				result.Expired++
+				continue
+			}
+			result.Renewed++
+			if r.Invoices != nil && r.invoice(ctx, subscription.ID, now) {
+				result.Invoiced++
+			}
+		}
+		if !progress {
+			return result, nil
+		}
+	}
+}
+
+// invoice invoices and finalizes the period a subscription was just renewed
+// for. A failure does not undo the renewal; it is logged so the period can
+// be invoiced through the API.
+func (r *Renewer) invoice(ctx context.Context, subscriptionID int, now time.Time) bool {
+	invoice, err := r.Invoices.Generate(ctx, subscriptionID)
+	if err == nil {
+		_, err = r.Invoices.Invoices.Transition(ctx, invoice.ID, models.InvoiceOpen, now)
+	}
+	switch {
+	case errors.Is(err, ErrNotBillable), errors.Is(err, store.ErrInvoiceExists):
+		return false
+	case err != nil:
+		log.Printf("Failed to invoice subscription %d: %v", subscriptionID, err)
+		return false
+	}
+	return true
+}
+
+// Run calls RunOnce every interval until ctx is cancelled.
+func (r *Renewer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := r.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Renewal run failed: %v", err)
+		}
+		if result.Renewed+result.Expired+result.Failed > 0 {
+			log.Printf("Renewal run: %d renewed, %d expired, %d failed, %d invoiced", result.Renewed, result.Expired, result.Failed, result.Invoiced)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package billing
+
+import (
+	"context"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// organizationContext scopes a context to a new organization of s, so the
+// subscriptions created with it belong somewhere.
+func organizationContext(t *testing.T, s store.Store) context.Context {
+	t.Helper()
+	organization := models.Organization{Name: "Acme"}
+	assert.NoError(t, s.Organizations().Create(context.Background(), &organization))
+	return store.WithOrganization(context.Background(), organization.ID)
+}
+
+func TestRenewerRunOnce(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	subscriptions := s.Subscriptions()
+
+	create := func(subscription models.Subscription) models.Subscription {
+		t.Helper()
+		assert.NoError(t, subscriptions.Create(ctx, &subscription))
+		return subscription
+	}
+	monthly := create(models.Subscription{Name: "Monthly", AutoRenew: true})
+	annual := create(models.Subscription{Name: "Annual", BillingInterval: models.IntervalAnnual, AutoRenew: true})
+	custom := create(models.Subscription{Name: "Custom", BillingInterval: models.IntervalCustom, IntervalDays: 10, AutoRenew: true})
+	oneOff := create(models.Subscription{Name: "One-off"})
+	paused := create(models.Subscription{Name: "Paused", AutoRenew: true})
+	_, err := subscriptions.Transition(ctx, paused.ID, models.EventPause, time.Now())
+	assert.NoError(t, err)
+	pastDue := create(models.Subscription{Name: "Past due", AutoRenew: true})
+	_, err = subscriptions.Transition(ctx, pastDue.ID, models.EventPaymentFailed, time.Now())
+	assert.NoError(t, err)
+
+	assert.Equal(t, monthly.CurrentPeriodStart.AddDate(0, 1, 0), monthly.CurrentPeriodEnd)
+	assert.Equal(t, annual.CurrentPeriodStart.AddDate(1, 0, 0), annual.CurrentPeriodEnd)
+	assert.Equal(t, custom.CurrentPeriodStart.AddDate(0, 0, 10), custom.CurrentPeriodEnd)
+
+	fake := clock.NewFake(monthly.CurrentPeriodStart)
+	renewer := &Renewer{Subscriptions: subscriptions, Clock: fake, BatchSize: 2}
+
+	result, err := renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result, "nothing is due before the first period ends")
+
+	// Three months later: the monthly subscription catches up one renewal
+	// per missed period, the custom one every ten days, the annual one not at all.
+	fake.Set(monthly.CurrentPeriodStart.AddDate(0, 3, 0))
+	result, err = renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Expired, "one-off and past due subscriptions expire")
+	assert.Equal(t, 0, result.Failed)
+
+	got, err := subscriptions.Get(ctx, monthly.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusActive, got.Status)
+	assert.Equal(t, monthly.CurrentPeriodStart.AddDate(0, 3, 0), got.CurrentPeriodStart)
+	assert.Equal(t, monthly.CurrentPeriodStart.AddDate(0, 4, 0), got.CurrentPeriodEnd)
+
+	renewals, err := subscriptions.Renewals(ctx, monthly.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, renewals, 3) {
+		assert.Equal(t, monthly.CurrentPeriodEnd, renewals[0].PeriodStart)
+		assert.Equal(t, got.CurrentPeriodEnd, renewals[2].PeriodEnd)
+	}
+
+	renewals, err = subscriptions.Renewals(ctx, custom.ID)
+	assert.NoError(t, err)
+	assert.Len(t, renewals, int(fake.Now().Sub(custom.CurrentPeriodStart)/(10*24*time.Hour)))
+	assert.Equal(t, 3+len(renewals), result.Renewed)
+
+	for id, status := range map[int]models.SubscriptionStatus{
+		annual.ID:  models.StatusActive,
+		oneOff.ID:  models.StatusExpired,
+		paused.ID:  models.StatusPaused,
+		pastDue.ID: models.StatusExpired,
+	} {
+		got, err := subscriptions.Get(ctx, id)
+		assert.NoError(t, err)
+		assert.Equal(t, status, got.Status, "subscription %d", id)
+	}
+	renewals, err = subscriptions.Renewals(ctx, annual.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, renewals)
+
+	result, err = renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result, "a second run at the same time is a no-op")
+}
+
+func TestEndPeriodIsIdempotent(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	subscriptions := s.Subscriptions()
+	subscription := models.Subscription{Name: "Monthly", AutoRenew: true}
+	assert.NoError(t, subscriptions.Create(ctx, &subscription))
+
+	now := subscription.CurrentPeriodEnd
+	_, err := subscriptions.EndPeriod(ctx, subscription.ID, now)
+	assert.NoError(t, err)
+	_, err = subscriptions.EndPeriod(ctx, subscription.ID, now)
+	assert.ErrorIs(t, err, store.ErrNotDue, "a second worker must not renew the same period")
+
+	renewals, err := subscriptions.Renewals(ctx, subscription.ID)
+	assert.NoError(t, err)
+	assert.Len(t, renewals, 1)
+}
+
+func TestRenewerEndsTrials(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	subscriptions := s.Subscriptions()
+
+	trialEnd := time.Now().Add(14 * 24 * time.Hour).UTC()
+	converting := models.Subscription{Name: "Converting", LicenseCount: 10, AutoRenew: true, TrialEnd: &trialEnd, TrialSeatLimit: 2}
+	assert.NoError(t, subscriptions.Create(ctx, &converting))
+	lapsing := models.Subscription{Name: "Lapsing", LicenseCount: 10, TrialEnd: &trialEnd}
+	assert.NoError(t, subscriptions.Create(ctx, &lapsing))
+
+	assert.Equal(t, models.StatusTrialing, converting.Status)
+	assert.Equal(t, trialEnd, converting.CurrentPeriodEnd, "the first period is the trial")
+	assert.Equal(t, 2, converting.SeatLimit())
+
+	fake := clock.NewFake(trialEnd.Add(-time.Second))
+	renewer := &Renewer{Subscriptions: subscriptions, Clock: fake}
+	result, err := renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+
+	fake.Set(trialEnd)
+	result, err = renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Renewed: 1, Expired: 1}, result)
+
+	got, err := subscriptions.Get(ctx, converting.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusActive, got.Status)
+	assert.Equal(t, trialEnd, got.CurrentPeriodStart)
+	assert.Equal(t, trialEnd.AddDate(0, 1, 0), got.CurrentPeriodEnd)
+	assert.Equal(t, 10, got.SeatLimit(), "the trial seat limit no longer applies")
+
+	got, err = subscriptions.Get(ctx, lapsing.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusExpired, got.Status)
+}
+
+func TestRenewerAppliesScheduledPlanChanges(t *testing.T) {
+	s := store.NewMemory()
+	ctx := organizationContext(t, s)
+	subscriptions := s.Subscriptions()
+
+	renewing := models.Subscription{Name: "Renewing", ProductID: 101, LicenseCount: 5, AutoRenew: true}
+	assert.NoError(t, subscriptions.Create(ctx, &renewing))
+	ending := models.Subscription{Name: "Ending", ProductID: 101, LicenseCount: 5}
+	assert.NoError(t, subscriptions.Create(ctx, &ending))
+
+	now := renewing.CurrentPeriodStart
+	for _, subscription := range []models.Subscription{renewing, ending} {
+		first := models.PlanChange{SubscriptionID: subscription.ID, FromProductID: 101, FromLicenseCount: 5, ToProductID: 102, ToLicenseCount: 5, Timing: models.ChangeAtPeriodEnd}
+		_, err := subscriptions.ChangePlan(ctx, &first, store.SeatPolicyReject, now)
+		assert.NoError(t, err)
+		second := models.PlanChange{SubscriptionID: subscription.ID, FromProductID: 101, FromLicenseCount: 5, ToProductID: 102, ToLicenseCount: 8, Timing: models.ChangeAtPeriodEnd}
+		_, err = subscriptions.ChangePlan(ctx, &second, store.SeatPolicyReject, now)
+		assert.NoError(t, err)
+		assert.Equal(t, subscription.CurrentPeriodEnd, second.EffectiveAt)
+	}
+
+	got, err := subscriptions.Get(ctx, renewing.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 101, got.ProductID, "scheduled changes wait for the period end")
+
+	renewer := &Renewer{Subscriptions: subscriptions, Clock: clock.NewFake(ending.CurrentPeriodEnd)}
+	_, err = renewer.RunOnce(ctx)
+	assert.NoError(t, err)
+
+	got, err = subscriptions.Get(ctx, renewing.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 102, got.ProductID)
+	assert.Equal(t, 8, got.LicenseCount)
+
+	changes, err := subscriptions.PlanChanges(ctx, renewing.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, models.PlanChangeCanceled, changes[0].Status, "replaced by the second change")
+		assert.Equal(t, models.PlanChangeApplied, changes[1].Status)
+		assert.NotNil(t, changes[1].AppliedAt)
+	}
+
+	got, err = subscriptions.Get(ctx, ending.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusExpired, got.Status)
+	assert.Equal(t, 101, got.ProductID)
+	changes, err = subscriptions.PlanChanges(ctx, ending.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, models.PlanChangeCanceled, changes[1].Status, "expired subscriptions never change plan")
+	}
+}
@@ -0,0 +1,209 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProductSource is implemented by Client and by CachedClient in front of it.
+type ProductSource interface {
+	GetOffers(ctx context.Context) ([]Product, error)
+	GetOfferById(ctx context.Context, id int) (*Product, error)
+	Ping(ctx context.Context) error
+}
+
+// CacheOptions control how long CachedClient keeps answers.
+type CacheOptions struct {
+	// TTL is how long a product is served without asking the products service.
+	TTL time.Duration
+	// NegativeTTL is how long a 404 is remembered.
+	NegativeTTL time.Duration
+	// StaleTTL is how long past TTL a product may still be served while it is
+	// refreshed in the background, or while the products service is down.
+	// Zero disables stale-while-revalidate.
+	StaleTTL time.Duration
+}
+
+// CachedClient caches products in memory in front of a ProductSource.
+// Concurrent misses for the same key share a single upstream call.
+type CachedClient struct {
+	source ProductSource
+	offers *cache[[]Product]
+	offer  *cache[*Product]
+}
+
+// NewCachedClient wraps source with a cache configured by opts.
+func NewCachedClient(source ProductSource, opts CacheOptions) *CachedClient {
+	return &CachedClient{
+		source: source,
+		offers: newCache[[]Product](opts),
+		offer:  newCache[*Product](opts),
+	}
+}
+
+// GetOffers returns a copy of the cached list, so callers may modify it.
+func (c *CachedClient) GetOffers(ctx context.Context) ([]Product, error) {
+	offers, err := c.offers.get(ctx, "all", func(ctx context.Context) ([]Product, error) {
+		offers, err := c.source.GetOffers(ctx)
+		if err == nil {
+			// The list answers single lookups too
+			for _, offer := range offers {
+				c.offer.set(strconv.Itoa(offer.ID), &offer)
+			}
+		}
+		return offers, err
+	})
+	if offers == nil {
+		return nil, err
+	}
+	return append(make([]Product, 0, len(offers)), offers...), err
+}
+
+// GetOfferById returns a copy of the cached product.
+func (c *CachedClient) GetOfferById(ctx context.Context, id int) (*Product, error) {
+	offer, err := c.offer.get(ctx, strconv.Itoa(id), func(ctx context.Context) (*Product, error) {
+		return c.source.GetOfferById(ctx, id)
+	})
+	if offer == nil {
+		return nil, err
+	}
+	copied := *offer
+	return &copied, err
+}
+
+// Ping is never cached.
+func (c *CachedClient) Ping(ctx context.Context) error {
+	return c.source.Ping(ctx)
+}
+
+type cacheEntry[V any] struct {
+	value      V
+	err        error
+	expires    time.Time
+	staleUntil time.Time
+}
+
+// dead reports whether the entry can no longer be served, fresh or stale.
+func (e *cacheEntry[V]) dead(now time.Time) bool {
+	return !now.Before(e.expires) && !now.Before(e.staleUntil)
+}
+
+// cacheCall is an upstream fetch in progress; waiters block on done.
+type cacheCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+type cache[V any] struct {
+	opts CacheOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry[V]
+	inflight map[string]*cacheCall[V]
+	// nextSweep is when put next drops dead entries, so keys that are never
+	// asked for again do not accumulate.
+	nextSweep time.Time
+}
+
+func newCache[V any](opts CacheOptions) *cache[V] {
+	return &cache[V]{
+		opts:     opts,
+		now:      time.Now,
+		entries:  map[string]*cacheEntry[V]{},
+		inflight: map[string]*cacheCall[V]{},
+	}
+}
+
+func (c *cache[V]) get(ctx context.Context, key string, fetch func(context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	now := c.now()
+	if e, ok := c.entries[key]; ok {
+		if now.Before(e.expires) {
+			c.mu.Unlock()
+			return e.value, e.err
+		}
+		if e.err == nil && now.Before(e.staleUntil) {
+			// Serve the stale value and refresh in the background
+			c.start(ctx, key, fetch)
+			c.mu.Unlock()
+			return e.value, nil
+		}
+	}
+	call := c.start(ctx, key, fetch)
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// start returns the in-flight fetch for key, starting one if needed. The
+// fetch outlives the request that triggered it so other waiters and the
+// cache still get its result. Callers must hold c.mu.
+func (c *cache[V]) start(ctx context.Context, key string, fetch func(context.Context) (V, error)) *cacheCall[V] {
+	if call, ok := c.inflight[key]; ok {
+		return call
+	}
+	call := &cacheCall[V]{done: make(chan struct{})}
+	c.inflight[key] = call
+
+	go func() {
+		value, err := fetch(context.WithoutCancel(ctx))
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.inflight, key)
+
+		now := c.now()
+		switch {
+		case err == nil && c.opts.TTL > 0:
+			c.put(now, key, &cacheEntry[V]{value: value, expires: now.Add(c.opts.TTL), staleUntil: now.Add(c.opts.TTL + c.opts.StaleTTL)})
+		case errors.Is(err, ErrNotFound) && c.opts.NegativeTTL > 0:
+			c.put(now, key, &cacheEntry[V]{err: err, expires: now.Add(c.opts.NegativeTTL)})
+		case err != nil:
+			// Keep any previous entry: it may still be served while stale
+			if e, ok := c.entries[key]; ok && e.err == nil && now.Before(e.staleUntil) {
+				value, err = e.value, nil
+			}
+		}
+
+		call.value, call.err = value, err
+		close(call.done)
+	}()
+	return call
+}
+
+// set stores a value fetched by another call.
+func (c *cache[V]) set(key string, value V) {
+	if c.opts.TTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.put(now, key, &cacheEntry[V]{value: value, expires: now.Add(c.opts.TTL), staleUntil: now.Add(c.opts.TTL + c.opts.StaleTTL)})
+}
+
+// put stores e under key. Once per longest entry lifetime it first drops
+// the entries that are dead, which bounds the map to the keys asked for in
+// the last two lifetimes. Callers must hold c.mu.
+func (c *cache[V]) put(now time.Time, key string, e *cacheEntry[V]) {
+	if !now.Before(c.nextSweep) {
+		for k, old := range c.entries {
+			if old.dead(now) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(max(c.opts.TTL+c.opts.StaleTTL, c.opts.NegativeTTL))
+	}
+	c.entries[key] = e
+}
@@ -0,0 +1,226 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubSource is a ProductSource whose answers and latency the test controls.
+type stubSource struct {
+	calls   atomic.Int32
+	delay   time.Duration
+	mu      sync.Mutex
+	err     error
+	version int
+}
+
+func (s *stubSource) answer() (int, error) {
+	s.calls.Add(1)
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version, s.err
+}
+
+func (s *stubSource) setAnswer(version int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version, s.err = version, err
+}
+
+func (s *stubSource) GetOffers(ctx context.Context) ([]Product, error) {
+	version, err := s.answer()
+	if err != nil {
+		return nil, err
+	}
+	return []Product{{ID: 1, Name: fmt.Sprintf("v%d", version)}, {ID: 2, Name: fmt.Sprintf("v%d", version)}}, nil
+}
+
+func (s *stubSource) GetOfferById(ctx context.Context, id int) (*Product, error) {
+	version, err := s.answer()
+	if err != nil {
+		return nil, err
+	}
+	return &Product{ID: id, Name: fmt.Sprintf("v%d", version)}, nil
+}
+
+func (s *stubSource) Ping(ctx context.Context) error { return nil }
+
+// fakeNow lets a test move the cache's clock.
+func fakeNow(c *CachedClient) func(time.Duration) {
+	now := time.Now()
+	var mu sync.Mutex
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	c.offer.now = clock
+	c.offers.now = clock
+	return func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func TestCachedClientTTL(t *testing.T) {
+	source := &stubSource{version: 1}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute})
+	advance := fakeNow(client)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		product, err := client.GetOfferById(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", product.Name)
+	}
+	assert.Equal(t, int32(1), source.calls.Load())
+
+	source.setAnswer(2, nil)
+	advance(2 * time.Minute)
+	product, err := client.GetOfferById(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", product.Name)
+	assert.Equal(t, int32(2), source.calls.Load())
+}
+
+func TestCachedClientNegativeCaching(t *testing.T) {
+	source := &stubSource{err: ErrNotFound}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute, NegativeTTL: 10 * time.Second})
+	advance := fakeNow(client)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetOfferById(ctx, 9)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, int32(1), source.calls.Load())
+
+	advance(11 * time.Second)
+	_, err := client.GetOfferById(ctx, 9)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int32(2), source.calls.Load())
+}
+
+func TestCachedClientEvictsDeadEntries(t *testing.T) {
+	source := &stubSource{err: ErrNotFound}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute, NegativeTTL: 10 * time.Second, StaleTTL: time.Minute})
+	advance := fakeNow(client)
+	ctx := context.Background()
+	entries := func() int {
+		client.offer.mu.Lock()
+		defer client.offer.mu.Unlock()
+		return len(client.offer.entries)
+	}
+
+	for id := 1; id <= 50; id++ {
+		_, err := client.GetOfferById(ctx, id)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, 50, entries())
+
+	// Every 404 is dead two minutes later; the next insert sweeps them
+	advance(2 * time.Minute)
+	source.setAnswer(1, nil)
+	_, err := client.GetOfferById(ctx, 51)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, entries())
+}
+
+func TestCachedClientCoalescesMisses(t *testing.T) {
+	source := &stubSource{version: 1, delay: 50 * time.Millisecond}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			product, err := client.GetOfferById(context.Background(), 1)
+			assert.NoError(t, err)
+			assert.Equal(t, "v1", product.Name)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), source.calls.Load())
+}
+
+func TestCachedClientStaleWhileRevalidate(t *testing.T) {
+	source := &stubSource{version: 1}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute, StaleTTL: 10 * time.Minute})
+	advance := fakeNow(client)
+	ctx := context.Background()
+
+	_, err := client.GetOfferById(ctx, 1)
+	assert.NoError(t, err)
+
+	// The products service goes down: stale answers keep flowing
+	source.setAnswer(0, fmt.Errorf("%w: connection refused", ErrUnavailable))
+	advance(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		product, err := client.GetOfferById(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", product.Name)
+	}
+
+	// It recovers: the background refresh replaces the stale value
+	source.setAnswer(2, nil)
+	client.GetOfferById(ctx, 1)
+	assert.Eventually(t, func() bool {
+		product, err := client.GetOfferById(ctx, 1)
+		return err == nil && product.Name == "v2"
+	}, time.Second, 5*time.Millisecond)
+
+	// Past the stale window an outage surfaces to callers
+	source.setAnswer(0, fmt.Errorf("%w: connection refused", ErrUnavailable))
+	advance(20 * time.Minute)
+	_, err = client.GetOfferById(ctx, 1)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestCachedClientOffersSeedLookups(t *testing.T) {
+	source := &stubSource{version: 1}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute})
+	ctx := context.Background()
+
+	offers, err := client.GetOffers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, offers, 2)
+
+	product, err := client.GetOfferById(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, product.ID)
+	assert.Equal(t, int32(1), source.calls.Load())
+}
+
+func TestCachedClientReturnsCopies(t *testing.T) {
+	source := &stubSource{version: 1}
+	client := NewCachedClient(source, CacheOptions{TTL: time.Minute})
+	ctx := context.Background()
+
+	offers, err := client.GetOffers(ctx)
+	assert.NoError(t, err)
+	want := append([]Product(nil), offers...)
+	offers[0].Price = -1
+	offers[1] = Product{}
+
+	product, err := client.GetOfferById(ctx, want[1].ID)
+	assert.NoError(t, err)
+	product.Name = "Changed"
+
+	again, err := client.GetOffers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, want, again, "callers cannot modify the cached list")
+	product, err = client.GetOfferById(ctx, want[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, want[1], *product, "callers cannot modify a cached product")
+	assert.Equal(t, int32(1), source.calls.Load())
+}
@@ -1,77 +1,200 @@
 package clients
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"subscriptions/models"
+	"time"
 )
 
-// Product represents the structure of the product data.
-type Product struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
+var (
+	// ErrNotFound is returned when the products service has no offer with the requested id.
+	ErrNotFound = errors.New("product not found")
+	// ErrUnavailable is returned when the products service cannot be reached,
+	// keeps failing after retries, or the circuit breaker is open.
+	ErrUnavailable = errors.New("products service unavailable")
+)
+
+// Product represents the structure of the product data. It is defined in
+// models so responses can embed it without models depending on this client.
+type Product = models.Product
+
+// Options tune how the client talks to the products service.
+type Options struct {
+	// HTTPClient is used for every request; when nil a client with Timeout is created.
+	HTTPClient *http.Client
+	Timeout    time.Duration
+	// MaxRetries is how many times a failed GET is retried, with exponential
+	// backoff starting at BaseDelay and capped at MaxDelay, plus jitter.
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// BreakerThreshold consecutive failures open the circuit breaker for
+	// BreakerCooldown. Zero disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultOptions returns the settings NewClient uses.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:          5 * time.Second,
+		MaxRetries:       2,
+		BaseDelay:        100 * time.Millisecond,
+		MaxDelay:         2 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
 }
 
 // Client is a struct that will hold base URL and HTTP client.
 type Client struct {
 	BaseURL string
+
+	httpClient *http.Client
+	opts       Options
+	breaker    *breaker
 }
 
 // NewClient creates a new client to communicate with the products service.
 func NewClient(baseURL string) *Client {
-	return &Client{BaseURL: baseURL}
+	return NewClientWithOptions(baseURL, DefaultOptions())
 }
 
-func (c *Client) GetOffers() ([]Product, error) {
-	url := fmt.Sprintf("%s/offers", c.BaseURL)
+// NewClientWithOptions creates a products client with explicit timeouts,
+// retry and circuit breaker settings.
+func NewClientWithOptions(baseURL string, opts Options) *Client {
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: opts.Timeout}
+	}
+	return &Client{
+		BaseURL:    baseURL,
+		httpClient: httpClient,
+		opts:       opts,
+		breaker:    newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+	}
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Error fetching offers: %v", err)
-		return nil, err
+func (c *Client) GetOffers(ctx context.Context) ([]Product, error) {
+	var offers []Product
+	if err := c.get(ctx, "/offers", &offers); err != nil {
+		return nil, fmt.Errorf("fetching offers: %w", err)
 	}
-	defer resp.Body.Close()
+	return offers, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("Error: Received non-OK response: %s", body)
-		return nil, fmt.Errorf("failed to fetch offers: %v", resp.Status)
+func (c *Client) GetOfferById(ctx context.Context, id int) (*Product, error) {
+	var offer Product
+	if err := c.get(ctx, fmt.Sprintf("/offers/%d", id), &offer); err != nil {
+		return nil, fmt.Errorf("fetching offer %d: %w", id, err)
 	}
+	return &offer, nil
+}
 
-	var offers []Product
-	if err := json.NewDecoder(resp.Body).Decode(&offers); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return nil, err
+// Ping checks that the products service is reachable and not failing. It
+// neither retries nor consults the circuit breaker, so readiness always
+// reports the live state.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.attempt(ctx, "/offers", nil)
+	if errors.Is(err, ErrUnavailable) || ctx.Err() != nil {
+		return err
 	}
+	// Any other answer, even a 4xx, means the service is up
+	return nil
+}
 
-	return offers, nil
+// get performs an idempotent GET of path into out, retrying transient failures.
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	if !c.breaker.allow() {
+		return fmt.Errorf("%w: circuit breaker open", ErrUnavailable)
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		retryable, err = c.attempt(ctx, path, out)
+		if !retryable || attempt >= c.opts.MaxRetries {
+			break
+		}
+		if waitErr := sleep(ctx, c.backoff(attempt)); waitErr != nil {
+			err = waitErr
+			break
+		}
+	}
+
+	if errors.Is(err, context.Canceled) {
+		// A caller giving up says nothing about the upstream
+		c.breaker.release()
+		return err
+	}
+	// A 404 is a healthy answer
+	c.breaker.record(err == nil || errors.Is(err, ErrNotFound))
+	return err
 }
 
-func (c *Client) GetOfferById(id int) (*Product, error) {
-	url := fmt.Sprintf("%s/offers/%d", c.BaseURL, id)
+// attempt makes a single request and reports whether a failure is worth retrying.
+func (c *Client) attempt(ctx context.Context, path string, out interface{}) (bool, error) {
+	url := c.BaseURL + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
 
-	resp, err := http.Get(url)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error fetching offer by ID: %v", err)
-		return nil, err
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return true, fmt.Errorf("%w: %v", ErrUnavailable, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("Error: Received non-OK response: %s", body)
-		return nil, fmt.Errorf("failed to fetch offer by id: %v", resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, ErrNotFound
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return true, fmt.Errorf("%w: GET %s returned %s", ErrUnavailable, url, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("GET %s returned %s: %s", url, resp.Status, body)
 	}
 
-	var offer Product
-	if err := json.NewDecoder(resp.Body).Decode(&offer); err != nil {
-		log.Printf("Error decoding response: %v", err)
-		return nil, err
+	if out == nil {
+		return false, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("decoding response from %s: %w", url, err)
 	}
+	return false, nil
+}
 
-	return &offer, nil
+// backoff returns the delay before retry number attempt+1: exponential in
+// attempt, capped at MaxDelay, with the upper half randomized.
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.opts.BaseDelay << attempt
+	if delay > c.opts.MaxDelay || delay <= 0 {
+		delay = c.opts.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
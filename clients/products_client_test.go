@@ -0,0 +1,160 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testOptions() Options {
+	return Options{
+		Timeout:          time.Second,
+		MaxRetries:       2,
+		BaseDelay:        time.Millisecond,
+		MaxDelay:         5 * time.Millisecond,
+		BreakerThreshold: 0,
+	}
+}
+
+// countingServer answers each request with the next status in statuses,
+// repeating the last one, and counts the requests it receives.
+func countingServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprint(w, `{"id": 1, "name": "Editor", "price": 9.5}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestGetOfferById(t *testing.T) {
+	testCases := []struct {
+		name          string
+		statuses      []int
+		expectedErr   error
+		expectedCalls int32
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, expectedCalls: 1},
+		{name: "retries transient failures", statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, expectedCalls: 3},
+		{name: "not found is not retried", statuses: []int{http.StatusNotFound}, expectedErr: ErrNotFound, expectedCalls: 1},
+		{name: "gives up after max retries", statuses: []int{http.StatusInternalServerError}, expectedErr: ErrUnavailable, expectedCalls: 3},
+		{name: "client errors are not retried", statuses: []int{http.StatusBadRequest}, expectedCalls: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := countingServer(t, tc.statuses...)
+			client := NewClientWithOptions(server.URL, testOptions())
+
+			product, err := client.GetOfferById(context.Background(), 1)
+			assert.Equal(t, tc.expectedCalls, calls.Load())
+
+			switch {
+			case tc.expectedErr != nil:
+				assert.ErrorIs(t, err, tc.expectedErr)
+			case tc.statuses[len(tc.statuses)-1] == http.StatusOK:
+				assert.NoError(t, err)
+				assert.Equal(t, "Editor", product.Name)
+			default:
+				assert.Error(t, err)
+				assert.False(t, errors.Is(err, ErrUnavailable) || errors.Is(err, ErrNotFound))
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server, calls := countingServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+
+	opts := testOptions()
+	opts.MaxRetries = 0
+	opts.BreakerThreshold = 2
+	opts.BreakerCooldown = time.Minute
+	client := NewClientWithOptions(server.URL, opts)
+
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetOfferById(context.Background(), 1)
+		assert.ErrorIs(t, err, ErrUnavailable)
+	}
+
+	// Open: calls fail fast without reaching the server
+	_, err := client.GetOfferById(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// After the cooldown one trial call goes through and closes the breaker
+	now = now.Add(time.Minute)
+	_, err = client.GetOfferById(context.Background(), 1)
+	assert.NoError(t, err)
+	_, err = client.GetOfferById(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestCircuitBreakerCanceledTrialIsNeutral(t *testing.T) {
+	server, calls := countingServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK)
+
+	opts := testOptions()
+	opts.MaxRetries = 0
+	opts.BreakerThreshold = 2
+	opts.BreakerCooldown = time.Minute
+	client := NewClientWithOptions(server.URL, opts)
+
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetOfferById(context.Background(), 1)
+		assert.ErrorIs(t, err, ErrUnavailable)
+	}
+
+	// The trial's caller gives up before the request is sent
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.GetOfferById(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, client.breaker.failures, "the canceled trial neither closes nor reopens the breaker")
+
+	// The slot is back, so the next caller probes the upstream
+	_, err = client.GetOfferById(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, 0, client.breaker.failures)
+}
+
+func TestGetOffersHonoursContext(t *testing.T) {
+	server, _ := countingServer(t, http.StatusServiceUnavailable)
+
+	opts := testOptions()
+	opts.MaxRetries = 10
+	opts.BaseDelay = time.Second
+	opts.MaxDelay = time.Second
+	client := NewClientWithOptions(server.URL, opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetOffers(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,427 @@
+// Package config loads the service configuration from defaults, an optional
+// YAML or JSON file, environment variables and command-line flags.
+//
+// Later sources override earlier ones: defaults < file < environment < flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective configuration of the service.
+type Config struct {
+	DatabaseURL        string
+	ProductsServiceURL string
+	// ProductsAllowUnavailable accepts subscription writes whose product_id
+	// cannot be verified because the products service is unavailable.
+	ProductsAllowUnavailable bool
+	Products                 Products
+	Server                   Server
+	Renewal                  Renewal
+	Entitlements             Entitlements
+	Tokens                   Tokens
+	Auth                     Auth
+	Idempotency              Idempotency
+}
+
+// Products holds the products service client settings.
+type Products struct {
+	Timeout          time.Duration
+	MaxRetries       int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	CacheTTL         time.Duration
+	CacheNegativeTTL time.Duration
+	CacheStaleTTL    time.Duration
+}
+
+// Renewal holds the billing period renewal worker settings.
+type Renewal struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Entitlements holds the entitlement check settings.
+type Entitlements struct {
+	CacheTTL time.Duration
+}
+
+// Tokens holds the entitlement token settings.
+type Tokens struct {
+	// SigningKeys are kid=seed pairs, the key signing new tokens first
+	SigningKeys string
+	TTL         time.Duration
+	Issuer      string
+}
+
+// Auth holds the settings bearer JWTs are verified with. API keys need none.
+type Auth struct {
+	JWTIssuer   string
+	JWTAudience string
+	JWKSURL     string
+	// JWTKeys are kid=path pairs of PEM public keys, instead of JWKSURL
+	JWTKeys string
+}
+
+// Idempotency holds the Idempotency-Key settings.
+type Idempotency struct {
+	// TTL is how long a key is remembered and its response replayed
+	TTL time.Duration
+}
+
+// Server holds the HTTP server settings.
+type Server struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	DrainDelay        time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+// Default returns the configuration used when no source sets a value.
+func Default() Config {
+	return Config{
+		Products: Products{
+			Timeout:          5 * time.Second,
+			MaxRetries:       2,
+			RetryBaseDelay:   100 * time.Millisecond,
+			RetryMaxDelay:    2 * time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+			CacheTTL:         5 * time.Minute,
+			CacheNegativeTTL: 30 * time.Second,
+			CacheStaleTTL:    15 * time.Minute,
+		},
+		Server: Server{
+			Addr:              ":8002",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Renewal: Renewal{
+			Interval:  time.Minute,
+			BatchSize: 100,
+		},
+		Entitlements: Entitlements{
+			CacheTTL: 5 * time.Second,
+		},
+		Tokens: Tokens{
+			TTL:    5 * time.Minute,
+			Issuer: "subscriptions",
+		},
+		Idempotency: Idempotency{
+			TTL: 24 * time.Hour,
+		},
+	}
+}
+
+// setting describes one configuration value and every name it can be set by.
+// key is the dotted path used in config files; the flag name is derived from it.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	ptr    interface{}
+}
+
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (s setting) set(value string) error {
+	switch p := s.ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.key, value)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", s.key, value)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.key, value)
+		}
+		*p = d
+	default:
+		panic(fmt.Sprintf("config: unsupported type for %s", s.key))
+	}
+	return nil
+}
+
+func (s setting) String() string {
+	switch p := s.ptr.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	}
+	return ""
+}
+
+// settings lists every configurable value of c. Adding a configuration
+// option means adding a field to Config and a line here.
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "database_url", env: "DATABASE_URL", usage: "PostgreSQL connection string", secret: true, ptr: &c.DatabaseURL},
+		{key: "products_service_url", env: "PRODUCTS_SERVICE_URL", usage: "base URL of the products service (optional)", ptr: &c.ProductsServiceURL},
+		{key: "products_allow_unavailable", env: "PRODUCTS_ALLOW_UNAVAILABLE", usage: "accept subscription writes when the products service is unavailable", ptr: &c.ProductsAllowUnavailable},
+		{key: "products.timeout", env: "PRODUCTS_TIMEOUT", usage: "timeout of a single products service request", ptr: &c.Products.Timeout},
+		{key: "products.max_retries", env: "PRODUCTS_MAX_RETRIES", usage: "retries of a failed products service GET", ptr: &c.Products.MaxRetries},
+		{key: "products.retry_base_delay", env: "PRODUCTS_RETRY_BASE_DELAY", usage: "initial retry backoff", ptr: &c.Products.RetryBaseDelay},
+		{key: "products.retry_max_delay", env: "PRODUCTS_RETRY_MAX_DELAY", usage: "maximum retry backoff", ptr: &c.Products.RetryMaxDelay},
+		{key: "products.breaker_threshold", env: "PRODUCTS_BREAKER_THRESHOLD", usage: "consecutive failures that open the circuit breaker (0 disables it)", ptr: &c.Products.BreakerThreshold},
+		{key: "products.breaker_cooldown", env: "PRODUCTS_BREAKER_COOLDOWN", usage: "how long the circuit breaker stays open", ptr: &c.Products.BreakerCooldown},
+		{key: "products.cache_ttl", env: "PRODUCTS_CACHE_TTL", usage: "how long products are cached (0 disables the cache)", ptr: &c.Products.CacheTTL},
+		{key: "products.cache_negative_ttl", env: "PRODUCTS_CACHE_NEGATIVE_TTL", usage: "how long unknown products are cached", ptr: &c.Products.CacheNegativeTTL},
+		{key: "products.cache_stale_ttl", env: "PRODUCTS_CACHE_STALE_TTL", usage: "how long past cache_ttl stale products may be served", ptr: &c.Products.CacheStaleTTL},
+		{key: "server.addr", env: "LISTEN_ADDR", usage: "HTTP listen address", ptr: &c.Server.Addr},
+		{key: "server.read_timeout", env: "HTTP_READ_TIMEOUT", usage: "maximum duration for reading a request", ptr: &c.Server.ReadTimeout},
+		{key: "server.read_header_timeout", env: "HTTP_READ_HEADER_TIMEOUT", usage: "maximum duration for reading request headers", ptr: &c.Server.ReadHeaderTimeout},
+		{key: "server.write_timeout", env: "HTTP_WRITE_TIMEOUT", usage: "maximum duration for writing a response", ptr: &c.Server.WriteTimeout},
+		{key: "server.idle_timeout", env: "HTTP_IDLE_TIMEOUT", usage: "keep-alive idle timeout", ptr: &c.Server.IdleTimeout},
+		{key: "server.max_header_bytes", env: "HTTP_MAX_HEADER_BYTES", usage: "maximum size of request headers", ptr: &c.Server.MaxHeaderBytes},
+		{key: "server.drain_delay", env: "HTTP_DRAIN_DELAY", usage: "time readiness reports draining before the listener closes", ptr: &c.Server.DrainDelay},
+		{key: "server.shutdown_timeout", env: "HTTP_SHUTDOWN_TIMEOUT", usage: "time allowed for in-flight requests on shutdown", ptr: &c.Server.ShutdownTimeout},
+		{key: "renewal.interval", env: "RENEWAL_INTERVAL", usage: "how often ended billing periods are renewed or expired (0 disables the worker)", ptr: &c.Renewal.Interval},
+		{key: "renewal.batch_size", env: "RENEWAL_BATCH_SIZE", usage: "due subscriptions loaded per renewal query", ptr: &c.Renewal.BatchSize},
+		{key: "entitlements.cache_ttl", env: "ENTITLEMENTS_CACHE_TTL", usage: "how long entitlement answers are cached (0 disables the cache)", ptr: &c.Entitlements.CacheTTL},
+		{key: "tokens.signing_keys", env: "ENTITLEMENT_SIGNING_KEYS", usage: "entitlement token Ed25519 keys as kid=base64url-seed pairs, the signing key first", secret: true, ptr: &c.Tokens.SigningKeys},
+		{key: "tokens.ttl", env: "ENTITLEMENT_TOKEN_TTL", usage: "how long entitlement tokens are valid", ptr: &c.Tokens.TTL},
+		{key: "tokens.issuer", env: "ENTITLEMENT_TOKEN_ISSUER", usage: "iss claim of entitlement tokens", ptr: &c.Tokens.Issuer},
+		{key: "auth.jwt_issuer", env: "AUTH_JWT_ISSUER", usage: "iss claim bearer JWTs must carry", ptr: &c.Auth.JWTIssuer},
+		{key: "auth.jwt_audience", env: "AUTH_JWT_AUDIENCE", usage: "aud claim bearer JWTs must include; empty accepts any", ptr: &c.Auth.JWTAudience},
+		{key: "auth.jwks_url", env: "AUTH_JWKS_URL", usage: "JWKS URL of the identity provider bearer JWTs are verified with", ptr: &c.Auth.JWKSURL},
+		{key: "auth.jwt_keys", env: "AUTH_JWT_KEYS", usage: "static bearer JWT keys as kid=path-to-PEM pairs, instead of auth.jwks_url", ptr: &c.Auth.JWTKeys},
+		{key: "idempotency.ttl", env: "IDEMPOTENCY_KEY_TTL", usage: "how long Idempotency-Key responses are replayed", ptr: &c.Idempotency.TTL},
+	}
+}
+
+// flagValue records a flag's raw value and whether it was given at all.
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string   { return f.value }
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	f.set = true
+	return nil
+}
+
+// Load builds the configuration from the process environment and args
+// (typically os.Args[1:]). It returns the arguments left after the flags,
+// such as a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	// Flags are captured as raw strings first so they can be applied last
+	fs := flag.NewFlagSet("subscriptions", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML or JSON config file (env CONFIG_FILE)")
+	flagValues := map[string]*flagValue{}
+	for _, s := range settings {
+		_, isBool := s.ptr.(*bool)
+		flagValues[s.key] = &flagValue{isBool: isBool}
+		fs.Var(flagValues[s.key], s.flagName(), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		known := map[string]bool{}
+		for _, s := range settings {
+			known[s.key] = true
+			if value, ok := values[s.key]; ok {
+				if err := s.set(value); err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", path, err)
+				}
+			}
+		}
+		for key := range values {
+			if !known[key] {
+				return nil, nil, fmt.Errorf("%s: unknown setting %q", path, key)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if f := flagValues[s.key]; f.set {
+			if err := s.set(f.value); err != nil {
+				return nil, nil, fmt.Errorf("flag -%s: %w", s.flagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return &cfg, fs.Args(), nil
+}
+
+// readFile parses a YAML or JSON file (JSON is valid YAML) into dotted keys.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := map[string]string{}
+	flatten("", tree, values)
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(key, nested, values)
+			continue
+		}
+		values[key] = fmt.Sprint(value)
+	}
+}
+
+// Validate checks that required values are present and the rest are sane.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("database_url is required (env DATABASE_URL)"))
+	}
+	if c.ProductsServiceURL != "" {
+		u, err := url.Parse(c.ProductsServiceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("products_service_url must be an http(s) URL, got %q", c.ProductsServiceURL))
+		}
+	}
+	if c.Products.MaxRetries < 0 {
+		errs = append(errs, errors.New("products.max_retries must not be negative"))
+	}
+	if c.Products.BreakerThreshold < 0 {
+		errs = append(errs, errors.New("products.breaker_threshold must not be negative"))
+	}
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("server.max_header_bytes must be positive"))
+	}
+	if c.Renewal.BatchSize <= 0 {
+		errs = append(errs, errors.New("renewal.batch_size must be positive"))
+	}
+	if c.Tokens.TTL <= 0 {
+		errs = append(errs, errors.New("tokens.ttl must be positive"))
+	}
+	if c.Idempotency.TTL <= 0 {
+		errs = append(errs, errors.New("idempotency.ttl must be positive"))
+	}
+	if c.Auth.JWKSURL != "" {
+		u, err := url.Parse(c.Auth.JWKSURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("auth.jwks_url must be an http(s) URL, got %q", c.Auth.JWKSURL))
+		}
+		if c.Auth.JWTKeys != "" {
+			errs = append(errs, errors.New("auth.jwks_url and auth.jwt_keys are mutually exclusive"))
+		}
+	}
+	if (c.Auth.JWKSURL != "" || c.Auth.JWTKeys != "") && c.Auth.JWTIssuer == "" {
+		errs = append(errs, errors.New("auth.jwt_issuer is required when bearer JWTs are accepted"))
+	}
+	for _, s := range c.settings() {
+		if d, ok := s.ptr.(*time.Duration); ok && *d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", s.key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)(\S+)`)
+
+// redact hides credentials in URL or key=value connection strings.
+func redact(value string) string {
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	if dsnPassword.MatchString(value) {
+		return dsnPassword.ReplaceAllString(value, "${1}xxxxx")
+	}
+	if value != "" {
+		return "xxxxx"
+	}
+	return value
+}
+
+// Print writes the effective configuration, one key per line, with secrets redacted.
+func (c *Config) Print(w io.Writer) {
+	settings := c.settings()
+	sort.Slice(settings, func(i, j int) bool { return settings[i].key < settings[j].key })
+	for _, s := range settings {
+		value := s.String()
+		if s.secret {
+			value = redact(value)
+		}
+		fmt.Fprintf(w, "%s=%s\n", s.key, value)
+	}
+}
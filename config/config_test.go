@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func envFrom(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+database_url: postgres://file@db/subscriptions
+server:
+  addr: ":9000"
+  read_timeout: 3s
+  idle_timeout: 10s
+`)
+
+	env := envFrom(map[string]string{
+		"CONFIG_FILE":       file,
+		"LISTEN_ADDR":       ":9100",
+		"HTTP_READ_TIMEOUT": "4s",
+	})
+
+	cfg, args, err := load([]string{"-server-read-timeout=5s", "-products-allow-unavailable", "migrate", "up"}, env)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+
+	assert.Equal(t, "postgres://file@db/subscriptions", cfg.DatabaseURL, "file overrides defaults")
+	assert.Equal(t, ":9100", cfg.Server.Addr, "env overrides file")
+	assert.Equal(t, 5*time.Second, cfg.Server.ReadTimeout, "flags override env")
+	assert.Equal(t, 10*time.Second, cfg.Server.IdleTimeout)
+	assert.True(t, cfg.ProductsAllowUnavailable)
+	assert.Equal(t, Default().Server.WriteTimeout, cfg.Server.WriteTimeout, "unset values keep defaults")
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	file := writeFile(t, "config.json", `{"database_url": "host=db", "server": {"max_header_bytes": 4096}}`)
+
+	cfg, _, err := load([]string{"-config", file}, envFrom(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db", cfg.DatabaseURL)
+	assert.Equal(t, 4096, cfg.Server.MaxHeaderBytes)
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+	}{
+		{name: "missing database url", env: map[string]string{}},
+		{name: "invalid duration", env: map[string]string{"DATABASE_URL": "host=db", "HTTP_IDLE_TIMEOUT": "soon"}},
+		{name: "invalid products url", args: []string{"-products-service-url", "products:8001"}, env: map[string]string{"DATABASE_URL": "host=db"}},
+		{name: "unknown file key", env: map[string]string{"DATABASE_URL": "host=db"}, file: "port: 8002\n"},
+		{name: "jwt keys without issuer", env: map[string]string{"DATABASE_URL": "host=db", "AUTH_JWKS_URL": "https://idp.example.com/jwks"}},
+		{name: "jwks url and static jwt keys", env: map[string]string{"DATABASE_URL": "host=db", "AUTH_JWT_ISSUER": "idp", "AUTH_JWKS_URL": "https://idp.example.com/jwks", "AUTH_JWT_KEYS": "k1=/etc/k1.pem"}},
+		{name: "zero idempotency ttl", env: map[string]string{"DATABASE_URL": "host=db", "IDEMPOTENCY_KEY_TTL": "0s"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append([]string{"-config", writeFile(t, "config.yaml", tc.file)}, args...)
+			}
+			_, _, err := load(args, envFrom(tc.env))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	testCases := []struct {
+		databaseURL string
+		expected    string
+	}{
+		{databaseURL: "postgres://app:s3cret@db:5432/subscriptions", expected: "database_url=postgres://app:xxxxx@db:5432/subscriptions"},
+		{databaseURL: "host=db user=app password=s3cret dbname=subscriptions", expected: "database_url=host=db user=app password=xxxxx dbname=subscriptions"},
+	}
+
+	for _, tc := range testCases {
+		cfg := Default()
+		cfg.DatabaseURL = tc.databaseURL
+
+		var out strings.Builder
+		cfg.Print(&out)
+		assert.Contains(t, out.String(), tc.expected)
+		assert.NotContains(t, out.String(), "s3cret")
+		assert.Contains(t, out.String(), "server.addr=:8002")
+	}
+}
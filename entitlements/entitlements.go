@@ -0,0 +1,192 @@
+// Package entitlements answers whether users may use products right now,
+// from the seats they hold on subscriptions.
+package entitlements
+
+import (
+	"context"
+	"sort"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is how long a Checker remembers an answer by default.
+	DefaultCacheTTL = 5 * time.Second
+	// MaxBatchSize is how many checks one CheckMany call may ask for.
+	MaxBatchSize = 100
+	// maxCacheEntries bounds the memory the cache may use.
+	maxCacheEntries = 100000
+)
+
+// Check asks whether a user may use a product.
+type Check struct {
+	UserID    int `json:"user_id"`
+	ProductID int `json:"product_id"`
+}
+
+// cacheKey is a check as asked under an organization scope, which decides
+// the subscriptions the answer may come from.
+type cacheKey struct {
+	Check
+	organizationID int
+}
+
+type cacheEntry struct {
+	entitlement models.Entitlement
+	expires     time.Time
+}
+
+// Checker answers entitlement checks from the subscriptions store with a
+// short-lived in-memory cache in front, so answers may be up to the cache
+// TTL old. It is safe for concurrent use.
+type Checker struct {
+	subscriptions store.SubscriptionStore
+	clock         clock.Clock
+	ttl           time.Duration
+
+	mu    sync.Mutex
+	cache map[cacheKey]cacheEntry
+}
+
+// NewChecker creates a Checker caching answers for ttl; 0 disables the cache.
+func NewChecker(subscriptions store.SubscriptionStore, ttl time.Duration) *Checker {
+	return &Checker{subscriptions: subscriptions, clock: clock.Real{}, ttl: ttl, cache: map[cacheKey]cacheEntry{}}
+}
+
+// Check answers whether userID may use productID now.
+func (c *Checker) Check(ctx context.Context, userID, productID int) (models.Entitlement, error) {
+	results, err := c.CheckMany(ctx, []Check{{UserID: userID, ProductID: productID}})
+	if err != nil {
+		return models.Entitlement{}, err
+	}
+	return results[0], nil
+}
+
+// CheckMany answers checks in order. Checks that are not cached are looked
+// up with one query per user.
+func (c *Checker) CheckMany(ctx context.Context, checks []Check) ([]models.Entitlement, error) {
+	now := c.clock.Now()
+	organizationID := store.OrganizationFrom(ctx)
+	results := make([]models.Entitlement, len(checks))
+
+	missing := map[int][]int{}
+	c.mu.Lock()
+	for i, check := range checks {
+		if entry, ok := c.cache[cacheKey{check, organizationID}]; ok && now.Before(entry.expires) {
+			results[i] = entry.entitlement
+			continue
+		}
+		missing[check.UserID] = append(missing[check.UserID], i)
+	}
+	c.mu.Unlock()
+
+	for userID, indexes := range missing {
+		productIDs := make([]int, 0, len(indexes))
+		seen := map[int]bool{}
+		for _, i := range indexes {
+			if !seen[checks[i].ProductID] {
+				seen[checks[i].ProductID] = true
+				productIDs = append(productIDs, checks[i].ProductID)
+			}
+		}
+
+		subscriptions, err := c.subscriptions.ListForUser(ctx, userID, productIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range indexes {
+			results[i] = Evaluate(checks[i], subscriptions, now)
+			c.remember(cacheKey{checks[i], organizationID}, results[i], now)
+		}
+	}
+	return results, nil
+}
+
+// ForUser answers for every product userID holds a seat on, in product id
+// order, with a single query. The answers are cached like those of CheckMany.
+func (c *Checker) ForUser(ctx context.Context, userID int) ([]models.Entitlement, error) {
+	now := c.clock.Now()
+	organizationID := store.OrganizationFrom(ctx)
+	subscriptions, err := c.subscriptions.ListForUser(ctx, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := []int{}
+	seen := map[int]bool{}
+	for _, subscription := range subscriptions {
+		if !seen[subscription.ProductID] {
+			seen[subscription.ProductID] = true
+			productIDs = append(productIDs, subscription.ProductID)
+		}
+	}
+	sort.Ints(productIDs)
+
+	results := make([]models.Entitlement, len(productIDs))
+	for i, productID := range productIDs {
+		check := Check{UserID: userID, ProductID: productID}
+		results[i] = Evaluate(check, subscriptions, now)
+		c.remember(cacheKey{check, organizationID}, results[i], now)
+	}
+	return results, nil
+}
+
+// remember caches entitlement for the TTL, but never past the end of the
+// period that grants it.
+func (c *Checker) remember(key cacheKey, entitlement models.Entitlement, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	expires := now.Add(c.ttl)
+	if entitlement.ExpiresAt != nil && entitlement.ExpiresAt.Before(expires) {
+		expires = *entitlement.ExpiresAt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cache) >= maxCacheEntries {
+		for key, entry := range c.cache {
+			if !now.Before(entry.expires) {
+				delete(c.cache, key)
+			}
+		}
+		if len(c.cache) >= maxCacheEntries {
+			c.cache = map[cacheKey]cacheEntry{}
+		}
+	}
+	c.cache[key] = cacheEntry{entitlement: entitlement, expires: expires}
+}
+
+// Evaluate decides check from the subscriptions the user holds seats on.
+// Any granting subscription allows access, the one with the latest period
+// end being reported. Otherwise the denial is explained by the subscription
+// whose period ends last, or by the user holding no seat at all.
+func Evaluate(check Check, subscriptions []models.Subscription, now time.Time) models.Entitlement {
+	entitlement := models.Entitlement{UserID: check.UserID, ProductID: check.ProductID, Reason: models.DenyNoSeat}
+	var deniedUntil time.Time
+	for _, subscription := range subscriptions {
+		if subscription.ProductID != check.ProductID {
+			continue
+		}
+
+		allowed, reason := subscription.Entitles(now)
+		switch {
+		case allowed:
+			if !entitlement.Allowed || subscription.CurrentPeriodEnd.After(*entitlement.ExpiresAt) {
+				periodEnd := subscription.CurrentPeriodEnd
+				entitlement.Allowed = true
+				entitlement.Reason = ""
+				entitlement.SubscriptionID = subscription.ID
+				entitlement.ExpiresAt = &periodEnd
+			}
+		case !entitlement.Allowed && (entitlement.SubscriptionID == 0 || subscription.CurrentPeriodEnd.After(deniedUntil)):
+			entitlement.Reason = reason
+			entitlement.SubscriptionID = subscription.ID
+			deniedUntil = subscription.CurrentPeriodEnd
+		}
+	}
+	return entitlement
+}
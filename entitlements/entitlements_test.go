@@ -0,0 +1,183 @@
+package entitlements
+
+import (
+	"context"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the ListForUser queries that reach the store.
+type countingStore struct {
+	store.SubscriptionStore
+	calls int
+}
+
+func (s *countingStore) ListForUser(ctx context.Context, userID int, productIDs []int) ([]models.Subscription, error) {
+	s.calls++
+	return s.SubscriptionStore.ListForUser(ctx, userID, productIDs)
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	periodEnd := now.AddDate(0, 0, 10)
+	subscription := func(id int, status models.SubscriptionStatus, end time.Time) models.Subscription {
+		return models.Subscription{ID: id, ProductID: 101, Status: status, CurrentPeriodEnd: end}
+	}
+
+	testCases := []struct {
+		name           string
+		subscriptions  []models.Subscription
+		allowed        bool
+		reason         models.DenialReason
+		subscriptionID int
+	}{
+		{name: "no seat", reason: models.DenyNoSeat},
+		{name: "seat on another product", subscriptions: []models.Subscription{{ID: 1, ProductID: 102, Status: models.StatusActive, CurrentPeriodEnd: periodEnd}}, reason: models.DenyNoSeat},
+		{name: "active", subscriptions: []models.Subscription{subscription(1, models.StatusActive, periodEnd)}, allowed: true, subscriptionID: 1},
+		{name: "trialing", subscriptions: []models.Subscription{subscription(1, models.StatusTrialing, periodEnd)}, allowed: true, subscriptionID: 1},
+		{name: "past due keeps access", subscriptions: []models.Subscription{subscription(1, models.StatusPastDue, periodEnd)}, allowed: true, subscriptionID: 1},
+		{name: "paused", subscriptions: []models.Subscription{subscription(1, models.StatusPaused, periodEnd)}, reason: models.DenySubscriptionPaused, subscriptionID: 1},
+		{name: "canceled", subscriptions: []models.Subscription{subscription(1, models.StatusCanceled, periodEnd)}, reason: models.DenySubscriptionCanceled, subscriptionID: 1},
+		{name: "expired", subscriptions: []models.Subscription{subscription(1, models.StatusExpired, periodEnd)}, reason: models.DenySubscriptionExpired, subscriptionID: 1},
+		{name: "period ended before renewal", subscriptions: []models.Subscription{subscription(1, models.StatusActive, now)}, reason: models.DenyPeriodEnded, subscriptionID: 1},
+		{
+			name:           "any granting subscription wins",
+			subscriptions:  []models.Subscription{subscription(1, models.StatusCanceled, periodEnd), subscription(2, models.StatusActive, periodEnd)},
+			allowed:        true,
+			subscriptionID: 2,
+		},
+		{
+			name:           "latest denial explains",
+			subscriptions:  []models.Subscription{subscription(1, models.StatusExpired, now.AddDate(0, -1, 0)), subscription(2, models.StatusPaused, periodEnd)},
+			reason:         models.DenySubscriptionPaused,
+			subscriptionID: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entitlement := Evaluate(Check{UserID: 7, ProductID: 101}, tc.subscriptions, now)
+			assert.Equal(t, tc.allowed, entitlement.Allowed)
+			assert.Equal(t, tc.reason, entitlement.Reason)
+			assert.Equal(t, tc.subscriptionID, entitlement.SubscriptionID)
+			if tc.allowed {
+				assert.Equal(t, periodEnd, *entitlement.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestCheckerCaches(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+
+	counting := &countingStore{SubscriptionStore: s.Subscriptions()}
+	checker := NewChecker(counting, time.Minute)
+	fake := clock.NewFake(time.Now())
+	checker.clock = fake
+
+	results, err := checker.CheckMany(ctx, []Check{{UserID: 7, ProductID: 101}, {UserID: 7, ProductID: 102}, {UserID: 8, ProductID: 101}})
+	assert.NoError(t, err)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, models.DenyNoSeat, results[1].Reason)
+	assert.Equal(t, models.DenyNoSeat, results[2].Reason)
+	assert.Equal(t, 2, counting.calls, "one query per user")
+
+	_, err = s.Subscriptions().Transition(ctx, subscription.ID, models.EventPause, time.Now())
+	assert.NoError(t, err)
+
+	entitlement, err := checker.Check(ctx, 7, 101)
+	assert.NoError(t, err)
+	assert.True(t, entitlement.Allowed, "served from the cache")
+	assert.Equal(t, 2, counting.calls)
+
+	fake.Advance(time.Minute)
+	entitlement, err = checker.Check(ctx, 7, 101)
+	assert.NoError(t, err)
+	assert.False(t, entitlement.Allowed)
+	assert.Equal(t, models.DenySubscriptionPaused, entitlement.Reason)
+	assert.Equal(t, 3, counting.calls)
+}
+
+func TestCheckerCacheEndsWithPeriod(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+
+	checker := NewChecker(s.Subscriptions(), 24*time.Hour)
+	fake := clock.NewFake(subscription.CurrentPeriodEnd.Add(-time.Hour))
+	checker.clock = fake
+
+	entitlement, err := checker.Check(ctx, 7, 101)
+	assert.NoError(t, err)
+	assert.True(t, entitlement.Allowed)
+
+	fake.Set(subscription.CurrentPeriodEnd)
+	entitlement, err = checker.Check(ctx, 7, 101)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DenyPeriodEnded, entitlement.Reason, "access is not cached past the period end")
+}
+
+func TestCheckerCachePerOrganization(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 5, OrganizationID: 1}
+	assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+	assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+	checker := NewChecker(s.Subscriptions(), time.Minute)
+
+	entitlement, err := checker.Check(store.WithOrganization(ctx, 1), 7, 101)
+	assert.NoError(t, err)
+	assert.True(t, entitlement.Allowed)
+
+	entitlement, err = checker.Check(store.WithOrganization(ctx, 2), 7, 101)
+	assert.NoError(t, err)
+	assert.False(t, entitlement.Allowed, "answers cached for one organization are not served to another")
+	assert.Equal(t, models.DenyNoSeat, entitlement.Reason)
+}
+
+func TestCheckerForUser(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	for _, productID := range []int{102, 101, 102} {
+		subscription := models.Subscription{Name: "Team", ProductID: productID, LicenseCount: 5, OrganizationID: 1}
+		assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+	}
+	_, err := s.Subscriptions().Transition(ctx, 2, models.EventPause, time.Now())
+	assert.NoError(t, err)
+
+	counting := &countingStore{SubscriptionStore: s.Subscriptions()}
+	checker := NewChecker(counting, time.Minute)
+
+	results, err := checker.ForUser(ctx, 7)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2, "one answer per product") {
+		assert.Equal(t, 101, results[0].ProductID)
+		assert.Equal(t, models.DenySubscriptionPaused, results[0].Reason)
+		assert.Equal(t, 102, results[1].ProductID)
+		assert.True(t, results[1].Allowed)
+	}
+
+	_, err = checker.Check(ctx, 7, 102)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, counting.calls, "the answers were cached")
+
+	results, err = checker.ForUser(ctx, 8)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
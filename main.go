@@ -1,46 +1,207 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"subscriptions/app"
+	"subscriptions/auth"
+	"subscriptions/clients"
+	"subscriptions/config"
+	"subscriptions/migrations"
+	"subscriptions/models"
+	"subscriptions/store"
+	"syscall"
+
 	_ "github.com/lib/pq"
 )
 
 func main() {
+	// run returns instead of exiting so deferred cleanup such as db.Close runs
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	var effective strings.Builder
+	cfg.Print(&effective)
+	log.Printf("Effective configuration:\n%s", effective.String())
+
 	// Open connection to the PostgreSQL database
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer db.Close()
 
-	// Create the subscriptions and user_subscriptions tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS subscriptions (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR NOT NULL,
-			product_id INT NOT NULL,
-			license_count INT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			deleted_at TIMESTAMP 
-		);
-
-		CREATE TABLE IF NOT EXISTS user_subscriptions (
-			id SERIAL PRIMARY KEY,
-			user_id INT NOT NULL,
-			subscription_id INT NOT NULL REFERENCES subscriptions(id),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			deleted_at TIMESTAMP 
-		);
-	`)
+	migrator, err := migrations.New(db)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	// `main [flags] migrate up|down [steps]|status` manages the schema and exits
+	if len(args) > 0 && args[0] == "migrate" {
+		return runMigrate(migrator, args[1:])
+	}
+	// `main [flags] api-key create <name> <role,...> [organization_id]`
+	// bootstraps the first API key, which every other route needs
+	if len(args) > 0 && args[0] == "api-key" {
+		return runAPIKey(store.NewPostgres(db), args[1:])
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Bring the schema up to date before serving requests
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %d_%s", m.Version, m.Name)
 	}
 
-	// Initialize routes and start the server
-	app.InitializeRoute(db)
+	// The products service is optional; readiness only probes it when configured
+	var products clients.ProductSource
+	if cfg.ProductsServiceURL != "" {
+		products = clients.NewClientWithOptions(cfg.ProductsServiceURL, clients.Options{
+			Timeout:          cfg.Products.Timeout,
+			MaxRetries:       cfg.Products.MaxRetries,
+			BaseDelay:        cfg.Products.RetryBaseDelay,
+			MaxDelay:         cfg.Products.RetryMaxDelay,
+			BreakerThreshold: cfg.Products.BreakerThreshold,
+			BreakerCooldown:  cfg.Products.BreakerCooldown,
+		})
+		if cfg.Products.CacheTTL > 0 {
+			products = clients.NewCachedClient(products, clients.CacheOptions{
+				TTL:         cfg.Products.CacheTTL,
+				NegativeTTL: cfg.Products.CacheNegativeTTL,
+				StaleTTL:    cfg.Products.CacheStaleTTL,
+			})
+		}
+	}
+
+	opts := app.Options{
+		Products:                 products,
+		AllowProductsUnavailable: cfg.ProductsAllowUnavailable,
+		RenewalInterval:          cfg.Renewal.Interval,
+		RenewalBatchSize:         cfg.Renewal.BatchSize,
+		EntitlementCacheTTL:      cfg.Entitlements.CacheTTL,
+		SigningKeys:              cfg.Tokens.SigningKeys,
+		TokenTTL:                 cfg.Tokens.TTL,
+		TokenIssuer:              cfg.Tokens.Issuer,
+		JWTIssuer:                cfg.Auth.JWTIssuer,
+		JWTAudience:              cfg.Auth.JWTAudience,
+		JWKSURL:                  cfg.Auth.JWKSURL,
+		JWTKeys:                  cfg.Auth.JWTKeys,
+		IdempotencyTTL:           cfg.Idempotency.TTL,
+	}
+	opts.Server = app.ServerConfig{
+		Addr:              cfg.Server.Addr,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		DrainDelay:        cfg.Server.DrainDelay,
+		ShutdownTimeout:   cfg.Server.ShutdownTimeout,
+	}
+
+	// Initialize routes and serve until a shutdown signal arrives
+	return app.InitializeRoute(ctx, db, opts)
+}
+
+func runAPIKey(s store.Store, args []string) error {
+	if len(args) < 3 || len(args) > 4 || args[0] != "create" {
+		return fmt.Errorf("usage: %s api-key create <name> <role,...> [organization_id]", os.Args[0])
+	}
+	key := models.APIKey{Name: args[1], Roles: strings.Split(args[2], ",")}
+	roles, err := auth.ParseRoles(key.Roles)
+	if err != nil {
+		return err
+	}
+	if len(args) == 4 {
+		id, err := strconv.Atoi(args[3])
+		if err != nil || id < 1 {
+			return fmt.Errorf("invalid organization_id %q", args[3])
+		}
+		key.OrganizationID = &id
+	} else if !auth.HasPlatformRole(roles) {
+		return fmt.Errorf("organization_id is required unless the roles include %s", auth.RolePlatformAdmin)
+	}
+
+	secret, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+	key.Prefix, key.Hash = prefix, hash
+	if err := s.APIKeys().Create(context.Background(), &key); err != nil {
+		return err
+	}
+	// The secret cannot be recovered later, only its hash is stored
+	fmt.Printf("created API key %d (%s)\n%s\n", key.ID, key.Prefix, secret)
+	return nil
+}
+
+func runMigrate(migrator *migrations.Migrator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s migrate up|down [steps]|status", os.Args[0])
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied   %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted  %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or status)", args[0])
+	}
 }
@@ -0,0 +1,98 @@
+package migrations
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name        string
+		files       fstest.MapFS
+		expectErr   bool
+		expectedLen int
+	}{
+		{
+			name: "success - ordered pairs",
+			files: fstest.MapFS{
+				"sql/0002_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+				"sql/0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+				"sql/0001_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+				"sql/0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+			},
+			expectedLen: 2,
+		},
+		{
+			name: "failure - missing down file",
+			files: fstest.MapFS{
+				"sql/0001_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+			},
+			expectErr: true,
+		},
+		{
+			name: "failure - invalid file name",
+			files: fstest.MapFS{
+				"sql/first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations, err := Load(tc.files)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, migrations, tc.expectedLen)
+			for i := 1; i < len(migrations); i++ {
+				assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(embedded)
+	assert.NoError(t, err)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be contiguous")
+	}
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator := &Migrator{db: db, migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+	}}
+
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WithArgs(advisoryLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b ()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)")).
+		WithArgs(2, "second").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WithArgs(advisoryLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	applied, err := migrator.Up(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, applied, 1) {
+		assert.Equal(t, 2, applied[0].Version)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
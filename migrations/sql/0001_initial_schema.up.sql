@@ -0,0 +1,20 @@
+-- IF NOT EXISTS keeps this migration safe on databases that were created
+-- by the inline schema main.go used to execute on startup.
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR NOT NULL,
+	product_id INT NOT NULL,
+	license_count INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_subscriptions (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	subscription_id INT NOT NULL REFERENCES subscriptions(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMP
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_subscriptions_user_id_idx;
+DROP INDEX IF EXISTS user_subscriptions_subscription_id_idx;
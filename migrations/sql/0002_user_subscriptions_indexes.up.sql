@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS user_subscriptions_subscription_id_idx
+	ON user_subscriptions (subscription_id) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS user_subscriptions_user_id_idx
+	ON user_subscriptions (user_id) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions
+	ADD COLUMN IF NOT EXISTS status VARCHAR NOT NULL DEFAULT 'active'
+	CHECK (status IN ('trialing', 'active', 'past_due', 'paused', 'canceled', 'expired'));
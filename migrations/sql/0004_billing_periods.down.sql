@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS subscription_renewals;
+DROP INDEX IF EXISTS subscriptions_current_period_end_idx;
+ALTER TABLE subscriptions
+	DROP CONSTRAINT IF EXISTS subscriptions_custom_interval_days_check,
+	DROP COLUMN IF EXISTS current_period_end,
+	DROP COLUMN IF EXISTS current_period_start,
+	DROP COLUMN IF EXISTS auto_renew,
+	DROP COLUMN IF EXISTS interval_days,
+	DROP COLUMN IF EXISTS billing_interval;
@@ -0,0 +1,33 @@
+ALTER TABLE subscriptions
+	ADD COLUMN IF NOT EXISTS billing_interval VARCHAR NOT NULL DEFAULT 'monthly'
+		CHECK (billing_interval IN ('monthly', 'annual', 'custom')),
+	ADD COLUMN IF NOT EXISTS interval_days INT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS auto_renew BOOLEAN NOT NULL DEFAULT TRUE,
+	ADD COLUMN IF NOT EXISTS current_period_start TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ADD COLUMN IF NOT EXISTS current_period_end TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP + INTERVAL '1 month';
+
+-- Custom periods must have a length or they would renew forever.
+ALTER TABLE subscriptions
+	ADD CONSTRAINT subscriptions_custom_interval_days_check
+	CHECK (billing_interval <> 'custom' OR interval_days > 0);
+
+-- Existing subscriptions start their first period when they were created.
+UPDATE subscriptions
+	SET current_period_start = created_at, current_period_end = created_at + INTERVAL '1 month'
+	WHERE created_at IS NOT NULL;
+
+-- The renewal worker polls for periods that have ended.
+CREATE INDEX IF NOT EXISTS subscriptions_current_period_end_idx
+	ON subscriptions (current_period_end)
+	WHERE deleted_at IS NULL AND status IN ('trialing', 'active', 'past_due');
+
+CREATE TABLE IF NOT EXISTS subscription_renewals (
+	id SERIAL PRIMARY KEY,
+	subscription_id INT NOT NULL REFERENCES subscriptions(id),
+	period_start TIMESTAMP NOT NULL,
+	period_end TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS subscription_renewals_subscription_id_idx
+	ON subscription_renewals (subscription_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS trial_claims;
+ALTER TABLE subscriptions
+	DROP COLUMN IF EXISTS trial_seat_limit,
+	DROP COLUMN IF EXISTS trial_end;
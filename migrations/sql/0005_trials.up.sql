@@ -0,0 +1,12 @@
+ALTER TABLE subscriptions
+	ADD COLUMN IF NOT EXISTS trial_end TIMESTAMP,
+	ADD COLUMN IF NOT EXISTS trial_seat_limit INT NOT NULL DEFAULT 0;
+
+-- A user may trial each product once; the first trial seat claims it.
+CREATE TABLE IF NOT EXISTS trial_claims (
+	user_id INT NOT NULL,
+	product_id INT NOT NULL,
+	subscription_id INT NOT NULL REFERENCES subscriptions(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, product_id)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS plan_changes (
+	id SERIAL PRIMARY KEY,
+	subscription_id INT NOT NULL REFERENCES subscriptions(id),
+	from_product_id INT NOT NULL,
+	from_license_count INT NOT NULL,
+	to_product_id INT NOT NULL,
+	to_license_count INT NOT NULL,
+	timing VARCHAR NOT NULL CHECK (timing IN ('immediately', 'at_period_end')),
+	status VARCHAR NOT NULL CHECK (status IN ('scheduled', 'applied', 'canceled')),
+	proration NUMERIC(12, 2) NOT NULL DEFAULT 0,
+	effective_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	applied_at TIMESTAMP
+);
+
+-- At most one change waits for the end of a subscription's period.
+CREATE UNIQUE INDEX IF NOT EXISTS plan_changes_scheduled_idx
+	ON plan_changes (subscription_id) WHERE status = 'scheduled';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS invoice_lines;
+DROP TABLE IF EXISTS invoices;
+DROP SEQUENCE IF EXISTS invoice_number_seq;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS discount_percent;
@@ -0,0 +1,39 @@
+ALTER TABLE subscriptions
+	ADD COLUMN IF NOT EXISTS discount_percent NUMERIC(5, 2) NOT NULL DEFAULT 0
+		CONSTRAINT subscriptions_discount_percent_check CHECK (discount_percent >= 0 AND discount_percent <= 100);
+
+-- Invoice numbers are only drawn when an invoice is finalized.
+CREATE SEQUENCE IF NOT EXISTS invoice_number_seq;
+
+CREATE TABLE IF NOT EXISTS invoices (
+	id SERIAL PRIMARY KEY,
+	number VARCHAR UNIQUE,
+	subscription_id INT NOT NULL REFERENCES subscriptions(id),
+	status VARCHAR NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'open', 'paid', 'void')),
+	period_start TIMESTAMP NOT NULL,
+	period_end TIMESTAMP NOT NULL,
+	subtotal NUMERIC(12, 2) NOT NULL,
+	total NUMERIC(12, 2) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	issued_at TIMESTAMP,
+	paid_at TIMESTAMP,
+	voided_at TIMESTAMP
+);
+
+-- A period is invoiced at most once unless its invoice was voided.
+CREATE UNIQUE INDEX IF NOT EXISTS invoices_subscription_period_idx
+	ON invoices (subscription_id, period_start) WHERE status <> 'void';
+
+CREATE TABLE IF NOT EXISTS invoice_lines (
+	id SERIAL PRIMARY KEY,
+	invoice_id INT NOT NULL REFERENCES invoices(id),
+	kind VARCHAR NOT NULL CHECK (kind IN ('base', 'proration', 'discount')),
+	description VARCHAR NOT NULL,
+	quantity INT NOT NULL,
+	unit_price NUMERIC(12, 2) NOT NULL,
+	amount NUMERIC(12, 2) NOT NULL,
+	plan_change_id INT REFERENCES plan_changes(id)
+);
+
+CREATE INDEX IF NOT EXISTS invoice_lines_invoice_id_idx ON invoice_lines (invoice_id);
+CREATE INDEX IF NOT EXISTS invoice_lines_plan_change_id_idx ON invoice_lines (plan_change_id) WHERE plan_change_id IS NOT NULL;
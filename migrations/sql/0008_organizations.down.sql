@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS subscriptions_organization_id_idx;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organizations;
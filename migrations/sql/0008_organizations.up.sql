@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS organizations (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMP
+);
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS organization_id INT REFERENCES organizations(id);
+
+-- Subscriptions created before organizations existed all move to one
+-- organization, to be split up by hand if needed.
+INSERT INTO organizations (name)
+	SELECT 'Default' WHERE EXISTS (SELECT 1 FROM subscriptions WHERE organization_id IS NULL);
+UPDATE subscriptions SET organization_id = (SELECT MIN(id) FROM organizations) WHERE organization_id IS NULL;
+
+ALTER TABLE subscriptions ALTER COLUMN organization_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS subscriptions_organization_id_idx ON subscriptions (organization_id) WHERE deleted_at IS NULL;
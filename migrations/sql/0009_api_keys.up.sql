@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR NOT NULL,
+	prefix VARCHAR NOT NULL,
+	-- Hex SHA-256 of the secret; the secret itself is never stored
+	key_hash VARCHAR NOT NULL UNIQUE,
+	organization_id INT REFERENCES organizations(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP
+);
@@ -0,0 +1,4 @@
+-- Comma separated role names. Keys created before roles existed could do
+-- anything, so they keep doing so as platform admins.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS roles VARCHAR NOT NULL DEFAULT 'platform_admin';
+ALTER TABLE api_keys ALTER COLUMN roles SET DEFAULT '';
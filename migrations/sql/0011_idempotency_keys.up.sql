@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	-- scope is the caller the key belongs to
+	scope VARCHAR NOT NULL,
+	key VARCHAR NOT NULL,
+	fingerprint VARCHAR NOT NULL,
+	-- The response is NULL while the request is in progress
+	status_code INT,
+	content_type VARCHAR,
+	body BYTEA,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// APIKey lets a service call the API. Only the SHA-256 hash of the secret is
+// stored; the secret itself is shown once, when the key is created.
+type APIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the secret, to tell keys apart
+	Prefix string `json:"prefix"`
+	Hash   string `json:"-"`
+	// OrganizationID scopes the key to one organization; nil keys act for
+	// the platform and may pick any organization.
+	OrganizationID *int `json:"organization_id"`
+	// Roles name the permissions of the key, see package auth
+	Roles     []string   `json:"roles"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// BillingInterval is how long one billing period of a subscription lasts.
+type BillingInterval string
+
+const (
+	IntervalMonthly BillingInterval = "monthly"
+	IntervalAnnual  BillingInterval = "annual"
+	// IntervalCustom periods last Subscription.IntervalDays days.
+	IntervalCustom BillingInterval = "custom"
+)
+
+// Valid reports whether i is a known interval.
+func (i BillingInterval) Valid() bool {
+	switch i {
+	case IntervalMonthly, IntervalAnnual, IntervalCustom:
+		return true
+	}
+	return false
+}
+
+// PeriodEnd returns when a billing period starting at start ends.
+func (s Subscription) PeriodEnd(start time.Time) time.Time {
+	switch s.BillingInterval {
+	case IntervalAnnual:
+		return start.AddDate(1, 0, 0)
+	case IntervalCustom:
+		return start.AddDate(0, 0, s.IntervalDays)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// PeriodEnded reports whether the current period is over at now and the
+// subscription still has to be renewed or expired. Paused, canceled and
+// expired subscriptions are not renewed.
+func (s Subscription) PeriodEnded(now time.Time) bool {
+	switch s.Status {
+	case StatusTrialing, StatusActive, StatusPastDue:
+		return !s.CurrentPeriodEnd.After(now)
+	}
+	return false
+}
+
+// EndPeriod returns the subscription as it is once its current period is
+// over: renewed for another period when auto_renew is on and it is in good
+// standing, expired otherwise. renewed reports which one happened.
+func (s Subscription) EndPeriod() (next Subscription, renewed bool, err error) {
+	next = s
+	if !s.AutoRenew || (s.Status != StatusActive && s.Status != StatusTrialing) {
+		status, ok := s.Status.Next(EventExpire)
+		if !ok {
+			return s, false, fmt.Errorf("cannot expire a subscription that is %s", s.Status)
+		}
+		next.Status = status
+		return next, false, nil
+	}
+
+	if s.Status == StatusTrialing {
+		next.Status, _ = s.Status.Next(EventActivate)
+	}
+	next.CurrentPeriodStart = s.CurrentPeriodEnd
+	next.CurrentPeriodEnd = s.PeriodEnd(s.CurrentPeriodEnd)
+	return next, true, nil
+}
+
+// SeatLimit is how many seats may be assigned right now: license_count,
+// lowered to trial_seat_limit while the subscription is trialing.
+func (s Subscription) SeatLimit() int {
+	if s.Status == StatusTrialing && s.TrialSeatLimit > 0 && s.TrialSeatLimit < s.LicenseCount {
+		return s.TrialSeatLimit
+	}
+	return s.LicenseCount
+}
+
+// Renewal records a subscription moving into a new billing period.
+type Renewal struct {
+	ID             int       `json:"id"`
+	SubscriptionID int       `json:"subscription_id"`
+	PeriodStart    time.Time `json:"period_start"`
+	PeriodEnd      time.Time `json:"period_end"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// DenialReason explains why an entitlement check was denied.
+type DenialReason string
+
+const (
+	// DenyNoSeat means the user holds no seat on a subscription to the product.
+	DenyNoSeat DenialReason = "no_seat"
+	// DenyPeriodEnded means the subscription's period ended and it has not
+	// been renewed yet.
+	DenyPeriodEnded          DenialReason = "period_ended"
+	DenySubscriptionPaused   DenialReason = "subscription_paused"
+	DenySubscriptionCanceled DenialReason = "subscription_canceled"
+	DenySubscriptionExpired  DenialReason = "subscription_expired"
+)
+
+// Entitlement answers whether a user may use a product at a point in time.
+type Entitlement struct {
+	UserID    int  `json:"user_id"`
+	ProductID int  `json:"product_id"`
+	Allowed   bool `json:"allowed"`
+	// Reason is set when access is denied
+	Reason DenialReason `json:"reason,omitempty"`
+	// SubscriptionID is the subscription granting access, or the one the
+	// denial is about
+	SubscriptionID int `json:"subscription_id,omitempty"`
+	// ExpiresAt is when the granting subscription's current period ends
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Entitles reports whether a seat on s grants access at now and, if not, why.
+// Past due subscriptions keep access until they expire.
+func (s Subscription) Entitles(now time.Time) (bool, DenialReason) {
+	switch s.Status {
+	case StatusPaused:
+		return false, DenySubscriptionPaused
+	case StatusCanceled:
+		return false, DenySubscriptionCanceled
+	case StatusExpired:
+		return false, DenySubscriptionExpired
+	}
+	if !s.CurrentPeriodEnd.After(now) {
+		return false, DenyPeriodEnded
+	}
+	return true, ""
+}
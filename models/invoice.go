@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// InvoiceStatus tracks an invoice from generation to settlement.
+type InvoiceStatus string
+
+const (
+	// InvoiceDraft invoices can still be reviewed; they have no number yet.
+	InvoiceDraft InvoiceStatus = "draft"
+	// InvoiceOpen invoices are numbered, issued and awaiting payment.
+	InvoiceOpen InvoiceStatus = "open"
+	InvoicePaid InvoiceStatus = "paid"
+	// InvoiceVoid invoices are cancelled; their period may be invoiced again.
+	InvoiceVoid InvoiceStatus = "void"
+)
+
+// invoiceTransitions lists the statuses each invoice status may move to.
+var invoiceTransitions = map[InvoiceStatus][]InvoiceStatus{
+	InvoiceDraft: {InvoiceOpen, InvoiceVoid},
+	InvoiceOpen:  {InvoicePaid, InvoiceVoid},
+}
+
+// CanBecome reports whether an invoice in status s may move to status to.
+func (s InvoiceStatus) CanBecome(to InvoiceStatus) bool {
+	for _, next := range invoiceTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// InvoiceLineKind says what an invoice line charges or credits.
+type InvoiceLineKind string
+
+const (
+	// LineBase charges the product price for every licensed seat.
+	LineBase InvoiceLineKind = "base"
+	// LineProration charges or credits an immediate plan change.
+	LineProration InvoiceLineKind = "proration"
+	// LineDiscount credits the subscription's discount.
+	LineDiscount InvoiceLineKind = "discount"
+)
+
+// InvoiceLine is one charge or credit on an invoice.
+type InvoiceLine struct {
+	ID          int             `json:"id"`
+	Kind        InvoiceLineKind `json:"kind"`
+	Description string          `json:"description"`
+	Quantity    int             `json:"quantity"`
+	UnitPrice   float64         `json:"unit_price"`
+	Amount      float64         `json:"amount"`
+	// PlanChangeID links a proration line to the plan change it bills
+	PlanChangeID *int `json:"plan_change_id,omitempty"`
+}
+
+// Invoice bills one billing period of a subscription.
+type Invoice struct {
+	ID int `json:"id"`
+	// Number is assigned when the invoice is finalized
+	Number         string        `json:"number,omitempty"`
+	SubscriptionID int           `json:"subscription_id"`
+	Status         InvoiceStatus `json:"status"`
+	PeriodStart    time.Time     `json:"period_start"`
+	PeriodEnd      time.Time     `json:"period_end"`
+	Lines          []InvoiceLine `json:"lines"`
+	// Subtotal is the sum of the lines before discounts
+	Subtotal  float64    `json:"subtotal"`
+	Total     float64    `json:"total"`
+	CreatedAt time.Time  `json:"created_at"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	PaidAt    *time.Time `json:"paid_at,omitempty"`
+	VoidedAt  *time.Time `json:"voided_at,omitempty"`
+}
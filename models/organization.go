@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Organization is the customer that owns subscriptions. Every subscription,
+// and through it every seat and invoice, belongs to exactly one.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// PlanChangeTiming is when a plan change takes effect.
+type PlanChangeTiming string
+
+const (
+	ChangeImmediately PlanChangeTiming = "immediately"
+	// ChangeAtPeriodEnd changes are applied by the renewal worker when the
+	// current billing period ends.
+	ChangeAtPeriodEnd PlanChangeTiming = "at_period_end"
+)
+
+// PlanChangeStatus tracks a plan change from request to application.
+type PlanChangeStatus string
+
+const (
+	PlanChangeScheduled PlanChangeStatus = "scheduled"
+	PlanChangeApplied   PlanChangeStatus = "applied"
+	// PlanChangeCanceled changes were replaced by a newer change or the
+	// subscription ended before they took effect.
+	PlanChangeCanceled PlanChangeStatus = "canceled"
+)
+
+// PlanChange moves a subscription to another product or seat count.
+type PlanChange struct {
+	ID               int              `json:"id"`
+	SubscriptionID   int              `json:"subscription_id"`
+	FromProductID    int              `json:"from_product_id"`
+	FromLicenseCount int              `json:"from_license_count"`
+	ToProductID      int              `json:"to_product_id"`
+	ToLicenseCount   int              `json:"to_license_count"`
+	Timing           PlanChangeTiming `json:"timing"`
+	Status           PlanChangeStatus `json:"status"`
+	// Proration is charged (positive) or credited (negative) for the rest of
+	// the current period. Changes at period end are not prorated.
+	Proration   float64    `json:"proration"`
+	EffectiveAt time.Time  `json:"effective_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// Product is an offer of the products service, as embedded in responses
+// that ask for expand=product.
+type Product struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
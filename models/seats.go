@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// SeatUsage summarizes how the seats of a subscription are used.
+type SeatUsage struct {
+	SubscriptionID int `json:"subscription_id"`
+	LicenseCount   int `json:"license_count"`
+	// SeatLimit is license_count, lowered to trial_seat_limit while trialing
+	SeatLimit int          `json:"seat_limit"`
+	Assigned  int          `json:"assigned"`
+	Available int          `json:"available"`
+	Users     []SeatHolder `json:"users"`
+}
+
+// SeatHolder is a user holding a seat of a subscription.
+type SeatHolder struct {
+	UserSubscriptionID int       `json:"user_subscription_id"`
+	UserID             int       `json:"user_id"`
+	AssignedAt         time.Time `json:"assigned_at"`
+}
+
+// AvailableSeats is how many more seats may be assigned when assigned are
+// already taken. It is never negative, even for oversubscribed subscriptions.
+func (s Subscription) AvailableSeats(assigned int) int {
+	if available := s.SeatLimit() - assigned; available > 0 {
+		return available
+	}
+	return 0
+}
+
+// NewSeatUsage summarizes the seats assigned on subscription.
+func NewSeatUsage(subscription Subscription, assigned []UserSubscription) SeatUsage {
+	usage := SeatUsage{
+		SubscriptionID: subscription.ID,
+		LicenseCount:   subscription.LicenseCount,
+		SeatLimit:      subscription.SeatLimit(),
+		Assigned:       len(assigned),
+		Available:      subscription.AvailableSeats(len(assigned)),
+		Users:          make([]SeatHolder, len(assigned)),
+	}
+	for i, seat := range assigned {
+		usage.Users[i] = SeatHolder{UserSubscriptionID: seat.ID, UserID: seat.UserID, AssignedAt: seat.CreatedAt}
+	}
+	return usage
+}
@@ -5,10 +5,32 @@ import "time"
 
 type Subscription struct {
     ID            int       `json:"id"`
+    // OrganizationID is the organization owning the subscription; it never changes
+    OrganizationID int      `json:"organization_id"`
     Name          string    `json:"name"`
     ProductID     int       `json:"product_id"`
     LicenseCount  int       `json:"license_count"`
+    // Status only changes through the transition endpoints
+    Status        SubscriptionStatus `json:"status"`
+    BillingInterval    BillingInterval `json:"billing_interval"`
+    // IntervalDays is the period length of custom billing intervals
+    IntervalDays       int       `json:"interval_days,omitempty"`
+    AutoRenew          bool      `json:"auto_renew"`
+    CurrentPeriodStart time.Time `json:"current_period_start"`
+    CurrentPeriodEnd   time.Time `json:"current_period_end"`
+    // TrialEnd is set on subscriptions created as a free trial; the trial
+    // converts to active or expires when it is reached
+    TrialEnd           *time.Time `json:"trial_end,omitempty"`
+    // TrialSeatLimit caps assigned seats while trialing; 0 means license_count
+    TrialSeatLimit     int       `json:"trial_seat_limit,omitempty"`
+    // DiscountPercent is taken off every invoice of the subscription
+    DiscountPercent    float64   `json:"discount_percent,omitempty"`
     CreatedAt     time.Time `json:"created_at"`
     UpdatedAt     time.Time `json:"updated_at"`
     DeletedAt     *time.Time `json:"deleted_at"`
+    // Product is only filled in when the request asks for expand=product
+    Product       *Product `json:"product,omitempty"`
+    // SeatsUsed and SeatsAvailable are only filled in for expand=seats
+    SeatsUsed      *int `json:"seats_used,omitempty"`
+    SeatsAvailable *int `json:"seats_available,omitempty"`
 }
@@ -0,0 +1,79 @@
+package models
+
+// SubscriptionStatus is where a subscription is in its lifecycle.
+type SubscriptionStatus string
+
+const (
+	StatusTrialing SubscriptionStatus = "trialing"
+	StatusActive   SubscriptionStatus = "active"
+	StatusPastDue  SubscriptionStatus = "past_due"
+	StatusPaused   SubscriptionStatus = "paused"
+	StatusCanceled SubscriptionStatus = "canceled"
+	StatusExpired  SubscriptionStatus = "expired"
+)
+
+// SubscriptionEvent is something that happens to a subscription and may
+// move it to another status.
+type SubscriptionEvent string
+
+const (
+	EventActivate      SubscriptionEvent = "activate"
+	EventPaymentFailed SubscriptionEvent = "payment_failed"
+	EventPause         SubscriptionEvent = "pause"
+	EventResume        SubscriptionEvent = "resume"
+	EventCancel        SubscriptionEvent = "cancel"
+	EventExpire        SubscriptionEvent = "expire"
+)
+
+// transitions lists every allowed status change. Canceled and expired are
+// terminal; anything missing from the table is rejected.
+var transitions = map[SubscriptionStatus]map[SubscriptionEvent]SubscriptionStatus{
+	StatusTrialing: {
+		EventActivate: StatusActive,
+		EventCancel:   StatusCanceled,
+		EventExpire:   StatusExpired,
+	},
+	StatusActive: {
+		EventPaymentFailed: StatusPastDue,
+		EventPause:         StatusPaused,
+		EventCancel:        StatusCanceled,
+		EventExpire:        StatusExpired,
+	},
+	StatusPastDue: {
+		EventActivate: StatusActive,
+		EventCancel:   StatusCanceled,
+		EventExpire:   StatusExpired,
+	},
+	StatusPaused: {
+		EventResume: StatusActive,
+		EventCancel: StatusCanceled,
+	},
+}
+
+// Next returns the status event moves s to, and false if the transition
+// is not allowed.
+func (s SubscriptionStatus) Next(event SubscriptionEvent) (SubscriptionStatus, bool) {
+	next, ok := transitions[s][event]
+	return next, ok
+}
+
+// Valid reports whether s is a known status.
+func (s SubscriptionStatus) Valid() bool {
+	switch s {
+	case StatusTrialing, StatusActive, StatusPastDue, StatusPaused, StatusCanceled, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// Seatable reports whether seats may be assigned on a subscription in
+// status s.
+func (s SubscriptionStatus) Seatable() bool {
+	return s == StatusTrialing || s == StatusActive || s == StatusPastDue
+}
+
+// PlanChangeable reports whether the plan of a subscription in status s may
+// still be changed.
+func (s SubscriptionStatus) PlanChangeable() bool {
+	return s != StatusCanceled && s != StatusExpired
+}
@@ -11,4 +11,6 @@ type UserSubscription struct {
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
 	DeletedAt        *time.Time `json:"deleted_at"`
+	// Product is only filled in when the request asks for expand=product
+	Product *Product `json:"product,omitempty"`
 }
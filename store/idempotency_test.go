@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresIdempotencyBeginRetriesPurgedClaim(t *testing.T) {
+	insertQuery := regexp.QuoteMeta("INSERT INTO idempotency_keys")
+	selectQuery := regexp.QuoteMeta("SELECT fingerprint, status_code, content_type, body, created_at, expires_at FROM idempotency_keys WHERE scope = $1 AND key = $2")
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	record := IdempotencyRecord{Scope: "key:1", Key: "abc", Fingerprint: "POST /subscriptions", CreatedAt: now, ExpiresAt: now.Add(24 * time.Hour)}
+
+	t.Run("claims after the holder is purged", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery(insertQuery).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(selectQuery).WithArgs("key:1", "abc").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(insertQuery).WillReturnRows(sqlmock.NewRows([]string{"scope"}).AddRow("key:1"))
+
+		_, claimed, err := NewPostgres(db).IdempotencyKeys().Begin(context.Background(), record, now.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("gives up after bounded attempts", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		for i := 0; i < idempotencyClaimAttempts; i++ {
+			mock.ExpectQuery(insertQuery).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(selectQuery).WithArgs("key:1", "abc").WillReturnError(sql.ErrNoRows)
+		}
+
+		_, claimed, err := NewPostgres(db).IdempotencyKeys().Begin(context.Background(), record, now.Add(-time.Minute))
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.False(t, claimed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestIdempotencyLateCompleteAfterTakeover(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	slow := IdempotencyRecord{Scope: "key:1", Key: "abc", Fingerprint: "POST /subscriptions", CreatedAt: start, ExpiresAt: start.Add(24 * time.Hour)}
+	retry := slow
+	retry.CreatedAt = start.Add(2 * time.Minute)
+
+	t.Run("memory", func(t *testing.T) {
+		keys := NewMemory().IdempotencyKeys()
+		_, claimed, err := keys.Begin(ctx, slow, start.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+		_, claimed, err = keys.Begin(ctx, retry, retry.CreatedAt.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.True(t, claimed, "the slow request is presumed abandoned")
+
+		assert.NoError(t, keys.Release(ctx, "key:1", "abc", slow.CreatedAt))
+		assert.ErrorIs(t, keys.Complete(ctx, "key:1", "abc", slow.CreatedAt, 201, "application/json", []byte(`{"id": 1}`)), ErrNotFound)
+		assert.NoError(t, keys.Complete(ctx, "key:1", "abc", retry.CreatedAt, 201, "application/json", []byte(`{"id": 2}`)))
+
+		existing, claimed, err := keys.Begin(ctx, retry, retry.CreatedAt)
+		assert.NoError(t, err)
+		assert.False(t, claimed)
+		assert.Equal(t, `{"id": 2}`, string(existing.Body), "the late request does not overwrite the response")
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		keys := NewPostgres(db).IdempotencyKeys()
+
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND created_at = $3 AND status_code IS NULL")).
+			WithArgs("key:1", "abc", slow.CreatedAt).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE idempotency_keys SET status_code = $4, content_type = $5, body = $6 WHERE scope = $1 AND key = $2 AND created_at = $3")).
+			WithArgs("key:1", "abc", slow.CreatedAt, 201, "application/json", []byte(`{"id": 1}`)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.NoError(t, keys.Release(ctx, "key:1", "abc", slow.CreatedAt))
+		assert.ErrorIs(t, keys.Complete(ctx, "key:1", "abc", slow.CreatedAt, 201, "application/json", []byte(`{"id": 1}`)), ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,619 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"subscriptions/models"
+	"sync"
+	"time"
+)
+
+// Memory implements Store in process memory. It mirrors the Postgres
+// behaviour closely enough to exercise the HTTP API in tests.
+type Memory struct {
+	mu                sync.Mutex
+	organizations     map[int]models.Organization
+	apiKeys           []models.APIKey
+	subscriptions     map[int]models.Subscription
+	userSubscriptions map[int]models.UserSubscription
+	renewals          []models.Renewal
+	planChanges       []models.PlanChange
+	invoices          []models.Invoice
+	idempotency       map[[2]string]IdempotencyRecord
+	// trialClaims maps a user and product to the subscription they trialed it with
+	trialClaims map[[2]int]int
+	nextID      map[string]int
+}
+
+// NewMemory creates an empty in-memory Store.
+func NewMemory() *Memory {
+	return &Memory{
+		organizations:     map[int]models.Organization{},
+		subscriptions:     map[int]models.Subscription{},
+		userSubscriptions: map[int]models.UserSubscription{},
+		trialClaims:       map[[2]int]int{},
+		idempotency:       map[[2]string]IdempotencyRecord{},
+		nextID:            map[string]int{},
+	}
+}
+
+func (m *Memory) Organizations() OrganizationStore {
+	return &memoryOrganizations{m: m}
+}
+
+func (m *Memory) APIKeys() APIKeyStore {
+	return &memoryAPIKeys{m: m}
+}
+
+func (m *Memory) Subscriptions() SubscriptionStore {
+	return &memorySubscriptions{m: m}
+}
+
+func (m *Memory) UserSubscriptions() UserSubscriptionStore {
+	return &memoryUserSubscriptions{m: m}
+}
+
+func (m *Memory) Invoices() InvoiceStore {
+	return &memoryInvoices{m: m}
+}
+
+func (m *Memory) IdempotencyKeys() IdempotencyStore {
+	return &memoryIdempotency{m: m}
+}
+
+// id returns the next value of the named sequence. Callers must hold m.mu.
+func (m *Memory) id(sequence string) int {
+	m.nextID[sequence]++
+	return m.nextID[sequence]
+}
+
+// inScope reports whether subscription belongs to the organization ctx is
+// scoped to. Everything is in the scope of an unscoped ctx.
+func inScope(ctx context.Context, subscription models.Subscription) bool {
+	organizationID := OrganizationFrom(ctx)
+	return organizationID == 0 || subscription.OrganizationID == organizationID
+}
+
+// fitSeats makes the seats assigned on a subscription fit into licenseCount
+// according to policy and returns the revoked seats. Callers must hold m.mu.
+func (m *Memory) fitSeats(id, licenseCount int, policy SeatPolicy) ([]int, error) {
+	seats := []models.UserSubscription{}
+	for _, seat := range m.userSubscriptions {
+		if seat.SubscriptionID == id && seat.DeletedAt == nil {
+			seats = append(seats, seat)
+		}
+	}
+	if len(seats) <= licenseCount {
+		return nil, nil
+	}
+	if policy != SeatPolicyRevoke {
+		return nil, &SeatOverageError{LicenseCount: licenseCount, Assigned: len(seats)}
+	}
+
+	// Most recently assigned first
+	sort.Slice(seats, func(i, j int) bool {
+		if !seats[i].CreatedAt.Equal(seats[j].CreatedAt) {
+			return seats[i].CreatedAt.After(seats[j].CreatedAt)
+		}
+		return seats[i].ID > seats[j].ID
+	})
+	now := time.Now()
+	revoked := []int{}
+	for _, seat := range seats[:len(seats)-licenseCount] {
+		seat.DeletedAt = &now
+		seat.UpdatedAt = now
+		m.userSubscriptions[seat.ID] = seat
+		revoked = append(revoked, seat.ID)
+	}
+	sort.Ints(revoked)
+	return revoked, nil
+}
+
+type memoryOrganizations struct {
+	m *Memory
+}
+
+func (s *memoryOrganizations) Create(ctx context.Context, organization *models.Organization) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now()
+	organization.ID = s.m.id("organizations")
+	organization.CreatedAt = now
+	organization.UpdatedAt = now
+	s.m.organizations[organization.ID] = *organization
+	return nil
+}
+
+func (s *memoryOrganizations) Get(ctx context.Context, id int) (models.Organization, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	organization, ok := s.m.organizations[id]
+	if scope := OrganizationFrom(ctx); !ok || (scope != 0 && scope != id) {
+		return models.Organization{}, ErrNotFound
+	}
+	return organization, nil
+}
+
+type memorySubscriptions struct {
+	m *Memory
+}
+
+func (s *memorySubscriptions) List(ctx context.Context, filter SubscriptionFilter, req PageRequest) (Page[models.Subscription], error) {
+	ks, err := newKeyset(req, subscriptionSortKeys)
+	if err != nil {
+		return Page[models.Subscription]{}, err
+	}
+
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscriptions := []models.Subscription{}
+	for _, subscription := range s.m.subscriptions {
+		switch {
+		case subscription.DeletedAt != nil,
+			!inScope(ctx, subscription),
+			filter.OrganizationID != 0 && subscription.OrganizationID != filter.OrganizationID,
+			filter.ProductID != 0 && subscription.ProductID != filter.ProductID,
+			!strings.HasPrefix(subscription.Name, filter.NamePrefix),
+			filter.Status != "" && subscription.Status != filter.Status,
+			!filter.CreatedAfter.IsZero() && !subscription.CreatedAt.After(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !subscription.CreatedAt.Before(filter.CreatedBefore):
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	total := len(subscriptions)
+	page := ks.apply(subscriptions, func(s models.Subscription) int { return s.ID })
+	if req.IncludeTotal {
+		page.Total = &total
+	}
+	return page, nil
+}
+
+func (s *memorySubscriptions) Get(ctx context.Context, id int) (models.Subscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscription, ok := s.m.subscriptions[id]
+	if !ok || subscription.DeletedAt != nil || !inScope(ctx, subscription) {
+		return models.Subscription{}, ErrNotFound
+	}
+	return subscription, nil
+}
+
+func (s *memorySubscriptions) Create(ctx context.Context, subscription *models.Subscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	now := time.Now()
+	if organizationID := OrganizationFrom(ctx); organizationID != 0 {
+		subscription.OrganizationID = organizationID
+	}
+	if _, ok := s.m.organizations[subscription.OrganizationID]; !ok {
+		return ErrOrganizationRequired
+	}
+	subscription.ID = s.m.id("subscriptions")
+	startPeriod(subscription, now)
+	subscription.CreatedAt = now
+	subscription.UpdatedAt = now
+	subscription.DeletedAt = nil
+	s.m.subscriptions[subscription.ID] = *subscription
+	return nil
+}
+
+func (s *memorySubscriptions) Update(ctx context.Context, id int, subscription *models.Subscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	existing, ok := s.m.subscriptions[id]
+	if !ok || existing.DeletedAt != nil || !inScope(ctx, existing) {
+		return ErrNotFound
+	}
+	if planChanged(existing, *subscription) {
+		return ErrPlanChangeRequired
+	}
+	existing.Name = subscription.Name
+	existing.UpdatedAt = time.Now()
+	s.m.subscriptions[id] = existing
+
+	subscription.ID = id
+	return nil
+}
+
+func (s *memorySubscriptions) Delete(ctx context.Context, id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	existing, ok := s.m.subscriptions[id]
+	if !ok || existing.DeletedAt != nil || !inScope(ctx, existing) {
+		return ErrNotFound
+	}
+	now := time.Now()
+	existing.DeletedAt = &now
+	s.m.subscriptions[id] = existing
+	return nil
+}
+
+func (s *memorySubscriptions) Transition(ctx context.Context, id int, event models.SubscriptionEvent, now time.Time) (models.Subscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscription, ok := s.m.subscriptions[id]
+	if !ok || subscription.DeletedAt != nil || !inScope(ctx, subscription) {
+		return models.Subscription{}, ErrNotFound
+	}
+	next, ok := subscription.Status.Next(event)
+	if !ok {
+		return models.Subscription{}, fmt.Errorf("%w: cannot %s a subscription that is %s", ErrInvalidTransition, event, subscription.Status)
+	}
+	if restartsPeriod(subscription.Status, event) {
+		subscription.CurrentPeriodStart = now.UTC()
+		subscription.CurrentPeriodEnd = subscription.PeriodEnd(subscription.CurrentPeriodStart)
+	}
+	subscription.Status = next
+	subscription.UpdatedAt = time.Now()
+	s.m.subscriptions[id] = subscription
+	return subscription, nil
+}
+
+func (s *memorySubscriptions) ListForUser(ctx context.Context, userID int, productIDs []int) ([]models.Subscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	products := map[int]bool{}
+	for _, id := range productIDs {
+		products[id] = true
+	}
+	seated := map[int]bool{}
+	for _, userSubscription := range s.m.userSubscriptions {
+		if userSubscription.UserID == userID && userSubscription.DeletedAt == nil {
+			seated[userSubscription.SubscriptionID] = true
+		}
+	}
+
+	subscriptions := []models.Subscription{}
+	for id := range seated {
+		subscription, ok := s.m.subscriptions[id]
+		if !ok || subscription.DeletedAt != nil || !inScope(ctx, subscription) || (len(products) > 0 && !products[subscription.ProductID]) {
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
+
+func (s *memorySubscriptions) ListDue(ctx context.Context, now time.Time, limit int) ([]models.Subscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscriptions := []models.Subscription{}
+	for _, subscription := range s.m.subscriptions {
+		if subscription.DeletedAt == nil && subscription.PeriodEnded(now) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		a, b := subscriptions[i], subscriptions[j]
+		if !a.CurrentPeriodEnd.Equal(b.CurrentPeriodEnd) {
+			return a.CurrentPeriodEnd.Before(b.CurrentPeriodEnd)
+		}
+		return a.ID < b.ID
+	})
+	if len(subscriptions) > limit {
+		subscriptions = subscriptions[:limit]
+	}
+	return subscriptions, nil
+}
+
+func (s *memorySubscriptions) EndPeriod(ctx context.Context, id int, now time.Time) (models.Subscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscription, ok := s.m.subscriptions[id]
+	if !ok || subscription.DeletedAt != nil {
+		return models.Subscription{}, ErrNotFound
+	}
+	if !subscription.PeriodEnded(now) {
+		return subscription, ErrNotDue
+	}
+
+	next, renewed, err := subscription.EndPeriod()
+	if err != nil {
+		return subscription, err
+	}
+	for i := range s.m.planChanges {
+		change := &s.m.planChanges[i]
+		if change.SubscriptionID != id || change.Status != models.PlanChangeScheduled {
+			continue
+		}
+		if !renewed {
+			change.Status = models.PlanChangeCanceled
+			continue
+		}
+		appliedAt := now
+		change.Status = models.PlanChangeApplied
+		change.AppliedAt = &appliedAt
+		next.ProductID = change.ToProductID
+		next.LicenseCount = change.ToLicenseCount
+		if _, err := s.m.fitSeats(id, next.LicenseCount, SeatPolicyRevoke); err != nil {
+			return subscription, err
+		}
+	}
+	next.UpdatedAt = time.Now()
+	s.m.subscriptions[id] = next
+	if renewed {
+		s.m.renewals = append(s.m.renewals, models.Renewal{
+			ID:             s.m.id("subscription_renewals"),
+			SubscriptionID: id,
+			PeriodStart:    next.CurrentPeriodStart,
+			PeriodEnd:      next.CurrentPeriodEnd,
+			CreatedAt:      now,
+		})
+	}
+	return next, nil
+}
+
+func (s *memorySubscriptions) Renewals(ctx context.Context, subscriptionID int) ([]models.Renewal, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	renewals := []models.Renewal{}
+	if !inScope(ctx, s.m.subscriptions[subscriptionID]) {
+		return renewals, nil
+	}
+	for _, renewal := range s.m.renewals {
+		if renewal.SubscriptionID == subscriptionID {
+			renewals = append(renewals, renewal)
+		}
+	}
+	return renewals, nil
+}
+
+func (s *memorySubscriptions) ChangePlan(ctx context.Context, change *models.PlanChange, policy SeatPolicy, now time.Time) ([]int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscription, ok := s.m.subscriptions[change.SubscriptionID]
+	if !ok || subscription.DeletedAt != nil || !inScope(ctx, subscription) {
+		return nil, ErrNotFound
+	}
+	if err := preparePlanChange(subscription, change, now); err != nil {
+		return nil, err
+	}
+	var revoked []int
+	if change.ToLicenseCount < subscription.LicenseCount && (change.Timing == models.ChangeImmediately || policy != SeatPolicyRevoke) {
+		var err error
+		if revoked, err = s.m.fitSeats(subscription.ID, change.ToLicenseCount, policy); err != nil {
+			return nil, err
+		}
+	}
+
+	if change.Timing == models.ChangeImmediately {
+		subscription.ProductID = change.ToProductID
+		subscription.LicenseCount = change.ToLicenseCount
+		subscription.UpdatedAt = time.Now()
+		s.m.subscriptions[subscription.ID] = subscription
+	} else {
+		for i := range s.m.planChanges {
+			if s.m.planChanges[i].SubscriptionID == subscription.ID && s.m.planChanges[i].Status == models.PlanChangeScheduled {
+				s.m.planChanges[i].Status = models.PlanChangeCanceled
+			}
+		}
+	}
+
+	change.ID = s.m.id("plan_changes")
+	change.CreatedAt = now
+	s.m.planChanges = append(s.m.planChanges, *change)
+	return revoked, nil
+}
+
+func (s *memorySubscriptions) PlanChanges(ctx context.Context, subscriptionID int) ([]models.PlanChange, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	changes := []models.PlanChange{}
+	if !inScope(ctx, s.m.subscriptions[subscriptionID]) {
+		return changes, nil
+	}
+	for _, change := range s.m.planChanges {
+		if change.SubscriptionID == subscriptionID {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+type memoryUserSubscriptions struct {
+	m *Memory
+}
+
+// joined fills in the subscription columns the Postgres store selects via JOIN.
+// Callers must hold m.mu.
+func (s *memoryUserSubscriptions) joined(userSubscription models.UserSubscription) models.UserSubscription {
+	subscription := s.m.subscriptions[userSubscription.SubscriptionID]
+	userSubscription.SubscriptionName = subscription.Name
+	userSubscription.ProductID = subscription.ProductID
+	return userSubscription
+}
+
+func (s *memoryUserSubscriptions) List(ctx context.Context, filter UserSubscriptionFilter, req PageRequest) (Page[models.UserSubscription], error) {
+	ks, err := newKeyset(req, userSubscriptionSortKeys)
+	if err != nil {
+		return Page[models.UserSubscription]{}, err
+	}
+
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	userSubscriptions := []models.UserSubscription{}
+	for _, userSubscription := range s.m.userSubscriptions {
+		userSubscription = s.joined(userSubscription)
+		switch {
+		case userSubscription.DeletedAt != nil,
+			!inScope(ctx, s.m.subscriptions[userSubscription.SubscriptionID]),
+			filter.UserID != 0 && userSubscription.UserID != filter.UserID,
+			filter.SubscriptionID != 0 && userSubscription.SubscriptionID != filter.SubscriptionID,
+			filter.ProductID != 0 && userSubscription.ProductID != filter.ProductID,
+			!filter.CreatedAfter.IsZero() && !userSubscription.CreatedAt.After(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !userSubscription.CreatedAt.Before(filter.CreatedBefore):
+			continue
+		}
+		userSubscriptions = append(userSubscriptions, userSubscription)
+	}
+
+	total := len(userSubscriptions)
+	page := ks.apply(userSubscriptions, func(us models.UserSubscription) int { return us.ID })
+	if req.IncludeTotal {
+		page.Total = &total
+	}
+	return page, nil
+}
+
+func (s *memoryUserSubscriptions) Get(ctx context.Context, id int) (models.UserSubscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	userSubscription, ok := s.m.userSubscriptions[id]
+	if !ok || userSubscription.DeletedAt != nil || !inScope(ctx, s.m.subscriptions[userSubscription.SubscriptionID]) {
+		return models.UserSubscription{}, ErrNotFound
+	}
+	return s.joined(userSubscription), nil
+}
+
+func (s *memoryUserSubscriptions) Create(ctx context.Context, userSubscription *models.UserSubscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if err := s.m.allocateSeat(ctx, userSubscription.UserID, userSubscription.SubscriptionID, true); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	userSubscription.ID = s.m.id("user_subscriptions")
+	s.m.userSubscriptions[userSubscription.ID] = models.UserSubscription{
+		ID:             userSubscription.ID,
+		UserID:         userSubscription.UserID,
+		SubscriptionID: userSubscription.SubscriptionID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	return nil
+}
+
+// allocateSeat checks that userID may take a seat on the subscription, as
+// the Postgres allocateSeat does. Callers must hold m.mu.
+func (m *Memory) allocateSeat(ctx context.Context, userID, subscriptionID int, checkLimit bool) error {
+	subscription, ok := m.subscriptions[subscriptionID]
+	if !ok || subscription.DeletedAt != nil || !inScope(ctx, subscription) {
+		return ErrNotFound
+	}
+	if !subscription.Status.Seatable() {
+		return fmt.Errorf("%w: subscription is %s", ErrSubscriptionNotSeatable, subscription.Status)
+	}
+
+	if checkLimit {
+		assigned := 0
+		for _, existing := range m.userSubscriptions {
+			if existing.SubscriptionID == subscription.ID && existing.DeletedAt == nil {
+				assigned++
+			}
+		}
+		if assigned >= subscription.SeatLimit() {
+			return ErrNoLicensesAvailable
+		}
+	}
+
+	if subscription.Status == models.StatusTrialing {
+		claim := [2]int{userID, subscription.ProductID}
+		if claimedBy, ok := m.trialClaims[claim]; ok && claimedBy != subscription.ID {
+			return ErrTrialAlreadyUsed
+		}
+		m.trialClaims[claim] = subscription.ID
+	}
+	return nil
+}
+
+func (s *memoryUserSubscriptions) Update(ctx context.Context, id int, userSubscription *models.UserSubscription) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	existing, ok := s.m.userSubscriptions[id]
+	if !ok || existing.DeletedAt != nil || !inScope(ctx, s.m.subscriptions[existing.SubscriptionID]) {
+		return ErrNotFound
+	}
+	err := s.m.allocateSeat(ctx, userSubscription.UserID, userSubscription.SubscriptionID, userSubscription.SubscriptionID != existing.SubscriptionID)
+	if errors.Is(err, ErrNotFound) {
+		return ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	existing.UserID = userSubscription.UserID
+	existing.SubscriptionID = userSubscription.SubscriptionID
+	existing.UpdatedAt = time.Now()
+	s.m.userSubscriptions[id] = existing
+
+	userSubscription.ID = id
+	return nil
+}
+
+func (s *memoryUserSubscriptions) Delete(ctx context.Context, id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	existing, ok := s.m.userSubscriptions[id]
+	if !ok || !inScope(ctx, s.m.subscriptions[existing.SubscriptionID]) {
+		return ErrNotFound
+	}
+	now := time.Now()
+	existing.DeletedAt = &now
+	s.m.userSubscriptions[id] = existing
+	return nil
+}
+
+func (s *memoryUserSubscriptions) Assigned(ctx context.Context, subscriptionID int) ([]models.UserSubscription, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	userSubscriptions := []models.UserSubscription{}
+	if !inScope(ctx, s.m.subscriptions[subscriptionID]) {
+		return userSubscriptions, nil
+	}
+	for _, userSubscription := range s.m.userSubscriptions {
+		if userSubscription.SubscriptionID == subscriptionID && userSubscription.DeletedAt == nil {
+			userSubscriptions = append(userSubscriptions, s.joined(userSubscription))
+		}
+	}
+	sort.Slice(userSubscriptions, func(i, j int) bool {
+		a, b := userSubscriptions[i], userSubscriptions[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return userSubscriptions, nil
+}
+
+func (s *memoryUserSubscriptions) CountAssigned(ctx context.Context, subscriptionIDs []int) (map[int]int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	wanted := map[int]bool{}
+	for _, id := range subscriptionIDs {
+		wanted[id] = inScope(ctx, s.m.subscriptions[id])
+	}
+	counts := map[int]int{}
+	for _, userSubscription := range s.m.userSubscriptions {
+		if wanted[userSubscription.SubscriptionID] && userSubscription.DeletedAt == nil {
+			counts[userSubscription.SubscriptionID]++
+		}
+	}
+	return counts, nil
+}
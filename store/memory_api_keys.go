@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"subscriptions/models"
+	"time"
+)
+
+type memoryAPIKeys struct {
+	m *Memory
+}
+
+// keyInScope reports whether key is visible under the organization scope of ctx.
+func keyInScope(ctx context.Context, key models.APIKey) bool {
+	organizationID := OrganizationFrom(ctx)
+	return organizationID == 0 || (key.OrganizationID != nil && *key.OrganizationID == organizationID)
+}
+
+func (s *memoryAPIKeys) Create(ctx context.Context, key *models.APIKey) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if organizationID := OrganizationFrom(ctx); organizationID != 0 {
+		key.OrganizationID = &organizationID
+	}
+	key.ID = s.m.id("api_keys")
+	key.CreatedAt = time.Now()
+	key.RevokedAt = nil
+	key.Roles = append([]string{}, key.Roles...)
+	s.m.apiKeys = append(s.m.apiKeys, *key)
+	return nil
+}
+
+func (s *memoryAPIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	keys := []models.APIKey{}
+	for _, key := range s.m.apiKeys {
+		if keyInScope(ctx, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryAPIKeys) FindByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, key := range s.m.apiKeys {
+		if key.Hash == hash && key.RevokedAt == nil && keyInScope(ctx, key) {
+			return key, nil
+		}
+	}
+	return models.APIKey{}, ErrNotFound
+}
+
+func (s *memoryAPIKeys) Revoke(ctx context.Context, id int, now time.Time) (models.APIKey, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i, key := range s.m.apiKeys {
+		if key.ID != id || !keyInScope(ctx, key) {
+			continue
+		}
+		if key.RevokedAt == nil {
+			s.m.apiKeys[i].RevokedAt = &now
+		}
+		return s.m.apiKeys[i], nil
+	}
+	return models.APIKey{}, ErrNotFound
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+type memoryIdempotency struct {
+	m *Memory
+}
+
+func (s *memoryIdempotency) Begin(ctx context.Context, record IdempotencyRecord, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	id := [2]string{record.Scope, record.Key}
+	if existing, ok := s.m.idempotency[id]; ok && existing.ExpiresAt.After(record.CreatedAt) &&
+		(existing.StatusCode != 0 || !existing.CreatedAt.Before(staleBefore)) {
+		existing.Body = append([]byte(nil), existing.Body...)
+		return existing, false, nil
+	}
+	record.StatusCode, record.ContentType, record.Body = 0, "", nil
+	s.m.idempotency[id] = record
+	return IdempotencyRecord{}, true, nil
+}
+
+func (s *memoryIdempotency) Complete(ctx context.Context, scope, key string, claimedAt time.Time, statusCode int, contentType string, body []byte) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	id := [2]string{scope, key}
+	record, ok := s.m.idempotency[id]
+	if !ok || !record.CreatedAt.Equal(claimedAt) {
+		return ErrNotFound
+	}
+	record.StatusCode, record.ContentType, record.Body = statusCode, contentType, append([]byte(nil), body...)
+	s.m.idempotency[id] = record
+	return nil
+}
+
+func (s *memoryIdempotency) Release(ctx context.Context, scope, key string, claimedAt time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	id := [2]string{scope, key}
+	if record, ok := s.m.idempotency[id]; ok && record.CreatedAt.Equal(claimedAt) && record.StatusCode == 0 {
+		delete(s.m.idempotency, id)
+	}
+	return nil
+}
+
+func (s *memoryIdempotency) Purge(ctx context.Context, now time.Time) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	purged := 0
+	for id, record := range s.m.idempotency {
+		if !record.ExpiresAt.After(now) {
+			delete(s.m.idempotency, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"subscriptions/models"
+	"time"
+)
+
+type memoryInvoices struct {
+	m *Memory
+}
+
+// copyInvoice returns invoice with its own copy of the lines so callers
+// cannot modify the stored invoice.
+func copyInvoice(invoice models.Invoice) models.Invoice {
+	invoice.Lines = append([]models.InvoiceLine{}, invoice.Lines...)
+	return invoice
+}
+
+func (s *memoryInvoices) Create(ctx context.Context, invoice *models.Invoice) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	subscription, ok := s.m.subscriptions[invoice.SubscriptionID]
+	if !ok || subscription.DeletedAt != nil || !inScope(ctx, subscription) {
+		return ErrNotFound
+	}
+	for _, existing := range s.m.invoices {
+		if existing.SubscriptionID == invoice.SubscriptionID && existing.PeriodStart.Equal(invoice.PeriodStart) && existing.Status != models.InvoiceVoid {
+			return ErrInvoiceExists
+		}
+	}
+
+	invoice.ID = s.m.id("invoices")
+	invoice.Status = models.InvoiceDraft
+	invoice.CreatedAt = time.Now()
+	for i := range invoice.Lines {
+		invoice.Lines[i].ID = s.m.id("invoice_lines")
+	}
+	s.m.invoices = append(s.m.invoices, copyInvoice(*invoice))
+	return nil
+}
+
+func (s *memoryInvoices) Get(ctx context.Context, id int) (models.Invoice, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, invoice := range s.m.invoices {
+		if invoice.ID == id && inScope(ctx, s.m.subscriptions[invoice.SubscriptionID]) {
+			return copyInvoice(invoice), nil
+		}
+	}
+	return models.Invoice{}, ErrNotFound
+}
+
+func (s *memoryInvoices) ListBySubscription(ctx context.Context, subscriptionID int) ([]models.Invoice, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	invoices := []models.Invoice{}
+	if !inScope(ctx, s.m.subscriptions[subscriptionID]) {
+		return invoices, nil
+	}
+	for _, invoice := range s.m.invoices {
+		if invoice.SubscriptionID == subscriptionID {
+			invoices = append(invoices, copyInvoice(invoice))
+		}
+	}
+	return invoices, nil
+}
+
+func (s *memoryInvoices) Transition(ctx context.Context, id int, status models.InvoiceStatus, now time.Time) (models.Invoice, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for i := range s.m.invoices {
+		if s.m.invoices[i].ID != id || !inScope(ctx, s.m.subscriptions[s.m.invoices[i].SubscriptionID]) {
+			continue
+		}
+		invoice := s.m.invoices[i]
+		err := transitionInvoice(&invoice, status, now, func() (int, error) {
+			return s.m.id("invoice_numbers"), nil
+		})
+		if err != nil {
+			return models.Invoice{}, err
+		}
+		s.m.invoices[i] = invoice
+		return copyInvoice(invoice), nil
+	}
+	return models.Invoice{}, ErrNotFound
+}
+
+func (s *memoryInvoices) UnbilledPlanChanges(ctx context.Context, subscriptionID int) ([]models.PlanChange, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if !inScope(ctx, s.m.subscriptions[subscriptionID]) {
+		return []models.PlanChange{}, nil
+	}
+	billed := map[int]bool{}
+	for _, invoice := range s.m.invoices {
+		if invoice.Status == models.InvoiceVoid {
+			continue
+		}
+		for _, line := range invoice.Lines {
+			if line.PlanChangeID != nil {
+				billed[*line.PlanChangeID] = true
+			}
+		}
+	}
+
+	changes := []models.PlanChange{}
+	for _, change := range s.m.planChanges {
+		if change.SubscriptionID == subscriptionID && change.Status == models.PlanChangeApplied && change.Proration != 0 && !billed[change.ID] {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
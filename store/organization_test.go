@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"subscriptions/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSubscriptionRequiresOrganization(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("memory", func(t *testing.T) {
+		m := NewMemory()
+		assert.ErrorIs(t, m.Subscriptions().Create(ctx, &models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1}), ErrOrganizationRequired)
+		assert.ErrorIs(t, m.Subscriptions().Create(ctx, &models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1, OrganizationID: 9}), ErrOrganizationRequired)
+
+		subscription := models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1}
+		assert.NoError(t, m.Subscriptions().Create(WithOrganization(ctx, seedOrganization(t, m)), &subscription))
+		assert.Equal(t, 1, subscription.OrganizationID)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		existsQuery := regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1 AND deleted_at IS NULL)")
+		mock.ExpectQuery(existsQuery).WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery(existsQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		s := NewPostgres(db).Subscriptions()
+		assert.ErrorIs(t, s.Create(ctx, &models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1}), ErrOrganizationRequired)
+		assert.ErrorIs(t, s.Create(ctx, &models.Subscription{Name: "Team", ProductID: 101, LicenseCount: 1, OrganizationID: 9}), ErrOrganizationRequired)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,239 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the page size used when a request does not set one.
+	DefaultLimit = 50
+	// MaxLimit caps the page size a request may ask for.
+	MaxLimit = 200
+)
+
+// ErrInvalidPageRequest is returned for unknown sort fields, malformed
+// cursors or cursors that do not belong to the requested sort order.
+var ErrInvalidPageRequest = errors.New("invalid page request")
+
+// PageRequest selects one page of a keyset-paginated list.
+type PageRequest struct {
+	Limit int
+	// Sort is a whitelisted field name; ties are broken by id.
+	Sort string
+	Desc bool
+	// Cursor is the opaque NextCursor of the previous page.
+	Cursor string
+	// IncludeTotal asks for the number of rows matching the filters.
+	IncludeTotal bool
+}
+
+// Page is one page of results plus what is needed to fetch the next one.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+}
+
+type fieldKind int
+
+const (
+	kindInt fieldKind = iota
+	kindString
+	kindTime
+)
+
+// sortKey describes a field lists may be ordered by: its SQL column and how
+// to read it from a record for the in-memory store and for cursors.
+type sortKey[T any] struct {
+	column string
+	kind   fieldKind
+	value  func(T) interface{}
+}
+
+// cursor is the position after the last row of a page, encoded opaquely.
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidPageRequest)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidPageRequest)
+	}
+	return c, nil
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (k fieldKind) parse(s string) (interface{}, error) {
+	switch k {
+	case kindInt:
+		return strconv.Atoi(s)
+	case kindTime:
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		return s, nil
+	}
+}
+
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int:
+		return a - b.(int)
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return strings.Compare(a.(string), b.(string))
+	}
+}
+
+// keyset is a validated PageRequest for one entity type.
+type keyset[T any] struct {
+	sortName string
+	key      sortKey[T]
+	desc     bool
+	limit    int
+	// after is set when the request continues from a cursor
+	after      bool
+	afterValue interface{}
+	afterID    int
+}
+
+func newKeyset[T any](req PageRequest, keys map[string]sortKey[T]) (keyset[T], error) {
+	ks := keyset[T]{sortName: req.Sort, desc: req.Desc, limit: req.Limit}
+	if ks.sortName == "" {
+		ks.sortName = "id"
+	}
+	key, ok := keys[ks.sortName]
+	if !ok {
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return ks, fmt.Errorf("%w: cannot sort by %q (allowed: %s)", ErrInvalidPageRequest, ks.sortName, strings.Join(names, ", "))
+	}
+	ks.key = key
+
+	if ks.limit <= 0 {
+		ks.limit = DefaultLimit
+	}
+	if ks.limit > MaxLimit {
+		ks.limit = MaxLimit
+	}
+
+	if req.Cursor != "" {
+		c, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return ks, err
+		}
+		if c.Sort != ks.sortName || c.Desc != ks.desc {
+			return ks, fmt.Errorf("%w: cursor belongs to a different sort order", ErrInvalidPageRequest)
+		}
+		value, err := key.kind.parse(c.Value)
+		if err != nil {
+			return ks, fmt.Errorf("%w: malformed cursor", ErrInvalidPageRequest)
+		}
+		ks.after, ks.afterValue, ks.afterID = true, value, c.ID
+	}
+	return ks, nil
+}
+
+// where returns the SQL condition selecting rows after the cursor, using
+// placeholders starting at $next, and the matching arguments.
+func (ks keyset[T]) where(idColumn string, next int) (string, []interface{}) {
+	if !ks.after {
+		return "", nil
+	}
+	op := ">"
+	if ks.desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s, %s) %s ($%d, $%d)", ks.key.column, idColumn, op, next, next+1), []interface{}{ks.afterValue, ks.afterID}
+}
+
+// orderBy returns the ORDER BY ... LIMIT clause; one extra row is fetched to
+// learn whether another page follows.
+func (ks keyset[T]) orderBy(idColumn string) string {
+	dir := "ASC"
+	if ks.desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, %s %s LIMIT %d", ks.key.column, dir, idColumn, dir, ks.limit+1)
+}
+
+// page trims rows fetched with one extra row into a Page.
+func (ks keyset[T]) page(rows []T, id func(T) int) Page[T] {
+	page := Page[T]{Items: rows}
+	if len(rows) > ks.limit {
+		page.Items = rows[:ks.limit]
+		last := page.Items[ks.limit-1]
+		page.NextCursor = encodeCursor(cursor{Sort: ks.sortName, Desc: ks.desc, Value: formatValue(ks.key.value(last)), ID: id(last)})
+	}
+	return page
+}
+
+// apply sorts, positions and limits rows in memory the way the SQL would.
+func (ks keyset[T]) apply(rows []T, id func(T) int) Page[T] {
+	less := func(a, b T) int {
+		if c := compareValues(ks.key.value(a), ks.key.value(b)); c != 0 {
+			return c
+		}
+		return id(a) - id(b)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		c := less(rows[i], rows[j])
+		if ks.desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if ks.after {
+		start := len(rows)
+		for i, row := range rows {
+			c := compareValues(ks.key.value(row), ks.afterValue)
+			if c == 0 {
+				c = id(row) - ks.afterID
+			}
+			if (!ks.desc && c > 0) || (ks.desc && c < 0) {
+				start = i
+				break
+			}
+		}
+		rows = rows[start:]
+	}
+
+	if len(rows) > ks.limit+1 {
+		rows = rows[:ks.limit+1]
+	}
+	return ks.page(rows, id)
+}
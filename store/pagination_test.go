@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"subscriptions/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seedOrganization creates the organization test subscriptions belong to.
+func seedOrganization(t *testing.T, s Store) int {
+	t.Helper()
+	organization := models.Organization{Name: "Acme"}
+	assert.NoError(t, s.Organizations().Create(context.Background(), &organization))
+	return organization.ID
+}
+
+func seedSubscriptions(t *testing.T, m *Memory, n int) {
+	t.Helper()
+	organizationID := seedOrganization(t, m)
+	for i := 0; i < n; i++ {
+		subscription := models.Subscription{OrganizationID: organizationID, Name: fmt.Sprintf("Plan %c", 'A'+i%3), ProductID: 100 + i%2, LicenseCount: i % 4}
+		assert.NoError(t, m.Subscriptions().Create(context.Background(), &subscription))
+	}
+}
+
+func TestMemoryListWalksEveryPage(t *testing.T) {
+	m := NewMemory()
+	seedSubscriptions(t, m, 11)
+
+	for _, sort := range []string{"id", "name", "license_count", "created_at"} {
+		for _, desc := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s desc=%v", sort, desc), func(t *testing.T) {
+				req := PageRequest{Limit: 3, Sort: sort, Desc: desc}
+				seen := map[int]bool{}
+				var previous *models.Subscription
+				for pages := 0; ; pages++ {
+					if pages > 4 {
+						t.Fatal("pagination did not terminate")
+					}
+					page, err := m.Subscriptions().List(context.Background(), SubscriptionFilter{}, req)
+					assert.NoError(t, err)
+					for i := range page.Items {
+						item := page.Items[i]
+						assert.False(t, seen[item.ID], "subscription %d returned twice", item.ID)
+						seen[item.ID] = true
+						if previous != nil {
+							c := compareValues(subscriptionSortKeys[sort].value(*previous), subscriptionSortKeys[sort].value(item))
+							if desc {
+								assert.GreaterOrEqual(t, c, 0)
+							} else {
+								assert.LessOrEqual(t, c, 0)
+							}
+						}
+						previous = &item
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					req.Cursor = page.NextCursor
+				}
+				assert.Len(t, seen, 11)
+			})
+		}
+	}
+}
+
+func TestMemoryListFiltersAndTotal(t *testing.T) {
+	m := NewMemory()
+	seedSubscriptions(t, m, 6)
+
+	page, err := m.Subscriptions().List(context.Background(), SubscriptionFilter{ProductID: 100, NamePrefix: "Plan A"}, PageRequest{IncludeTotal: true})
+	assert.NoError(t, err)
+	if assert.NotNil(t, page.Total) {
+		assert.Equal(t, 1, *page.Total)
+	}
+	if assert.Len(t, page.Items, 1) {
+		assert.Equal(t, 1, page.Items[0].ID)
+	}
+
+	page, err = m.Subscriptions().List(context.Background(), SubscriptionFilter{CreatedAfter: time.Now()}, PageRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Items)
+	assert.Nil(t, page.Total)
+}
+
+func TestNewKeysetRejectsBadRequests(t *testing.T) {
+	m := NewMemory()
+	seedSubscriptions(t, m, 3)
+	page, err := m.Subscriptions().List(context.Background(), SubscriptionFilter{}, PageRequest{Limit: 1, Sort: "name"})
+	assert.NoError(t, err)
+
+	for name, req := range map[string]PageRequest{
+		"unknown sort":          {Sort: "deleted_at"},
+		"garbage cursor":        {Cursor: "not-a-cursor!"},
+		"cursor of other sort":  {Cursor: page.NextCursor},
+		"cursor of other order": {Sort: "name", Desc: true, Cursor: page.NextCursor},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := m.Subscriptions().List(context.Background(), SubscriptionFilter{}, req)
+			assert.True(t, errors.Is(err, ErrInvalidPageRequest), "got %v", err)
+		})
+	}
+}
+
+func TestKeysetSQL(t *testing.T) {
+	ks, err := newKeyset(PageRequest{Limit: 500, Sort: "created_at", Desc: true}, userSubscriptionSortKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, " ORDER BY us.created_at DESC, us.id DESC LIMIT 201", ks.orderBy("us.id"))
+
+	cond, _ := ks.where("us.id", 3)
+	assert.Empty(t, cond, "first page has no keyset condition")
+
+	rows := make([]models.UserSubscription, MaxLimit+1)
+	for i := range rows {
+		rows[i].ID = i + 1
+	}
+	page := ks.page(rows, func(us models.UserSubscription) int { return us.ID })
+	assert.Len(t, page.Items, MaxLimit)
+
+	ks, err = newKeyset(PageRequest{Sort: "created_at", Desc: true, Cursor: page.NextCursor}, userSubscriptionSortKeys)
+	assert.NoError(t, err)
+	cond, args := ks.where("us.id", 3)
+	assert.Equal(t, "(us.created_at, us.id) < ($3, $4)", cond)
+	assert.Equal(t, MaxLimit, args[1])
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"subscriptions/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryScheduledDowngradeFitsSeats(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	subscription := models.Subscription{OrganizationID: seedOrganization(t, m), Name: "Team", ProductID: 101, LicenseCount: 5, AutoRenew: true}
+	assert.NoError(t, m.Subscriptions().Create(ctx, &subscription))
+	seat := func() models.UserSubscription {
+		seat := models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}
+		assert.NoError(t, m.UserSubscriptions().Create(ctx, &seat))
+		return seat
+	}
+	seat()
+	seat()
+
+	now := subscription.CurrentPeriodStart
+	downgrade := func(licenseCount int) error {
+		change := models.PlanChange{SubscriptionID: subscription.ID, FromProductID: 101, FromLicenseCount: 5, ToProductID: 101, ToLicenseCount: licenseCount, Timing: models.ChangeAtPeriodEnd}
+		_, err := m.Subscriptions().ChangePlan(ctx, &change, SeatPolicyReject, now)
+		return err
+	}
+	var overage *SeatOverageError
+	assert.ErrorAs(t, downgrade(1), &overage, "fewer licenses than assigned seats")
+	assert.Equal(t, 1, overage.Overage())
+	assert.NoError(t, downgrade(3))
+
+	// Seats assigned after scheduling are revoked when the change applies
+	seat()
+	latest := seat()
+	next, err := m.Subscriptions().EndPeriod(ctx, subscription.ID, subscription.CurrentPeriodEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, next.LicenseCount)
+
+	_, err = m.UserSubscriptions().Get(ctx, latest.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	page, err := m.UserSubscriptions().List(ctx, UserSubscriptionFilter{SubscriptionID: subscription.ID}, PageRequest{Limit: MaxLimit})
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 3)
+}
+
+func TestPostgresScheduledDowngradeFitsSeats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := NewPostgres(db).Subscriptions()
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	countSeats := regexp.QuoteMeta("SELECT COUNT(*) FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL")
+
+	t.Run("scheduling checks the assigned seats", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT product_id, license_count, status, current_period_end FROM subscriptions WHERE id = $1 AND deleted_at IS NULL FOR UPDATE")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "license_count", "status", "current_period_end"}).AddRow(101, 5, "active", end))
+		mock.ExpectQuery(countSeats).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+		mock.ExpectRollback()
+
+		change := models.PlanChange{SubscriptionID: 1, FromProductID: 101, FromLicenseCount: 5, ToProductID: 101, ToLicenseCount: 3, Timing: models.ChangeAtPeriodEnd}
+		var overage *SeatOverageError
+		_, err := s.ChangePlan(ctx, &change, SeatPolicyReject, start)
+		assert.ErrorAs(t, err, &overage)
+		assert.Equal(t, 1, overage.Overage())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("renewal revokes seats beyond the new count", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(selectSubscriptions + " AND id = $1 FOR UPDATE")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "name", "product_id", "license_count", "status", "created_at", "updated_at", "deleted_at",
+				"billing_interval", "interval_days", "auto_renew", "current_period_start", "current_period_end",
+				"trial_end", "trial_seat_limit", "discount_percent", "organization_id",
+			}).AddRow(1, "Team", 101, 5, "active", start, start, nil, "monthly", 0, true, start, end, nil, 0, 0.0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, to_product_id, to_license_count FROM plan_changes WHERE subscription_id = $1 AND status = 'scheduled' FOR UPDATE")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "to_product_id", "to_license_count"}).AddRow(9, 101, 3))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE plan_changes SET status = 'applied'")).WithArgs(end, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countSeats).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE user_subscriptions SET deleted_at = CURRENT_TIMESTAMP")).
+			WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(14))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE subscriptions SET status = $1")).
+			WithArgs(models.StatusActive, end, end.AddDate(0, 1, 0), 101, 3, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO subscription_renewals")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		next, err := s.EndPeriod(ctx, 1, end)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, next.LicenseCount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,910 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"subscriptions/models"
+	"time"
+)
+
+// Postgres implements Store on top of a PostgreSQL connection pool.
+type Postgres struct {
+	db *sql.DB
+}
+
+// NewPostgres creates a Store backed by db.
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Organizations() OrganizationStore {
+	return &postgresOrganizations{db: p.db}
+}
+
+func (p *Postgres) APIKeys() APIKeyStore {
+	return &postgresAPIKeys{db: p.db}
+}
+
+func (p *Postgres) Subscriptions() SubscriptionStore {
+	return &postgresSubscriptions{db: p.db}
+}
+
+func (p *Postgres) UserSubscriptions() UserSubscriptionStore {
+	return &postgresUserSubscriptions{db: p.db}
+}
+
+func (p *Postgres) Invoices() InvoiceStore {
+	return &postgresInvoices{db: p.db}
+}
+
+func (p *Postgres) IdempotencyKeys() IdempotencyStore {
+	return &postgresIdempotency{db: p.db}
+}
+
+type postgresOrganizations struct {
+	db *sql.DB
+}
+
+func (s *postgresOrganizations) Create(ctx context.Context, organization *models.Organization) error {
+	return s.db.QueryRowContext(ctx, "INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at, updated_at", organization.Name).
+		Scan(&organization.ID, &organization.CreatedAt, &organization.UpdatedAt)
+}
+
+func (s *postgresOrganizations) Get(ctx context.Context, id int) (models.Organization, error) {
+	var organization models.Organization
+	scope, args := scopeOrganization(ctx, "id", 2)
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, created_at, updated_at FROM organizations WHERE id = $1 AND deleted_at IS NULL"+scope, append([]interface{}{id}, args...)...).
+		Scan(&organization.ID, &organization.Name, &organization.CreatedAt, &organization.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return organization, ErrNotFound
+	}
+	return organization, err
+}
+
+type postgresSubscriptions struct {
+	db *sql.DB
+}
+
+const subscriptionColumns = "id, name, product_id, license_count, status, created_at, updated_at, deleted_at, billing_interval, interval_days, auto_renew, current_period_start, current_period_end, trial_end, trial_seat_limit, discount_percent, organization_id"
+
+const selectSubscriptions = "SELECT " + subscriptionColumns + " FROM subscriptions WHERE deleted_at IS NULL"
+
+func scanSubscription(row interface{ Scan(...interface{}) error }, subscription *models.Subscription) error {
+	return row.Scan(
+		&subscription.ID, &subscription.Name, &subscription.ProductID, &subscription.LicenseCount, &subscription.Status,
+		&subscription.CreatedAt, &subscription.UpdatedAt, &subscription.DeletedAt,
+		&subscription.BillingInterval, &subscription.IntervalDays, &subscription.AutoRenew,
+		&subscription.CurrentPeriodStart, &subscription.CurrentPeriodEnd,
+		&subscription.TrialEnd, &subscription.TrialSeatLimit, &subscription.DiscountPercent, &subscription.OrganizationID)
+}
+
+func (s *postgresSubscriptions) List(ctx context.Context, filter SubscriptionFilter, req PageRequest) (Page[models.Subscription], error) {
+	ks, err := newKeyset(req, subscriptionSortKeys)
+	if err != nil {
+		return Page[models.Subscription]{}, err
+	}
+
+	var q conditions
+	if organizationID := OrganizationFrom(ctx); organizationID != 0 {
+		q.add("organization_id = ?", organizationID)
+	}
+	if filter.OrganizationID != 0 {
+		q.add("organization_id = ?", filter.OrganizationID)
+	}
+	if filter.ProductID != 0 {
+		q.add("product_id = ?", filter.ProductID)
+	}
+	if filter.NamePrefix != "" {
+		q.add("starts_with(name, ?)", filter.NamePrefix)
+	}
+	if filter.Status != "" {
+		q.add("status = ?", filter.Status)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		q.add("created_at > ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		q.add("created_at < ?", filter.CreatedBefore)
+	}
+
+	var total *int
+	if req.IncludeTotal {
+		var n int
+		if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM subscriptions WHERE deleted_at IS NULL"+q.sql(), q.args...).Scan(&n); err != nil {
+			return Page[models.Subscription]{}, err
+		}
+		total = &n
+	}
+
+	cond, args := ks.where("id", len(q.args)+1)
+	if cond != "" {
+		q.conds = append(q.conds, cond)
+		q.args = append(q.args, args...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, selectSubscriptions+q.sql()+ks.orderBy("id"), q.args...)
+	if err != nil {
+		return Page[models.Subscription]{}, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		var subscription models.Subscription
+		if err := scanSubscription(rows, &subscription); err != nil {
+			return Page[models.Subscription]{}, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[models.Subscription]{}, err
+	}
+
+	page := ks.page(subscriptions, func(s models.Subscription) int { return s.ID })
+	page.Total = total
+	return page, nil
+}
+
+func (s *postgresSubscriptions) Get(ctx context.Context, id int) (models.Subscription, error) {
+	var subscription models.Subscription
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err := scanSubscription(s.db.QueryRowContext(ctx, selectSubscriptions+" AND id = $1"+scope, append([]interface{}{id}, args...)...), &subscription)
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrNotFound
+	}
+	return subscription, err
+}
+
+func (s *postgresSubscriptions) Create(ctx context.Context, subscription *models.Subscription) error {
+	startPeriod(subscription, time.Now())
+	if organizationID := OrganizationFrom(ctx); organizationID != 0 {
+		subscription.OrganizationID = organizationID
+	}
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1 AND deleted_at IS NULL)", subscription.OrganizationID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrOrganizationRequired
+	}
+	return s.db.QueryRowContext(ctx,
+		"INSERT INTO subscriptions (name, product_id, license_count, status, billing_interval, interval_days, auto_renew, current_period_start, current_period_end, trial_end, trial_seat_limit, discount_percent, organization_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id, created_at, updated_at",
+		subscription.Name, subscription.ProductID, subscription.LicenseCount, subscription.Status,
+		subscription.BillingInterval, subscription.IntervalDays, subscription.AutoRenew, subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd,
+		subscription.TrialEnd, subscription.TrialSeatLimit, subscription.DiscountPercent, subscription.OrganizationID,
+	).Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+}
+
+func (s *postgresSubscriptions) Update(ctx context.Context, id int, subscription *models.Subscription) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The lock keeps a concurrent plan change from slipping in between the
+	// check and the update
+	var current models.Subscription
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err = tx.QueryRowContext(ctx, "SELECT product_id, license_count FROM subscriptions WHERE id = $1 AND deleted_at IS NULL"+scope+" FOR UPDATE", append([]interface{}{id}, args...)...).
+		Scan(&current.ProductID, &current.LicenseCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if planChanged(current, *subscription) {
+		return ErrPlanChangeRequired
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE subscriptions SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", subscription.Name, id)
+	if err != nil {
+		return err
+	}
+	subscription.ID = id
+	return tx.Commit()
+}
+
+// planChanged reports whether update asks for a product or license_count
+// other than those of current; zero values keep the current ones.
+func planChanged(current, update models.Subscription) bool {
+	return (update.ProductID != 0 && update.ProductID != current.ProductID) ||
+		(update.LicenseCount != 0 && update.LicenseCount != current.LicenseCount)
+}
+
+// fitSeats makes the seats assigned on a locked subscription fit into
+// licenseCount according to policy and returns the revoked seats.
+func fitSeats(ctx context.Context, tx *sql.Tx, id, licenseCount int, policy SeatPolicy) ([]int, error) {
+	var assigned int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL", id).Scan(&assigned)
+	if err != nil {
+		return nil, err
+	}
+	if assigned <= licenseCount {
+		return nil, nil
+	}
+	if policy != SeatPolicyRevoke {
+		return nil, &SeatOverageError{LicenseCount: licenseCount, Assigned: assigned}
+	}
+
+	rows, err := tx.QueryContext(ctx, "UPDATE user_subscriptions SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id IN "+
+		"(SELECT id FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT $2) RETURNING id",
+		id, assigned-licenseCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := []int{}
+	for rows.Next() {
+		var seat int
+		if err := rows.Scan(&seat); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, seat)
+	}
+	sort.Ints(revoked)
+	return revoked, rows.Err()
+}
+
+func (s *postgresSubscriptions) Delete(ctx context.Context, id int) error {
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	result, err := s.db.ExecContext(ctx, "UPDATE subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL"+scope, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (s *postgresSubscriptions) Transition(ctx context.Context, id int, event models.SubscriptionEvent, now time.Time) (models.Subscription, error) {
+	var subscription models.Subscription
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return subscription, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent transitions are checked against the
+	// status the previous one left behind.
+	var current models.Subscription
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err = tx.QueryRowContext(ctx, "SELECT status, billing_interval, interval_days FROM subscriptions WHERE id = $1 AND deleted_at IS NULL"+scope+" FOR UPDATE", append([]interface{}{id}, args...)...).
+		Scan(&current.Status, &current.BillingInterval, &current.IntervalDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrNotFound
+	}
+	if err != nil {
+		return subscription, err
+	}
+
+	next, ok := current.Status.Next(event)
+	if !ok {
+		return subscription, fmt.Errorf("%w: cannot %s a subscription that is %s", ErrInvalidTransition, event, current.Status)
+	}
+
+	if restartsPeriod(current.Status, event) {
+		start := now.UTC()
+		err = scanSubscription(tx.QueryRowContext(ctx, "UPDATE subscriptions SET status = $1, current_period_start = $2, current_period_end = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 RETURNING "+subscriptionColumns,
+			next, start, current.PeriodEnd(start), id), &subscription)
+	} else {
+		err = scanSubscription(tx.QueryRowContext(ctx, "UPDATE subscriptions SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING "+subscriptionColumns, next, id), &subscription)
+	}
+	if err != nil {
+		return subscription, err
+	}
+	return subscription, tx.Commit()
+}
+
+func (s *postgresSubscriptions) ListForUser(ctx context.Context, userID int, productIDs []int) ([]models.Subscription, error) {
+	query := selectSubscriptions + " AND id IN (SELECT subscription_id FROM user_subscriptions WHERE user_id = $1 AND deleted_at IS NULL)"
+	args := []interface{}{userID}
+	if len(productIDs) > 0 {
+		list, ids := inList(productIDs, 2)
+		query += " AND product_id IN " + list
+		args = append(args, ids...)
+	}
+	scope, scopeArgs := scopeOrganization(ctx, "organization_id", len(args)+1)
+	query += scope
+	args = append(args, scopeArgs...)
+
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		var subscription models.Subscription
+		if err := scanSubscription(rows, &subscription); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (s *postgresSubscriptions) ListDue(ctx context.Context, now time.Time, limit int) ([]models.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, selectSubscriptions+" AND status IN ('trialing', 'active', 'past_due') AND current_period_end <= $1 ORDER BY current_period_end, id LIMIT $2", now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		var subscription models.Subscription
+		if err := scanSubscription(rows, &subscription); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (s *postgresSubscriptions) EndPeriod(ctx context.Context, id int, now time.Time) (models.Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	// The row lock makes concurrent workers renew each period exactly once:
+	// the loser sees the advanced period and gets ErrNotDue.
+	var subscription models.Subscription
+	err = scanSubscription(tx.QueryRowContext(ctx, selectSubscriptions+" AND id = $1 FOR UPDATE", id), &subscription)
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrNotFound
+	}
+	if err != nil {
+		return subscription, err
+	}
+	if !subscription.PeriodEnded(now) {
+		return subscription, ErrNotDue
+	}
+
+	next, renewed, err := subscription.EndPeriod()
+	if err != nil {
+		return subscription, err
+	}
+
+	// A change scheduled for the end of the period starts with the new one;
+	// if the subscription expires instead, it never takes effect.
+	if renewed {
+		var changeID int
+		err = tx.QueryRowContext(ctx, "SELECT id, to_product_id, to_license_count FROM plan_changes WHERE subscription_id = $1 AND status = 'scheduled' FOR UPDATE", id).
+			Scan(&changeID, &next.ProductID, &next.LicenseCount)
+		switch {
+		case err == nil:
+			_, err = tx.ExecContext(ctx, "UPDATE plan_changes SET status = 'applied', applied_at = $1 WHERE id = $2", now.UTC(), changeID)
+			if err != nil {
+				return subscription, err
+			}
+			// Seats assigned since the change was scheduled must not
+			// leave the new period oversubscribed
+			if _, err := fitSeats(ctx, tx, id, next.LicenseCount, SeatPolicyRevoke); err != nil {
+				return subscription, err
+			}
+		case !errors.Is(err, sql.ErrNoRows):
+			return subscription, err
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE plan_changes SET status = 'canceled' WHERE subscription_id = $1 AND status = 'scheduled'", id)
+		if err != nil {
+			return subscription, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE subscriptions SET status = $1, current_period_start = $2, current_period_end = $3, product_id = $4, license_count = $5, updated_at = CURRENT_TIMESTAMP WHERE id = $6",
+		next.Status, next.CurrentPeriodStart, next.CurrentPeriodEnd, next.ProductID, next.LicenseCount, id)
+	if err != nil {
+		return subscription, err
+	}
+	if renewed {
+		_, err = tx.ExecContext(ctx, "INSERT INTO subscription_renewals (subscription_id, period_start, period_end) VALUES ($1, $2, $3)",
+			id, next.CurrentPeriodStart, next.CurrentPeriodEnd)
+		if err != nil {
+			return subscription, err
+		}
+	}
+	return next, tx.Commit()
+}
+
+func (s *postgresSubscriptions) Renewals(ctx context.Context, subscriptionID int) ([]models.Renewal, error) {
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	rows, err := s.db.QueryContext(ctx, "SELECT id, subscription_id, period_start, period_end, created_at FROM subscription_renewals WHERE subscription_id = $1"+scope+" ORDER BY id",
+		append([]interface{}{subscriptionID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renewals := []models.Renewal{}
+	for rows.Next() {
+		var renewal models.Renewal
+		if err := rows.Scan(&renewal.ID, &renewal.SubscriptionID, &renewal.PeriodStart, &renewal.PeriodEnd, &renewal.CreatedAt); err != nil {
+			return nil, err
+		}
+		renewals = append(renewals, renewal)
+	}
+	return renewals, rows.Err()
+}
+
+func (s *postgresSubscriptions) ChangePlan(ctx context.Context, change *models.PlanChange, policy SeatPolicy, now time.Time) ([]int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var subscription models.Subscription
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err = tx.QueryRowContext(ctx, "SELECT product_id, license_count, status, current_period_end FROM subscriptions WHERE id = $1 AND deleted_at IS NULL"+scope+" FOR UPDATE",
+		append([]interface{}{change.SubscriptionID}, args...)...).
+		Scan(&subscription.ProductID, &subscription.LicenseCount, &subscription.Status, &subscription.CurrentPeriodEnd)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := preparePlanChange(subscription, change, now); err != nil {
+		return nil, err
+	}
+	// Scheduled changes are checked too so a downgrade below the assigned
+	// seats is refused up front rather than found at renewal
+	var revoked []int
+	if change.ToLicenseCount < subscription.LicenseCount && (change.Timing == models.ChangeImmediately || policy != SeatPolicyRevoke) {
+		revoked, err = fitSeats(ctx, tx, change.SubscriptionID, change.ToLicenseCount, policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if change.Timing == models.ChangeImmediately {
+		_, err = tx.ExecContext(ctx, "UPDATE subscriptions SET product_id = $1, license_count = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+			change.ToProductID, change.ToLicenseCount, change.SubscriptionID)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE plan_changes SET status = 'canceled' WHERE subscription_id = $1 AND status = 'scheduled'", change.SubscriptionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO plan_changes (subscription_id, from_product_id, from_license_count, to_product_id, to_license_count, timing, status, proration, effective_at, applied_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at",
+		change.SubscriptionID, change.FromProductID, change.FromLicenseCount, change.ToProductID, change.ToLicenseCount,
+		change.Timing, change.Status, change.Proration, change.EffectiveAt, change.AppliedAt,
+	).Scan(&change.ID, &change.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return revoked, tx.Commit()
+}
+
+func (s *postgresSubscriptions) PlanChanges(ctx context.Context, subscriptionID int) ([]models.PlanChange, error) {
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	return queryPlanChanges(ctx, s.db, "SELECT "+planChangeColumns+" FROM plan_changes WHERE subscription_id = $1"+scope+" ORDER BY id", append([]interface{}{subscriptionID}, args...)...)
+}
+
+const planChangeColumns = "id, subscription_id, from_product_id, from_license_count, to_product_id, to_license_count, timing, status, proration, effective_at, created_at, applied_at"
+
+// queryPlanChanges runs a query selecting planChangeColumns.
+func queryPlanChanges(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.PlanChange, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.PlanChange{}
+	for rows.Next() {
+		var change models.PlanChange
+		err := rows.Scan(&change.ID, &change.SubscriptionID, &change.FromProductID, &change.FromLicenseCount, &change.ToProductID, &change.ToLicenseCount,
+			&change.Timing, &change.Status, &change.Proration, &change.EffectiveAt, &change.CreatedAt, &change.AppliedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+type postgresUserSubscriptions struct {
+	db *sql.DB
+}
+
+const selectUserSubscriptions = `
+	SELECT
+		us.id, us.user_id, us.subscription_id, us.created_at, us.updated_at, us.deleted_at,
+		s.name, s.product_id
+	FROM
+		user_subscriptions us
+	JOIN
+		subscriptions s ON us.subscription_id = s.id
+	WHERE
+		us.deleted_at IS NULL`
+
+func scanUserSubscription(row interface{ Scan(...interface{}) error }, userSubscription *models.UserSubscription) error {
+	return row.Scan(
+		&userSubscription.ID, &userSubscription.UserID, &userSubscription.SubscriptionID,
+		&userSubscription.CreatedAt, &userSubscription.UpdatedAt, &userSubscription.DeletedAt,
+		&userSubscription.SubscriptionName, &userSubscription.ProductID)
+}
+
+func (s *postgresUserSubscriptions) List(ctx context.Context, filter UserSubscriptionFilter, req PageRequest) (Page[models.UserSubscription], error) {
+	ks, err := newKeyset(req, userSubscriptionSortKeys)
+	if err != nil {
+		return Page[models.UserSubscription]{}, err
+	}
+
+	var q conditions
+	if organizationID := OrganizationFrom(ctx); organizationID != 0 {
+		q.add("s.organization_id = ?", organizationID)
+	}
+	if filter.UserID != 0 {
+		q.add("us.user_id = ?", filter.UserID)
+	}
+	if filter.SubscriptionID != 0 {
+		q.add("us.subscription_id = ?", filter.SubscriptionID)
+	}
+	if filter.ProductID != 0 {
+		q.add("s.product_id = ?", filter.ProductID)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		q.add("us.created_at > ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		q.add("us.created_at < ?", filter.CreatedBefore)
+	}
+
+	var total *int
+	if req.IncludeTotal {
+		var n int
+		countQuery := "SELECT COUNT(*) FROM user_subscriptions us JOIN subscriptions s ON us.subscription_id = s.id WHERE us.deleted_at IS NULL"
+		if err := s.db.QueryRowContext(ctx, countQuery+q.sql(), q.args...).Scan(&n); err != nil {
+			return Page[models.UserSubscription]{}, err
+		}
+		total = &n
+	}
+
+	cond, args := ks.where("us.id", len(q.args)+1)
+	if cond != "" {
+		q.conds = append(q.conds, cond)
+		q.args = append(q.args, args...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, selectUserSubscriptions+q.sql()+ks.orderBy("us.id"), q.args...)
+	if err != nil {
+		return Page[models.UserSubscription]{}, err
+	}
+	defer rows.Close()
+
+	userSubscriptions := []models.UserSubscription{}
+	for rows.Next() {
+		var userSubscription models.UserSubscription
+		if err := scanUserSubscription(rows, &userSubscription); err != nil {
+			return Page[models.UserSubscription]{}, err
+		}
+		userSubscriptions = append(userSubscriptions, userSubscription)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[models.UserSubscription]{}, err
+	}
+
+	page := ks.page(userSubscriptions, func(us models.UserSubscription) int { return us.ID })
+	page.Total = total
+	return page, nil
+}
+
+func (s *postgresUserSubscriptions) Get(ctx context.Context, id int) (models.UserSubscription, error) {
+	var userSubscription models.UserSubscription
+	scope, args := scopeOrganization(ctx, "s.organization_id", 2)
+	err := scanUserSubscription(s.db.QueryRowContext(ctx, selectUserSubscriptions+" AND us.id = $1"+scope, append([]interface{}{id}, args...)...), &userSubscription)
+	if errors.Is(err, sql.ErrNoRows) {
+		return userSubscription, ErrNotFound
+	}
+	return userSubscription, err
+}
+
+func (s *postgresUserSubscriptions) Create(ctx context.Context, userSubscription *models.UserSubscription) error {
+	// Seat allocation runs in a single transaction so concurrent requests
+	// cannot both pass the license check and oversell the subscription.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := allocateSeat(ctx, tx, userSubscription.UserID, userSubscription.SubscriptionID, true); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO user_subscriptions (user_id, subscription_id, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) RETURNING id",
+		userSubscription.UserID, userSubscription.SubscriptionID,
+	).Scan(&userSubscription.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// allocateSeat checks, within tx, that userID may take a seat on the
+// subscription: that it exists, that it has a free seat unless checkLimit
+// is false, and that a trial seat is the user's only trial of the product.
+func allocateSeat(ctx context.Context, tx *sql.Tx, userID, subscriptionID int, checkLimit bool) error {
+	// Check if the subscription exists and get its seat limits.
+	// FOR UPDATE locks the subscription row until commit, serializing
+	// every allocation against the same subscription.
+	var subscription models.Subscription
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err := tx.QueryRowContext(ctx, "SELECT license_count, status, trial_seat_limit, product_id FROM subscriptions WHERE id = $1 AND deleted_at IS NULL"+scope+" FOR UPDATE",
+		append([]interface{}{subscriptionID}, args...)...).
+		Scan(&subscription.LicenseCount, &subscription.Status, &subscription.TrialSeatLimit, &subscription.ProductID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !subscription.Status.Seatable() {
+		return fmt.Errorf("%w: subscription is %s", ErrSubscriptionNotSeatable, subscription.Status)
+	}
+
+	if checkLimit {
+		// Count how many user subscriptions currently exist for this subscription
+		var currentSubscriptions int
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_subscriptions WHERE subscription_id = $1 AND deleted_at IS NULL", subscriptionID).Scan(&currentSubscriptions)
+		if err != nil {
+			return err
+		}
+		if currentSubscriptions >= subscription.SeatLimit() {
+			return ErrNoLicensesAvailable
+		}
+	}
+
+	// A trial seat claims the user's one trial of the product. The claim is
+	// kept when the seat is removed, so reassigning the same trial is fine
+	// but a trial through another subscription is not.
+	if subscription.Status == models.StatusTrialing {
+		_, err = tx.ExecContext(ctx, "INSERT INTO trial_claims (user_id, product_id, subscription_id) VALUES ($1, $2, $3) ON CONFLICT (user_id, product_id) DO NOTHING",
+			userID, subscription.ProductID, subscriptionID)
+		if err != nil {
+			return err
+		}
+		var claimedBy int
+		err = tx.QueryRowContext(ctx, "SELECT subscription_id FROM trial_claims WHERE user_id = $1 AND product_id = $2", userID, subscription.ProductID).Scan(&claimedBy)
+		if err != nil {
+			return err
+		}
+		if claimedBy != subscriptionID {
+			return ErrTrialAlreadyUsed
+		}
+	}
+	return nil
+}
+
+func (s *postgresUserSubscriptions) Update(ctx context.Context, id int, userSubscription *models.UserSubscription) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Subscriptions are locked before their seats, like allocateSeat and
+	// ChangePlan do, so the seat is read first and locked once both the
+	// subscription it leaves and the one it joins are.
+	var current int
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	seatQuery := "SELECT subscription_id FROM user_subscriptions WHERE id = $1 AND deleted_at IS NULL" + scope
+	err = tx.QueryRowContext(ctx, seatQuery, append([]interface{}{id}, args...)...).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := lockSubscriptions(ctx, tx, current, userSubscription.SubscriptionID); err != nil {
+		return err
+	}
+	// The seat may have moved before the locks were taken
+	err = tx.QueryRowContext(ctx, seatQuery+" FOR UPDATE", append([]interface{}{id}, args...)...).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// The seat is checked like a new one; it only needs a free seat when it
+	// moves to another subscription
+	err = allocateSeat(ctx, tx, userSubscription.UserID, userSubscription.SubscriptionID, userSubscription.SubscriptionID != current)
+	if errors.Is(err, ErrNotFound) {
+		return ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE user_subscriptions SET user_id = $1, subscription_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+		userSubscription.UserID, userSubscription.SubscriptionID, id)
+	if err != nil {
+		return err
+	}
+	userSubscription.ID = id
+	return tx.Commit()
+}
+
+// lockSubscriptions locks the rows of the subscriptions ids within tx, in
+// id order so that two transactions locking the same pair cannot deadlock.
+func lockSubscriptions(ctx context.Context, tx *sql.Tx, ids ...int) error {
+	sort.Ints(ids)
+	unique := ids[:0]
+	for _, id := range ids {
+		if len(unique) == 0 || unique[len(unique)-1] != id {
+			unique = append(unique, id)
+		}
+	}
+	list, args := inList(unique, 1)
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM subscriptions WHERE id IN "+list+" ORDER BY id FOR UPDATE", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func (s *postgresUserSubscriptions) Delete(ctx context.Context, id int) error {
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	result, err := s.db.ExecContext(ctx, "UPDATE user_subscriptions SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1"+scope, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (s *postgresUserSubscriptions) Assigned(ctx context.Context, subscriptionID int) ([]models.UserSubscription, error) {
+	scope, args := scopeOrganization(ctx, "s.organization_id", 2)
+	rows, err := s.db.QueryContext(ctx, selectUserSubscriptions+" AND us.subscription_id = $1"+scope+" ORDER BY us.created_at, us.id", append([]interface{}{subscriptionID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userSubscriptions := []models.UserSubscription{}
+	for rows.Next() {
+		var userSubscription models.UserSubscription
+		if err := scanUserSubscription(rows, &userSubscription); err != nil {
+			return nil, err
+		}
+		userSubscriptions = append(userSubscriptions, userSubscription)
+	}
+	return userSubscriptions, rows.Err()
+}
+
+func (s *postgresUserSubscriptions) CountAssigned(ctx context.Context, subscriptionIDs []int) (map[int]int, error) {
+	counts := map[int]int{}
+	if len(subscriptionIDs) == 0 {
+		return counts, nil
+	}
+
+	list, args := inList(subscriptionIDs, 1)
+	scope, scopeArgs := scopeSubscription(ctx, "subscription_id", len(args)+1)
+	rows, err := s.db.QueryContext(ctx, "SELECT subscription_id, COUNT(*) FROM user_subscriptions WHERE deleted_at IS NULL AND subscription_id IN "+list+scope+" GROUP BY subscription_id", append(args, scopeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// startPeriod sets the initial status and starts the first period at now.
+// Trials start trialing and their first period lasts until the trial ends;
+// everything else starts active with a regular billing period.
+func startPeriod(subscription *models.Subscription, now time.Time) {
+	if subscription.BillingInterval == "" {
+		subscription.BillingInterval = models.IntervalMonthly
+	}
+	subscription.Status = models.StatusActive
+	subscription.CurrentPeriodStart = now.UTC()
+	subscription.CurrentPeriodEnd = subscription.PeriodEnd(subscription.CurrentPeriodStart)
+	if subscription.TrialEnd != nil {
+		trialEnd := subscription.TrialEnd.UTC()
+		subscription.Status = models.StatusTrialing
+		subscription.TrialEnd = &trialEnd
+		subscription.CurrentPeriodEnd = trialEnd
+	}
+}
+
+// restartsPeriod reports whether event, applied in status, resumes a paused
+// subscription. Its period then restarts rather than catching up on the
+// periods that ended while it was paused.
+func restartsPeriod(status models.SubscriptionStatus, event models.SubscriptionEvent) bool {
+	return status == models.StatusPaused && event == models.EventResume
+}
+
+// preparePlanChange checks change against the locked subscription and fills
+// in its status and effective time.
+func preparePlanChange(subscription models.Subscription, change *models.PlanChange, now time.Time) error {
+	if subscription.ProductID != change.FromProductID || subscription.LicenseCount != change.FromLicenseCount {
+		return ErrPlanChanged
+	}
+	if !subscription.Status.PlanChangeable() {
+		return fmt.Errorf("%w: cannot change the plan of a subscription that is %s", ErrInvalidTransition, subscription.Status)
+	}
+
+	if change.Timing == models.ChangeImmediately {
+		appliedAt := now.UTC()
+		change.Status = models.PlanChangeApplied
+		change.EffectiveAt = appliedAt
+		change.AppliedAt = &appliedAt
+		return nil
+	}
+	change.Status = models.PlanChangeScheduled
+	change.EffectiveAt = subscription.CurrentPeriodEnd
+	change.AppliedAt = nil
+	change.Proration = 0
+	return nil
+}
+
+// conditions accumulates AND-ed WHERE conditions and their arguments.
+type conditions struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends cond, replacing its single ? with the next $n placeholder.
+func (c *conditions) add(cond string, arg interface{}) {
+	c.args = append(c.args, arg)
+	c.conds = append(c.conds, strings.Replace(cond, "?", fmt.Sprintf("$%d", len(c.args)), 1))
+}
+
+// sql renders the conditions to append after an existing WHERE clause.
+func (c *conditions) sql() string {
+	if len(c.conds) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(c.conds, " AND ")
+}
+
+// inList renders ids as a parenthesized list of placeholders numbered from
+// first, for use with IN.
+func inList(ids []int, first int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", first+i)
+		args[i] = id
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// expectAffected turns an UPDATE that matched no rows into ErrNotFound.
+func expectAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
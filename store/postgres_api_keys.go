@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"subscriptions/models"
+	"time"
+)
+
+type postgresAPIKeys struct {
+	db *sql.DB
+}
+
+const apiKeyColumns = "id, name, prefix, key_hash, organization_id, roles, created_at, revoked_at"
+
+// scanAPIKey reads a key; roles are stored comma separated.
+func scanAPIKey(row interface{ Scan(...interface{}) error }, key *models.APIKey) error {
+	var roles string
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &key.OrganizationID, &roles, &key.CreatedAt, &key.RevokedAt)
+	key.Roles = []string{}
+	if roles != "" {
+		key.Roles = strings.Split(roles, ",")
+	}
+	return err
+}
+
+func (s *postgresAPIKeys) Create(ctx context.Context, key *models.APIKey) error {
+	if organizationID := OrganizationFrom(ctx); organizationID != 0 {
+		key.OrganizationID = &organizationID
+	}
+	return s.db.QueryRowContext(ctx,
+		"INSERT INTO api_keys (name, prefix, key_hash, organization_id, roles) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		key.Name, key.Prefix, key.Hash, key.OrganizationID, strings.Join(key.Roles, ","),
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (s *postgresAPIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	scope, args := scopeOrganization(ctx, "organization_id", 1)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE TRUE"+scope+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresAPIKeys) FindByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err := scanAPIKey(s.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL"+scope,
+		append([]interface{}{hash}, args...)...), &key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *postgresAPIKeys) Revoke(ctx context.Context, id int, now time.Time) (models.APIKey, error) {
+	var key models.APIKey
+	scope, args := scopeOrganization(ctx, "organization_id", 3)
+	err := scanAPIKey(s.db.QueryRowContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1"+scope+" RETURNING "+apiKeyColumns,
+		append([]interface{}{id, now}, args...)...), &key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNotFound
+	}
+	return key, err
+}
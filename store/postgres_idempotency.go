@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type postgresIdempotency struct {
+	db *sql.DB
+}
+
+// idempotencyClaimAttempts bounds how often Begin retries a claim whose
+// conflicting record was purged before it could be read.
+const idempotencyClaimAttempts = 3
+
+func (s *postgresIdempotency) Begin(ctx context.Context, record IdempotencyRecord, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	for attempt := 0; ; attempt++ {
+		existing, claimed, err := s.claim(ctx, record, staleBefore)
+		if errors.Is(err, ErrNotFound) && attempt+1 < idempotencyClaimAttempts {
+			// Purged in between; the next attempt claims it
+			continue
+		}
+		return existing, claimed, err
+	}
+}
+
+func (s *postgresIdempotency) claim(ctx context.Context, record IdempotencyRecord, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	// Claims the key unless a live record holds it, in one statement so
+	// concurrent retries cannot both run
+	var scope string
+	err := s.db.QueryRowContext(ctx,
+		"INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (scope, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status_code = NULL, content_type = NULL, body = NULL, "+
+			"created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at "+
+			"WHERE idempotency_keys.expires_at <= EXCLUDED.created_at OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $6) "+
+			"RETURNING scope",
+		record.Scope, record.Key, record.Fingerprint, record.CreatedAt.UTC(), record.ExpiresAt.UTC(), staleBefore.UTC(),
+	).Scan(&scope)
+	if err == nil {
+		return IdempotencyRecord{}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return IdempotencyRecord{}, false, err
+	}
+
+	existing := IdempotencyRecord{Scope: record.Scope, Key: record.Key}
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	err = s.db.QueryRowContext(ctx,
+		"SELECT fingerprint, status_code, content_type, body, created_at, expires_at FROM idempotency_keys WHERE scope = $1 AND key = $2",
+		record.Scope, record.Key,
+	).Scan(&existing.Fingerprint, &statusCode, &contentType, &existing.Body, &existing.CreatedAt, &existing.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IdempotencyRecord{}, false, ErrNotFound
+	}
+	existing.StatusCode = int(statusCode.Int64)
+	existing.ContentType = contentType.String
+	return existing, false, err
+}
+
+func (s *postgresIdempotency) Complete(ctx context.Context, scope, key string, claimedAt time.Time, statusCode int, contentType string, body []byte) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = $4, content_type = $5, body = $6 WHERE scope = $1 AND key = $2 AND created_at = $3",
+		scope, key, claimedAt.UTC(), statusCode, contentType, body)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresIdempotency) Release(ctx context.Context, scope, key string, claimedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND created_at = $3 AND status_code IS NULL", scope, key, claimedAt.UTC())
+	return err
+}
+
+func (s *postgresIdempotency) Purge(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"subscriptions/models"
+	"time"
+)
+
+type postgresInvoices struct {
+	db *sql.DB
+}
+
+const invoiceColumns = "id, number, subscription_id, status, period_start, period_end, subtotal, total, created_at, issued_at, paid_at, voided_at"
+
+const invoiceLineColumns = "l.id, l.invoice_id, l.kind, l.description, l.quantity, l.unit_price, l.amount, l.plan_change_id"
+
+func scanInvoice(row interface{ Scan(...interface{}) error }, invoice *models.Invoice) error {
+	var number sql.NullString
+	err := row.Scan(&invoice.ID, &number, &invoice.SubscriptionID, &invoice.Status, &invoice.PeriodStart, &invoice.PeriodEnd,
+		&invoice.Subtotal, &invoice.Total, &invoice.CreatedAt, &invoice.IssuedAt, &invoice.PaidAt, &invoice.VoidedAt)
+	invoice.Number = number.String
+	return err
+}
+
+func (s *postgresInvoices) Create(ctx context.Context, invoice *models.Invoice) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	scope, args := scopeOrganization(ctx, "organization_id", 2)
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM subscriptions WHERE id = $1 AND deleted_at IS NULL"+scope+")", append([]interface{}{invoice.SubscriptionID}, args...)...).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	invoice.Status = models.InvoiceDraft
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO invoices (subscription_id, status, period_start, period_end, subtotal, total) VALUES ($1, $2, $3, $4, $5, $6) "+
+			"ON CONFLICT (subscription_id, period_start) WHERE status <> 'void' DO NOTHING RETURNING id, created_at",
+		invoice.SubscriptionID, invoice.Status, invoice.PeriodStart, invoice.PeriodEnd, invoice.Subtotal, invoice.Total,
+	).Scan(&invoice.ID, &invoice.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvoiceExists
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO invoice_lines (invoice_id, kind, description, quantity, unit_price, amount, plan_change_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+			invoice.ID, line.Kind, line.Description, line.Quantity, line.UnitPrice, line.Amount, line.PlanChangeID,
+		).Scan(&line.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresInvoices) Get(ctx context.Context, id int) (models.Invoice, error) {
+	var invoice models.Invoice
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	err := scanInvoice(s.db.QueryRowContext(ctx, "SELECT "+invoiceColumns+" FROM invoices WHERE id = $1"+scope, append([]interface{}{id}, args...)...), &invoice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return invoice, ErrNotFound
+	}
+	if err != nil {
+		return invoice, err
+	}
+
+	lines, err := s.lines(ctx, "l.invoice_id = $1", id)
+	if err != nil {
+		return invoice, err
+	}
+	invoice.Lines = lines[id]
+	if invoice.Lines == nil {
+		invoice.Lines = []models.InvoiceLine{}
+	}
+	return invoice, nil
+}
+
+func (s *postgresInvoices) ListBySubscription(ctx context.Context, subscriptionID int) ([]models.Invoice, error) {
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+invoiceColumns+" FROM invoices WHERE subscription_id = $1"+scope+" ORDER BY id", append([]interface{}{subscriptionID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []models.Invoice{}
+	for rows.Next() {
+		var invoice models.Invoice
+		if err := scanInvoice(rows, &invoice); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lines, err := s.lines(ctx, "i.subscription_id = $1", subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range invoices {
+		invoices[i].Lines = lines[invoices[i].ID]
+		if invoices[i].Lines == nil {
+			invoices[i].Lines = []models.InvoiceLine{}
+		}
+	}
+	return invoices, nil
+}
+
+// lines loads the lines of the invoices matching cond, keyed by invoice id.
+func (s *postgresInvoices) lines(ctx context.Context, cond string, arg interface{}) (map[int][]models.InvoiceLine, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+invoiceLineColumns+" FROM invoice_lines l JOIN invoices i ON i.id = l.invoice_id WHERE "+cond+" ORDER BY l.id", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := map[int][]models.InvoiceLine{}
+	for rows.Next() {
+		var line models.InvoiceLine
+		var invoiceID int
+		err := rows.Scan(&line.ID, &invoiceID, &line.Kind, &line.Description, &line.Quantity, &line.UnitPrice, &line.Amount, &line.PlanChangeID)
+		if err != nil {
+			return nil, err
+		}
+		lines[invoiceID] = append(lines[invoiceID], line)
+	}
+	return lines, rows.Err()
+}
+
+func (s *postgresInvoices) Transition(ctx context.Context, id int, status models.InvoiceStatus, now time.Time) (models.Invoice, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Invoice{}, err
+	}
+	defer tx.Rollback()
+
+	var invoice models.Invoice
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	err = scanInvoice(tx.QueryRowContext(ctx, "SELECT "+invoiceColumns+" FROM invoices WHERE id = $1"+scope+" FOR UPDATE", append([]interface{}{id}, args...)...), &invoice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return invoice, ErrNotFound
+	}
+	if err != nil {
+		return invoice, err
+	}
+
+	err = transitionInvoice(&invoice, status, now, func() (int, error) {
+		var n int
+		err := tx.QueryRowContext(ctx, "SELECT nextval('invoice_number_seq')").Scan(&n)
+		return n, err
+	})
+	if err != nil {
+		return invoice, err
+	}
+
+	var number sql.NullString
+	if invoice.Number != "" {
+		number = sql.NullString{String: invoice.Number, Valid: true}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE invoices SET status = $1, number = $2, issued_at = $3, paid_at = $4, voided_at = $5 WHERE id = $6",
+		invoice.Status, number, invoice.IssuedAt, invoice.PaidAt, invoice.VoidedAt, id)
+	if err != nil {
+		return invoice, err
+	}
+	if err := tx.Commit(); err != nil {
+		return invoice, err
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *postgresInvoices) UnbilledPlanChanges(ctx context.Context, subscriptionID int) ([]models.PlanChange, error) {
+	scope, args := scopeSubscription(ctx, "subscription_id", 2)
+	return queryPlanChanges(ctx, s.db, "SELECT "+planChangeColumns+" FROM plan_changes pc WHERE subscription_id = $1"+scope+" AND status = 'applied' AND proration <> 0 "+
+		"AND NOT EXISTS (SELECT 1 FROM invoice_lines l JOIN invoices i ON i.id = l.invoice_id WHERE l.plan_change_id = pc.id AND i.status <> 'void') ORDER BY id",
+		append([]interface{}{subscriptionID}, args...)...)
+}
+
+// transitionInvoice moves invoice to status at now. A number is only drawn
+// when a draft is finalized, so voided drafts never use one up.
+func transitionInvoice(invoice *models.Invoice, status models.InvoiceStatus, now time.Time, nextNumber func() (int, error)) error {
+	if !invoice.Status.CanBecome(status) {
+		return fmt.Errorf("%w: cannot move a %s invoice to %s", ErrInvalidTransition, invoice.Status, status)
+	}
+
+	at := now.UTC()
+	switch status {
+	case models.InvoiceOpen:
+		n, err := nextNumber()
+		if err != nil {
+			return err
+		}
+		invoice.Number = fmt.Sprintf("INV-%06d", n)
+		invoice.IssuedAt = &at
+	case models.InvoicePaid:
+		invoice.PaidAt = &at
+	case models.InvoiceVoid:
+		invoice.VoidedAt = &at
+	}
+	invoice.Status = status
+	return nil
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// organizationKey is the context key of the organization scope.
+type organizationKey struct{}
+
+// WithOrganization scopes the store calls made with the returned context to
+// one organization: subscriptions of other organizations, and their seats,
+// renewals, plan changes and invoices, are left out of lists and reported as
+// ErrNotFound, as if they did not exist.
+func WithOrganization(ctx context.Context, organizationID int) context.Context {
+	return context.WithValue(ctx, organizationKey{}, organizationID)
+}
+
+// OrganizationFrom returns the organization ctx is scoped to, or 0 when it
+// is not scoped.
+func OrganizationFrom(ctx context.Context) int {
+	id, _ := ctx.Value(organizationKey{}).(int)
+	return id
+}
+
+// scopeOrganization renders the condition limiting column, an organization
+// id, to the organization ctx is scoped to, with its placeholder numbered n.
+// It is empty when ctx is not scoped.
+func scopeOrganization(ctx context.Context, column string, n int) (string, []interface{}) {
+	id := OrganizationFrom(ctx)
+	if id == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(" AND %s = $%d", column, n), []interface{}{id}
+}
+
+// scopeSubscription is scopeOrganization for a column holding a subscription id.
+func scopeSubscription(ctx context.Context, column string, n int) (string, []interface{}) {
+	id := OrganizationFrom(ctx)
+	if id == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(" AND %s IN (SELECT id FROM subscriptions WHERE organization_id = $%d)", column, n), []interface{}{id}
+}
@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"subscriptions/models"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist or has been soft-deleted.
+	ErrNotFound = errors.New("not found")
+	// ErrOrganizationRequired is returned when a subscription is created
+	// without an organization, or for one that does not exist.
+	ErrOrganizationRequired = errors.New("subscription needs an existing organization")
+	// ErrSubscriptionNotFound is returned when a seat is moved to a
+	// subscription that does not exist.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrSubscriptionNotSeatable is returned when a seat is assigned on a
+	// subscription that is paused, canceled or expired.
+	ErrSubscriptionNotSeatable = errors.New("seats can only be assigned on trialing, active or past_due subscriptions")
+	// ErrNoLicensesAvailable is returned when every seat of a subscription is already assigned.
+	ErrNoLicensesAvailable = errors.New("no licenses available")
+	// ErrInvalidTransition is returned when the transition table does not allow
+	// an event in the subscription's current status.
+	ErrInvalidTransition = errors.New("invalid status transition")
+	// ErrNotDue is returned by EndPeriod when the current period has not
+	// ended, typically because another worker already renewed it.
+	ErrNotDue = errors.New("billing period has not ended")
+	// ErrTrialAlreadyUsed is returned when a user is given a trial seat on a
+	// product they already trialed through another subscription.
+	ErrTrialAlreadyUsed = errors.New("trial already used")
+	// ErrPlanChanged is returned by ChangePlan when the subscription is no
+	// longer on the plan the change was computed from.
+	ErrPlanChanged = errors.New("subscription plan changed concurrently")
+	// ErrInvoiceExists is returned when a billing period already has an
+	// invoice that is not void.
+	ErrInvoiceExists = errors.New("billing period already invoiced")
+	// ErrPlanChangeRequired is returned when an update would change the
+	// product or license_count, which must go through ChangePlan.
+	ErrPlanChangeRequired = errors.New("product_id and license_count can only be changed with change_plan")
+	// ErrSeatsAssigned is returned when license_count would drop below the
+	// number of seats assigned on the subscription.
+	ErrSeatsAssigned = errors.New("license_count is below the assigned seats")
+)
+
+// SeatOverageError reports a license_count that would leave more seats
+// assigned than licensed. It wraps ErrSeatsAssigned.
+type SeatOverageError struct {
+	LicenseCount int
+	Assigned     int
+}
+
+// Overage is how many assigned seats exceed LicenseCount.
+func (e *SeatOverageError) Overage() int {
+	return e.Assigned - e.LicenseCount
+}
+
+func (e *SeatOverageError) Error() string {
+	return fmt.Sprintf("%v: %d seats assigned, license_count %d is %d short", ErrSeatsAssigned, e.Assigned, e.LicenseCount, e.Overage())
+}
+
+func (e *SeatOverageError) Unwrap() error {
+	return ErrSeatsAssigned
+}
+
+// SeatPolicy decides what happens to assigned seats when a plan change
+// lowers license_count below their number.
+type SeatPolicy string
+
+const (
+	// SeatPolicyReject fails the change with a *SeatOverageError.
+	SeatPolicyReject SeatPolicy = "reject"
+	// SeatPolicyRevoke revokes the most recently assigned seats until the
+	// rest fit into the new license_count.
+	SeatPolicyRevoke SeatPolicy = "revoke"
+)
+
+// Store groups the repositories the HTTP API is built on. Their methods
+// honour the organization scope set with WithOrganization unless documented
+// otherwise.
+type Store interface {
+	Organizations() OrganizationStore
+	APIKeys() APIKeyStore
+	Subscriptions() SubscriptionStore
+	UserSubscriptions() UserSubscriptionStore
+	Invoices() InvoiceStore
+	IdempotencyKeys() IdempotencyStore
+}
+
+// OrganizationStore persists organizations. A scoped context only sees its
+// own organization.
+type OrganizationStore interface {
+	Create(ctx context.Context, organization *models.Organization) error
+	Get(ctx context.Context, id int) (models.Organization, error)
+}
+
+// APIKeyStore persists the API keys services authenticate with. A scoped
+// context only sees the keys of its organization.
+type APIKeyStore interface {
+	// Create stores a key. Under an organization scope it belongs to that
+	// organization, whatever its OrganizationID said.
+	Create(ctx context.Context, key *models.APIKey) error
+	// List lists the keys, revoked ones included, oldest first.
+	List(ctx context.Context) ([]models.APIKey, error)
+	// FindByHash returns the key whose secret has hash, failing with
+	// ErrNotFound when there is none or it was revoked.
+	FindByHash(ctx context.Context, hash string) (models.APIKey, error)
+	// Revoke revokes a key at now. Revoking it again keeps the first time.
+	Revoke(ctx context.Context, id int, now time.Time) (models.APIKey, error)
+}
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once it
+// completed, its response.
+type IdempotencyRecord struct {
+	// Scope is the caller the key belongs to; keys of different callers never collide
+	Scope       string
+	Key         string
+	Fingerprint string
+	// StatusCode is 0 while the request is in progress
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore remembers the responses of requests made with an
+// Idempotency-Key. Records are keyed by caller, so it ignores the
+// organization scope.
+type IdempotencyStore interface {
+	// Begin claims record.Key for record.Scope until record.ExpiresAt. When
+	// another live record holds the key it is returned with claimed false;
+	// records still in progress that began before staleBefore are presumed
+	// abandoned and claimed over.
+	Begin(ctx context.Context, record IdempotencyRecord, staleBefore time.Time) (existing IdempotencyRecord, claimed bool, err error)
+	// Complete stores the response of a claimed record. claimedAt is the
+	// CreatedAt of the claim: once another request has claimed the key over,
+	// Complete leaves its record alone and fails with ErrNotFound.
+	Complete(ctx context.Context, scope, key string, claimedAt time.Time, statusCode int, contentType string, body []byte) error
+	// Release forgets a claimed record, so the request may be retried. Like
+	// Complete it only touches the claim made at claimedAt.
+	Release(ctx context.Context, scope, key string, claimedAt time.Time) error
+	// Purge deletes the records that expired at or before now.
+	Purge(ctx context.Context, now time.Time) (int, error)
+}
+
+// SubscriptionFilter narrows the subscriptions returned by List.
+// Zero values mean "no filter".
+type SubscriptionFilter struct {
+	OrganizationID int
+	ProductID      int
+	NamePrefix     string
+	Status         models.SubscriptionStatus
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+}
+
+// SubscriptionStore persists subscriptions.
+type SubscriptionStore interface {
+	List(ctx context.Context, filter SubscriptionFilter, page PageRequest) (Page[models.Subscription], error)
+	Get(ctx context.Context, id int) (models.Subscription, error)
+	// Create stores a new subscription. Under an organization scope it
+	// belongs to that organization, whatever its OrganizationID said; it
+	// fails with ErrOrganizationRequired when that organization is missing.
+	Create(ctx context.Context, subscription *models.Subscription) error
+	// Update renames a subscription. Its product and license_count only
+	// change through ChangePlan: a non-zero product_id or license_count
+	// other than the current one fails with ErrPlanChangeRequired.
+	Update(ctx context.Context, id int, subscription *models.Subscription) error
+	Delete(ctx context.Context, id int) error
+	// Transition applies event to the subscription's status atomically and
+	// returns the updated subscription. It fails with ErrInvalidTransition
+	// when the event is not allowed in the current status. Resuming a paused
+	// subscription restarts its billing period at now, so the paused time is
+	// neither renewed nor invoiced.
+	Transition(ctx context.Context, id int, event models.SubscriptionEvent, now time.Time) (models.Subscription, error)
+	// ListForUser returns the subscriptions the user holds a seat on, limited
+	// to productIDs unless it is empty.
+	ListForUser(ctx context.Context, userID int, productIDs []int) ([]models.Subscription, error)
+	// ListDue returns up to limit subscriptions whose current period ended
+	// at or before now, oldest first. It and EndPeriod are for the renewal
+	// worker and ignore the organization scope.
+	ListDue(ctx context.Context, now time.Time, limit int) ([]models.Subscription, error)
+	// EndPeriod renews or expires a subscription whose period ended at or
+	// before now, recording a Renewal and applying any scheduled plan change
+	// when it renews. Seats beyond the new license_count, assigned after the
+	// change was scheduled, are revoked most recent first.
+	EndPeriod(ctx context.Context, id int, now time.Time) (models.Subscription, error)
+	// Renewals lists the renewals recorded for a subscription, oldest first.
+	Renewals(ctx context.Context, subscriptionID int) ([]models.Renewal, error)
+	// ChangePlan records change and applies it at once or, for changes at
+	// period end, schedules it in place of any earlier scheduled change.
+	// Canceled and expired subscriptions fail with ErrInvalidTransition.
+	// Changes to fewer seats than are assigned are handled by policy, in the
+	// same transaction; revoked lists the user subscriptions revoked. A
+	// scheduled change revokes seats only when it applies.
+	ChangePlan(ctx context.Context, change *models.PlanChange, policy SeatPolicy, now time.Time) (revoked []int, err error)
+	// PlanChanges lists the plan changes of a subscription, oldest first.
+	PlanChanges(ctx context.Context, subscriptionID int) ([]models.PlanChange, error)
+}
+
+// UserSubscriptionFilter narrows the user subscriptions returned by List.
+// Zero values mean "no filter".
+type UserSubscriptionFilter struct {
+	UserID         int
+	SubscriptionID int
+	ProductID      int
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+}
+
+// subscriptionSortKeys are the fields subscription lists may be sorted by.
+var subscriptionSortKeys = map[string]sortKey[models.Subscription]{
+	"id":            {column: "id", kind: kindInt, value: func(s models.Subscription) interface{} { return s.ID }},
+	"name":          {column: "name", kind: kindString, value: func(s models.Subscription) interface{} { return s.Name }},
+	"license_count": {column: "license_count", kind: kindInt, value: func(s models.Subscription) interface{} { return s.LicenseCount }},
+	"status":        {column: "status", kind: kindString, value: func(s models.Subscription) interface{} { return string(s.Status) }},
+	"created_at":    {column: "created_at", kind: kindTime, value: func(s models.Subscription) interface{} { return s.CreatedAt }},
+	"updated_at":    {column: "updated_at", kind: kindTime, value: func(s models.Subscription) interface{} { return s.UpdatedAt }},
+}
+
+// userSubscriptionSortKeys are the fields user subscription lists may be sorted by.
+var userSubscriptionSortKeys = map[string]sortKey[models.UserSubscription]{
+	"id":              {column: "us.id", kind: kindInt, value: func(us models.UserSubscription) interface{} { return us.ID }},
+	"user_id":         {column: "us.user_id", kind: kindInt, value: func(us models.UserSubscription) interface{} { return us.UserID }},
+	"subscription_id": {column: "us.subscription_id", kind: kindInt, value: func(us models.UserSubscription) interface{} { return us.SubscriptionID }},
+	"created_at":      {column: "us.created_at", kind: kindTime, value: func(us models.UserSubscription) interface{} { return us.CreatedAt }},
+}
+
+// UserSubscriptionStore persists the seats assigned to users on a subscription.
+type UserSubscriptionStore interface {
+	List(ctx context.Context, filter UserSubscriptionFilter, page PageRequest) (Page[models.UserSubscription], error)
+	Get(ctx context.Context, id int) (models.UserSubscription, error)
+	// Create assigns a seat atomically: it fails with ErrNotFound if the
+	// subscription does not exist, ErrSubscriptionNotSeatable if its status
+	// takes no seats, ErrNoLicensesAvailable if it is full and
+	// ErrTrialAlreadyUsed if it is a trial of a product the user trialed before.
+	Create(ctx context.Context, userSubscription *models.UserSubscription) error
+	// Update reassigns a seat with the checks of Create, failing with
+	// ErrNotFound if the seat does not exist and ErrSubscriptionNotFound if
+	// the subscription it moves to does not.
+	Update(ctx context.Context, id int, userSubscription *models.UserSubscription) error
+	Delete(ctx context.Context, id int) error
+	// Assigned lists the seats assigned on a subscription, oldest first.
+	Assigned(ctx context.Context, subscriptionID int) ([]models.UserSubscription, error)
+	// CountAssigned counts the seats assigned on each of the subscriptions;
+	// subscriptions without seats are missing from the result.
+	CountAssigned(ctx context.Context, subscriptionIDs []int) (map[int]int, error)
+}
+
+// InvoiceStore persists invoices and their lines.
+type InvoiceStore interface {
+	// Create stores a draft invoice with its lines. It fails with
+	// ErrInvoiceExists when the period already has an invoice that is not
+	// void, and with ErrNotFound when the subscription does not exist.
+	Create(ctx context.Context, invoice *models.Invoice) error
+	Get(ctx context.Context, id int) (models.Invoice, error)
+	// ListBySubscription lists the invoices of a subscription, oldest first.
+	ListBySubscription(ctx context.Context, subscriptionID int) ([]models.Invoice, error)
+	// Transition moves an invoice to status at now, numbering it when it is
+	// finalized. It fails with ErrInvalidTransition when the move is not allowed.
+	Transition(ctx context.Context, id int, status models.InvoiceStatus, now time.Time) (models.Invoice, error)
+	// UnbilledPlanChanges lists the applied plan changes of a subscription
+	// with a proration that is not on any invoice yet, ignoring void ones.
+	UnbilledPlanChanges(ctx context.Context, subscriptionID int) ([]models.PlanChange, error)
+}
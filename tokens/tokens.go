@@ -0,0 +1,173 @@
+// Package tokens issues short-lived signed entitlement tokens listing what a
+// user may use, so other services can check access offline with package
+// verify.
+package tokens
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"subscriptions/clock"
+	"subscriptions/store"
+	"subscriptions/tokens/verify"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long tokens are valid by default.
+	DefaultTTL = 5 * time.Minute
+	// DefaultIssuer is the iss claim of tokens by default.
+	DefaultIssuer = "subscriptions"
+)
+
+// Key is an Ed25519 signing key and the id it is published under.
+type Key struct {
+	ID      string
+	Private ed25519.PrivateKey
+}
+
+// KeySet holds the signing keys. The first key signs new tokens; the others
+// are only published so tokens they signed keep verifying during a rotation.
+type KeySet struct {
+	keys []Key
+}
+
+// ParseKeys reads a comma separated list of kid=seed pairs, where seed is
+// the base64url encoded 32 byte Ed25519 seed. To rotate, put the new key
+// first and drop the old one once the tokens it signed have expired.
+func ParseKeys(spec string) (*KeySet, error) {
+	set := &KeySet{}
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, encoded, ok := strings.Cut(pair, "=")
+		if !ok || kid == "" {
+			return nil, errors.New("signing keys must be kid=seed pairs")
+		}
+		if seen[kid] {
+			return nil, fmt.Errorf("duplicate signing key id %q", kid)
+		}
+		seen[kid] = true
+		seed, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing key %q must be a base64url encoded %d byte seed", kid, ed25519.SeedSize)
+		}
+		set.keys = append(set.keys, Key{ID: kid, Private: ed25519.NewKeyFromSeed(seed)})
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return set, nil
+}
+
+// GenerateKeys creates a KeySet with a single random key. Tokens it signs
+// stop verifying when the process exits, so it only suits development.
+func GenerateKeys() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := base64.RawURLEncoding.EncodeToString(private.Public().(ed25519.PublicKey)[:8])
+	return &KeySet{keys: []Key{{ID: kid, Private: private}}}, nil
+}
+
+// JWKS returns the public keys of the set.
+func (s *KeySet) JWKS() verify.JWKS {
+	set := verify.JWKS{Keys: make([]verify.JWK, len(s.keys))}
+	for i, key := range s.keys {
+		set.Keys[i] = verify.NewJWK(key.ID, key.Private.Public().(ed25519.PublicKey))
+	}
+	return set
+}
+
+// Key implements verify.KeySource for tokens signed by this process.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return key.Private.Public().(ed25519.PublicKey), nil
+		}
+	}
+	return nil, verify.ErrUnknownKey
+}
+
+// Sign encodes claims as a JWT signed with the first key of the set.
+func (s *KeySet) Sign(claims verify.Claims) (string, error) {
+	key := s.keys[0]
+	header, err := json.Marshal(verify.Header{Algorithm: verify.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(key.Private, []byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Issuer issues entitlement tokens from the seats users hold.
+type Issuer struct {
+	Subscriptions store.SubscriptionStore
+	Keys          *KeySet
+	Clock         clock.Clock
+	// Name is the iss claim
+	Name string
+	TTL  time.Duration
+}
+
+// NewIssuer creates an Issuer with the default name and TTL.
+func NewIssuer(subscriptions store.SubscriptionStore, keys *KeySet) *Issuer {
+	return &Issuer{Subscriptions: subscriptions, Keys: keys, Clock: clock.Real{}, Name: DefaultIssuer, TTL: DefaultTTL}
+}
+
+// Issue signs a token listing the products and subscriptions userID is
+// entitled to now. It expires after the TTL, or earlier when one of the
+// listed subscriptions reaches the end of its period.
+func (i *Issuer) Issue(ctx context.Context, userID int) (string, verify.Claims, error) {
+	now := i.Clock.Now()
+	subscriptions, err := i.Subscriptions.ListForUser(ctx, userID, nil)
+	if err != nil {
+		return "", verify.Claims{}, err
+	}
+
+	expires := now.Add(i.TTL)
+	claims := verify.Claims{
+		Issuer:          i.Name,
+		Subject:         strconv.Itoa(userID),
+		IssuedAt:        now.Unix(),
+		UserID:          userID,
+		ProductIDs:      []int{},
+		SubscriptionIDs: []int{},
+	}
+	products := map[int]bool{}
+	for _, subscription := range subscriptions {
+		if allowed, _ := subscription.Entitles(now); !allowed {
+			continue
+		}
+		claims.SubscriptionIDs = append(claims.SubscriptionIDs, subscription.ID)
+		if !products[subscription.ProductID] {
+			products[subscription.ProductID] = true
+			claims.ProductIDs = append(claims.ProductIDs, subscription.ProductID)
+		}
+		if subscription.CurrentPeriodEnd.Before(expires) {
+			expires = subscription.CurrentPeriodEnd
+		}
+	}
+	sort.Ints(claims.ProductIDs)
+	claims.ExpiresAt = expires.Unix()
+
+	token, err := i.Keys.Sign(claims)
+	return token, claims, err
+}
@@ -0,0 +1,102 @@
+package tokens
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"subscriptions/clock"
+	"subscriptions/models"
+	"subscriptions/store"
+	"subscriptions/tokens/verify"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func seed(b byte) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), ed25519.SeedSize)))
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("2024-06=" + seed('b') + ", 2024-01=" + seed('a'))
+	assert.NoError(t, err)
+	jwks := keys.JWKS()
+	if assert.Len(t, jwks.Keys, 2) {
+		assert.Equal(t, "2024-06", jwks.Keys[0].KeyID)
+		assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+		assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+	}
+
+	for _, spec := range []string{"", "nokid", "=" + seed('a'), "k=short", "k=" + seed('a') + ",k=" + seed('b')} {
+		_, err := ParseKeys(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestIssue(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	assert.NoError(t, s.Organizations().Create(ctx, &models.Organization{Name: "Acme"}))
+	create := func(productID int, event models.SubscriptionEvent) models.Subscription {
+		subscription := models.Subscription{Name: "Team", ProductID: productID, LicenseCount: 5, OrganizationID: 1}
+		assert.NoError(t, s.Subscriptions().Create(ctx, &subscription))
+		assert.NoError(t, s.UserSubscriptions().Create(ctx, &models.UserSubscription{UserID: 7, SubscriptionID: subscription.ID}))
+		if event != "" {
+			_, err := s.Subscriptions().Transition(ctx, subscription.ID, event, time.Now())
+			assert.NoError(t, err)
+		}
+		return subscription
+	}
+	active := create(101, "")
+	create(102, models.EventPause)
+	second := create(101, "")
+
+	keys, err := ParseKeys("k1=" + seed('a'))
+	assert.NoError(t, err)
+	issuer := NewIssuer(s.Subscriptions(), keys)
+	now := time.Now().Truncate(time.Second)
+	issuer.Clock = clock.NewFake(now)
+
+	token, claims, err := issuer.Issue(ctx, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{101}, claims.ProductIDs, "paused subscriptions grant nothing")
+	assert.Equal(t, []int{active.ID, second.ID}, claims.SubscriptionIDs)
+	assert.Equal(t, now.Add(DefaultTTL).Unix(), claims.ExpiresAt)
+
+	verified, err := verify.NewVerifier(keys, DefaultIssuer).Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, claims, verified)
+	assert.True(t, verified.HasProduct(101))
+	assert.False(t, verified.HasProduct(102))
+
+	// Close to the period end the token expires with the period
+	issuer.Clock = clock.NewFake(active.CurrentPeriodEnd.Add(-time.Minute))
+	_, claims, err = issuer.Issue(ctx, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, active.CurrentPeriodEnd.Unix(), claims.ExpiresAt)
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	old, err := ParseKeys("old=" + seed('a'))
+	assert.NoError(t, err)
+	token, err := old.Sign(verify.Claims{Issuer: DefaultIssuer, UserID: 7, IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	rotated, err := ParseKeys("new=" + seed('b') + ",old=" + seed('a'))
+	assert.NoError(t, err)
+	_, err = verify.NewVerifier(rotated, DefaultIssuer).Verify(ctx, token)
+	assert.NoError(t, err, "tokens signed before the rotation still verify")
+
+	newToken, err := rotated.Sign(verify.Claims{Issuer: DefaultIssuer, IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+	_, err = verify.NewVerifier(old, DefaultIssuer).Verify(ctx, newToken)
+	assert.ErrorIs(t, err, verify.ErrUnknownKey)
+
+	retired, err := ParseKeys("new=" + seed('b'))
+	assert.NoError(t, err)
+	_, err = verify.NewVerifier(retired, DefaultIssuer).Verify(ctx, token)
+	assert.ErrorIs(t, err, verify.ErrUnknownKey, "retired keys no longer verify")
+}
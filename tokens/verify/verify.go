@@ -0,0 +1,417 @@
+// Package verify checks entitlement tokens issued by the subscriptions
+// service without calling it for every request. Tokens are JWTs signed with
+// Ed25519 (alg EdDSA); the public keys are published as a JWKS document.
+// The package only depends on the standard library so other services can
+// import it cheaply.
+//
+// VerifyInto also checks JWTs of other issuers signed with RS256 or ES256,
+// which the service uses to authenticate callers.
+package verify
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Algorithm is the JWS alg of entitlement tokens.
+const Algorithm = "EdDSA"
+
+// Other JWS algs accepted by VerifyInto. The alg must match the type of the
+// key the kid names, so a token cannot pick a weaker check for itself.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	// ErrMalformed is returned for tokens that are not well-formed JWTs.
+	ErrMalformed = errors.New("malformed token")
+	// ErrUnknownKey is returned when no key is known for the token's kid.
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrSignature is returned when the signature does not match.
+	ErrSignature = errors.New("invalid token signature")
+	// ErrExpired is returned for tokens past their exp or before their iat.
+	ErrExpired = errors.New("token expired")
+	// ErrIssuer is returned when the token was issued by someone else.
+	ErrIssuer = errors.New("unexpected token issuer")
+	// ErrAudience is returned when the token is meant for someone else.
+	ErrAudience = errors.New("unexpected token audience")
+)
+
+// Claims are the contents of an entitlement token.
+type Claims struct {
+	Issuer string `json:"iss"`
+	// Subject is the user id as a string, as JWT requires
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	UserID    int    `json:"user_id"`
+	// ProductIDs and SubscriptionIDs are what the user was entitled to when
+	// the token was issued
+	ProductIDs      []int `json:"product_ids"`
+	SubscriptionIDs []int `json:"subscription_ids"`
+}
+
+// HasProduct reports whether the claims entitle the user to productID.
+func (c Claims) HasProduct(productID int) bool {
+	for _, id := range c.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// Header is the JOSE header of an entitlement token.
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// JWK is a public key in JSON Web Key form: an Ed25519 (OKP), P-256 (EC)
+// or RSA key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+}
+
+// NewJWK describes public as a JWK with id kid.
+func NewJWK(kid string, public ed25519.PublicKey) JWK {
+	return JWK{KeyType: "OKP", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public), KeyID: kid, Use: "sig", Algorithm: Algorithm}
+}
+
+// PublicKey decodes the key into an ed25519.PublicKey, *ecdsa.PublicKey or
+// *rsa.PublicKey. Keys of other types fail.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 public key")
+		}
+		// ecdh rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, errors.New("invalid P-256 public key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case k.KeyType == "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA public key (at least 2048 bits)")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s/%s", k.KeyType, k.Curve)
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySource resolves the public key a token names in its kid header.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// StaticKeys is a KeySource over a fixed set of keys.
+type StaticKeys map[string]crypto.PublicKey
+
+func (k StaticKeys) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := k[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// RemoteKeys is a KeySource fetching a JWKS document over HTTP. Keys are
+// cached for Refresh; an unknown kid triggers an early refetch, at most once
+// per MinRefresh, so rotated keys are picked up without a restart.
+type RemoteKeys struct {
+	URL        string
+	Client     *http.Client
+	Refresh    time.Duration
+	MinRefresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// refreshing is the JWKS fetch in progress, shared by concurrent callers
+	refreshing *keysFetch
+}
+
+// keysFetch is a JWKS fetch in progress; waiters block on done.
+type keysFetch struct {
+	done chan struct{}
+	keys map[string]crypto.PublicKey
+	err  error
+}
+
+// NewRemoteKeys fetches keys from url, refreshing them every 10 minutes.
+func NewRemoteKeys(url string) *RemoteKeys {
+	return &RemoteKeys{URL: url, Client: &http.Client{Timeout: 5 * time.Second}, Refresh: 10 * time.Minute, MinRefresh: 30 * time.Second}
+}
+
+func (r *RemoteKeys) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	age := time.Since(r.fetched)
+	key, ok := r.keys[kid]
+	if ok && age < r.Refresh {
+		r.mu.Unlock()
+		return key, nil
+	}
+	if r.keys != nil && !ok && age < r.MinRefresh {
+		r.mu.Unlock()
+		return nil, ErrUnknownKey
+	}
+	call := r.refresh(ctx)
+	r.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		if ok {
+			// Keep serving the key we had while the JWKS endpoint is down
+			return key, nil
+		}
+		return nil, call.err
+	}
+	if key, ok = call.keys[kid]; !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// refresh returns the JWKS fetch in progress, starting one if needed. The
+// fetch runs without r.mu, so keys already cached are served meanwhile, and
+// outlives the request that triggered it so other waiters still get its
+// result. Callers must hold r.mu.
+func (r *RemoteKeys) refresh(ctx context.Context) *keysFetch {
+	if r.refreshing != nil {
+		return r.refreshing
+	}
+	call := &keysFetch{done: make(chan struct{})}
+	r.refreshing = call
+
+	go func() {
+		keys, err := r.fetch(context.WithoutCancel(ctx))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.refreshing = nil
+		if err == nil {
+			r.keys, r.fetched = keys, time.Now()
+		}
+		call.keys, call.err = keys, err
+		close(call.done)
+	}()
+	return call
+}
+
+func (r *RemoteKeys) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	return keys, nil
+}
+
+// Verifier checks entitlement tokens.
+type Verifier struct {
+	Keys KeySource
+	// Issuer, when set, must match the iss claim
+	Issuer string
+	// Audience, when set, must be one of the aud claim
+	Audience string
+	// Leeway tolerates clock skew between issuer and verifier
+	Leeway time.Duration
+	now    func() time.Time
+}
+
+// NewVerifier creates a Verifier trusting keys for tokens issued by issuer.
+func NewVerifier(keys KeySource, issuer string) *Verifier {
+	return &Verifier{Keys: keys, Issuer: issuer, Leeway: 30 * time.Second, now: time.Now}
+}
+
+// Verify checks the signature, issuer and lifetime of token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	var claims Claims
+	err := v.VerifyInto(ctx, token, &claims)
+	return claims, err
+}
+
+// VerifyInto checks the signature, issuer, audience and lifetime of token
+// and decodes its claims into claims.
+func (v *Verifier) VerifyInto(ctx context.Context, token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Algorithm != Algorithm && header.Algorithm != RS256 && header.Algorithm != ES256 {
+		return fmt.Errorf("%w: unsupported alg %q", ErrMalformed, header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	key, err := v.Keys.Key(ctx, header.KeyID)
+	if err != nil {
+		return err
+	}
+	if !verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature) {
+		return ErrSignature
+	}
+
+	var registered registeredClaims
+	if err := decodeSegment(parts[1], &registered); err != nil {
+		return err
+	}
+	if v.Issuer != "" && registered.Issuer != v.Issuer {
+		return ErrIssuer
+	}
+	if v.Audience != "" && !registered.Audience.contains(v.Audience) {
+		return ErrAudience
+	}
+	now := time.Now
+	if v.now != nil {
+		now = v.now
+	}
+	t := now()
+	if !t.Before(unixTime(registered.ExpiresAt).Add(v.Leeway)) ||
+		t.Add(v.Leeway).Before(unixTime(registered.IssuedAt)) ||
+		t.Add(v.Leeway).Before(unixTime(registered.NotBefore)) {
+		return ErrExpired
+	}
+	return decodeSegment(parts[1], claims)
+}
+
+// registeredClaims are the claims VerifyInto checks. Times are float64 as
+// JWT allows fractional seconds.
+type registeredClaims struct {
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	IssuedAt  float64  `json:"iat"`
+	NotBefore float64  `json:"nbf"`
+	ExpiresAt float64  `json:"exp"`
+}
+
+// audience is the aud claim, which is either a string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(want string) bool {
+	for _, aud := range a {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// verifySignature checks signature with key, which must be of the type alg
+// calls for.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return alg == Algorithm && ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return alg == RS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if alg != ES256 || key.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
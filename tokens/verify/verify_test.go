@@ -0,0 +1,212 @@
+package verify_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subscriptions/tokens"
+	"subscriptions/tokens/verify"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func keySet(t *testing.T, kid string, b byte) *tokens.KeySet {
+	t.Helper()
+	keys, err := tokens.ParseKeys(kid + "=" + base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32))))
+	assert.NoError(t, err)
+	return keys
+}
+
+func sign(t *testing.T, keys *tokens.KeySet, claims verify.Claims) string {
+	t.Helper()
+	token, err := keys.Sign(claims)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	keys := keySet(t, "k1", 'a')
+	verifier := verify.NewVerifier(keys, "subscriptions")
+	now := time.Now()
+	valid := verify.Claims{Issuer: "subscriptions", UserID: 7, ProductIDs: []int{101}, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+
+	claims, err := verifier.Verify(ctx, sign(t, keys, valid))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, claims.UserID)
+
+	expired := valid
+	expired.ExpiresAt = now.Add(-time.Minute).Unix()
+	_, err = verifier.Verify(ctx, sign(t, keys, expired))
+	assert.ErrorIs(t, err, verify.ErrExpired)
+
+	foreign := valid
+	foreign.Issuer = "someone-else"
+	_, err = verifier.Verify(ctx, sign(t, keys, foreign))
+	assert.ErrorIs(t, err, verify.ErrIssuer)
+
+	// A token whose claims were edited after signing
+	parts := strings.Split(sign(t, keys, valid), ".")
+	tampered, _ := json.Marshal(verify.Claims{Issuer: "subscriptions", UserID: 8, IssuedAt: valid.IssuedAt, ExpiresAt: valid.ExpiresAt})
+	parts[1] = base64.RawURLEncoding.EncodeToString(tampered)
+	_, err = verifier.Verify(ctx, strings.Join(parts, "."))
+	assert.ErrorIs(t, err, verify.ErrSignature)
+
+	_, err = verifier.Verify(ctx, sign(t, keySet(t, "k1", 'b'), valid))
+	assert.ErrorIs(t, err, verify.ErrSignature, "same kid, different key")
+
+	for _, token := range []string{"", "a.b", "a.b.c", "!!.e30.sig"} {
+		_, err = verifier.Verify(ctx, token)
+		assert.ErrorIs(t, err, verify.ErrMalformed, token)
+	}
+}
+
+// signWith encodes claims as a JWT signed by an RS256 or ES256 key, as an
+// identity provider would.
+func signWith(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(verify.Header{Algorithm: alg, Type: "JWT", KeyID: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		assert.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyInto(t *testing.T) {
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	keys := verify.StaticKeys{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	verifier := &verify.Verifier{Keys: keys, Issuer: "https://idp.example.com", Audience: "subscriptions"}
+	now := time.Now().Unix()
+	claims := map[string]interface{}{"iss": "https://idp.example.com", "aud": []string{"other", "subscriptions"}, "sub": "42", "iat": now, "exp": now + 60}
+
+	var got struct {
+		Subject string `json:"sub"`
+	}
+	assert.NoError(t, verifier.VerifyInto(ctx, signWith(t, verify.RS256, "rsa", rsaKey, claims), &got))
+	assert.Equal(t, "42", got.Subject)
+	assert.NoError(t, verifier.VerifyInto(ctx, signWith(t, verify.ES256, "ec", ecKey, claims), &got))
+
+	assert.ErrorIs(t, verifier.VerifyInto(ctx, signWith(t, verify.ES256, "rsa", rsaKey, claims), &got), verify.ErrSignature, "alg must match the key")
+
+	claims["aud"] = "billing"
+	assert.ErrorIs(t, verifier.VerifyInto(ctx, signWith(t, verify.RS256, "rsa", rsaKey, claims), &got), verify.ErrAudience)
+	claims["aud"] = "subscriptions"
+	claims["nbf"] = now + 600
+	assert.ErrorIs(t, verifier.VerifyInto(ctx, signWith(t, verify.RS256, "rsa", rsaKey, claims), &got), verify.ErrExpired, "not valid yet")
+
+	// JWKS forms of the keys decode to the same keys
+	n := base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes())
+	decoded, err := verify.JWK{KeyType: "RSA", N: n, E: "AQAB"}.PublicKey()
+	assert.NoError(t, err)
+	assert.True(t, rsaKey.PublicKey.Equal(decoded))
+	x := base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32)))
+	y := base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))
+	decoded, err = verify.JWK{KeyType: "EC", Curve: "P-256", X: x, Y: y}.PublicKey()
+	assert.NoError(t, err)
+	assert.True(t, ecKey.PublicKey.Equal(decoded))
+	_, err = verify.JWK{KeyType: "EC", Curve: "P-256", X: x, Y: x}.PublicKey()
+	assert.Error(t, err, "point not on the curve")
+}
+
+func TestRemoteKeys(t *testing.T) {
+	ctx := context.Background()
+	current := keySet(t, "k1", 'a')
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(current.JWKS())
+	}))
+	defer server.Close()
+
+	remote := verify.NewRemoteKeys(server.URL)
+	remote.MinRefresh = 0
+	verifier := verify.NewVerifier(remote, "subscriptions")
+	claims := verify.Claims{Issuer: "subscriptions", IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(ctx, sign(t, current, claims))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), fetches.Load(), "keys are cached")
+
+	// After a rotation the new kid is unknown and triggers a refetch
+	current = keySet(t, "k2", 'b')
+	_, err := verifier.Verify(ctx, sign(t, current, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), fetches.Load())
+
+	_, err = verifier.Verify(ctx, sign(t, keySet(t, "k3", 'c'), claims))
+	assert.ErrorIs(t, err, verify.ErrUnknownKey)
+}
+
+func TestRemoteKeysFetchDoesNotBlockCachedKeys(t *testing.T) {
+	ctx := context.Background()
+	current := keySet(t, "k1", 'a')
+	var fetches atomic.Int32
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			requested <- struct{}{}
+			<-release
+		}
+		json.NewEncoder(w).Encode(current.JWKS())
+	}))
+	defer server.Close()
+
+	remote := verify.NewRemoteKeys(server.URL)
+	remote.MinRefresh = 0
+	_, err := remote.Key(ctx, "k1")
+	assert.NoError(t, err)
+
+	// An unknown kid triggers a slow refetch
+	refetched := make(chan error, 1)
+	go func() {
+		_, err := remote.Key(ctx, "k2")
+		refetched <- err
+	}()
+	<-requested
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := remote.Key(ctx, "k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("a cached key waited for the JWKS fetch")
+	}
+
+	close(release)
+	assert.ErrorIs(t, <-refetched, verify.ErrUnknownKey)
+	assert.Equal(t, int32(2), fetches.Load())
+}